@@ -0,0 +1,76 @@
+package grid
+
+// Direction is a compass heading on the grid. North points towards y-1 and
+// East towards x+1, matching the row-major cell layout.
+type Direction int
+
+const (
+	NoDirection Direction = iota
+	North
+	East
+	South
+	West
+)
+
+// Directions lists the four headings in clockwise order.
+var Directions = [4]Direction{North, East, South, West}
+
+// Delta returns the (dx,dy) step for one move in direction d.
+func (d Direction) Delta() (int, int) {
+	switch d {
+	case North:
+		return 0, -1
+	case East:
+		return 1, 0
+	case South:
+		return 0, 1
+	case West:
+		return -1, 0
+	}
+	return 0, 0
+}
+
+// Opposite returns the reverse heading.
+func (d Direction) Opposite() Direction {
+	switch d {
+	case North:
+		return South
+	case East:
+		return West
+	case South:
+		return North
+	case West:
+		return East
+	}
+	return NoDirection
+}
+
+func (d Direction) String() string {
+	switch d {
+	case North:
+		return "north"
+	case East:
+		return "east"
+	case South:
+		return "south"
+	case West:
+		return "west"
+	}
+	return "none"
+}
+
+// DirectionOf returns the heading of a unit step (dx,dy), or NoDirection if
+// the step is not a single orthogonal move.
+func DirectionOf(dx, dy int) Direction {
+	switch {
+	case dx == 0 && dy == -1:
+		return North
+	case dx == 1 && dy == 0:
+		return East
+	case dx == 0 && dy == 1:
+		return South
+	case dx == -1 && dy == 0:
+		return West
+	}
+	return NoDirection
+}
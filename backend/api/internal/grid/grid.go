@@ -1,19 +1,10 @@
 package grid
 
-// LightState represents the state of a traffic light at an intersection.
-type LightState string
-
-const (
-	LightRed    LightState = "red"
-	LightYellow LightState = "yellow"
-	LightGreen  LightState = "green"
-)
-
 // Intersection represents a major intersection location in the grid.
 type Intersection struct {
-	X          int
-	Y          int
-	LightState LightState
+	X     int
+	Y     int
+	Turns TurnRules // movement rules for vehicles passing through
 }
 
 // Cell represents a single cell in the traffic grid.
@@ -38,6 +29,27 @@ func NewGrid(width, height int) *Grid {
 	return g
 }
 
+// NewGridWithIntersections constructs a grid of Width x Height with the given
+// intersections instead of the default four. Points outside the grid and
+// repeated points are skipped.
+func NewGridWithIntersections(width, height int, intersections []Intersection) *Grid {
+	g := &Grid{Width: width, Height: height}
+	g.buildCells()
+	seen := make(map[[2]int]bool, len(intersections))
+	for _, it := range intersections {
+		k := [2]int{it.X, it.Y}
+		if !g.IsValid(it.X, it.Y) || seen[k] {
+			continue
+		}
+		if it.Turns.Validate() != nil {
+			it.Turns = TurnRules{}
+		}
+		seen[k] = true
+		g.intersections = append(g.intersections, it)
+	}
+	return g
+}
+
 func (g *Grid) buildCells() {
 	total := g.Width * g.Height
 	g.cells = make([]Cell, 0, total)
@@ -56,7 +68,7 @@ func (g *Grid) seedDefaultIntersections() {
 	for _, p := range defaults {
 		x, y := p[0], p[1]
 		if g.IsValid(x, y) {
-			g.intersections = append(g.intersections, Intersection{X: x, Y: y, LightState: LightRed})
+			g.intersections = append(g.intersections, Intersection{X: x, Y: y})
 		}
 	}
 }
@@ -87,6 +99,21 @@ func (g *Grid) FromID(id int) (int, int) {
 	return x, y
 }
 
+// SetTurnRules sets the movement rules of the intersection at (x,y).
+// Returns false if there is no intersection there or the rules are invalid.
+func (g *Grid) SetTurnRules(x, y int, rules TurnRules) bool {
+	if rules.Validate() != nil {
+		return false
+	}
+	for i := range g.intersections {
+		if g.intersections[i].X == x && g.intersections[i].Y == y {
+			g.intersections[i].Turns = rules
+			return true
+		}
+	}
+	return false
+}
+
 // Intersections returns a copy of the seeded intersections for safety.
 func (g *Grid) Intersections() []Intersection {
 	out := make([]Intersection, len(g.intersections))
@@ -0,0 +1,75 @@
+package grid_test
+
+import (
+	"math"
+	"testing"
+
+	grid "routeiq/internal/grid"
+)
+
+func TestDirection_DeltaRoundTrip(t *testing.T) {
+	for _, d := range grid.Directions {
+		dx, dy := d.Delta()
+		if got := grid.DirectionOf(dx, dy); got != d {
+			t.Fatalf("DirectionOf(%d,%d) = %s, want %s", dx, dy, got, d)
+		}
+		ox, oy := d.Opposite().Delta()
+		if ox != -dx || oy != -dy {
+			t.Fatalf("opposite of %s should reverse its delta", d)
+		}
+	}
+	if grid.DirectionOf(1, 1) != grid.NoDirection {
+		t.Fatalf("expected diagonal step to have no direction")
+	}
+}
+
+func TestTurnBetween(t *testing.T) {
+	cases := []struct {
+		in, out grid.Direction
+		want    grid.Turn
+	}{
+		{grid.North, grid.North, grid.Straight},
+		{grid.North, grid.East, grid.Right},
+		{grid.North, grid.West, grid.Left},
+		{grid.North, grid.South, grid.UTurn},
+		{grid.West, grid.North, grid.Right},
+		{grid.West, grid.South, grid.Left},
+		{grid.NoDirection, grid.East, grid.Straight},
+	}
+	for _, c := range cases {
+		if got := grid.TurnBetween(c.in, c.out); got != c.want {
+			t.Fatalf("TurnBetween(%s,%s) = %s, want %s", c.in, c.out, got, c.want)
+		}
+		if c.in != grid.NoDirection {
+			if got := c.in.Turned(c.want); got != c.out {
+				t.Fatalf("%s.Turned(%s) = %s, want %s", c.in, c.want, got, c.out)
+			}
+		}
+	}
+}
+
+func TestSetTurnRules(t *testing.T) {
+	g := grid.NewGrid(20, 20)
+	rules := grid.TurnRules{Prohibited: map[grid.Turn]bool{grid.Left: true}}
+	if !g.SetTurnRules(5, 5, rules) {
+		t.Fatalf("expected rules to be set on intersection (5,5)")
+	}
+	if g.SetTurnRules(1, 1, rules) {
+		t.Fatalf("expected no intersection at (1,1)")
+	}
+	for _, it := range g.Intersections() {
+		if it.X == 5 && it.Y == 5 && it.Turns.Allows(grid.Left) {
+			t.Fatalf("expected left turn prohibited at (5,5)")
+		}
+	}
+	for _, cost := range []float64{-1, math.NaN(), math.Inf(1)} {
+		bad := grid.TurnRules{Penalty: map[grid.Turn]float64{grid.Right: cost}}
+		if bad.Validate() == nil || g.SetTurnRules(5, 5, bad) {
+			t.Fatalf("expected a turn penalty of %v to be refused", cost)
+		}
+		built := grid.NewGridWithIntersections(10, 10, []grid.Intersection{{X: 5, Y: 5, Turns: bad}})
+		if its := built.Intersections(); len(its) != 1 || !its[0].Turns.Empty() {
+			t.Fatalf("expected the intersection kept without its invalid rules, got %+v", its)
+		}
+	}
+}
@@ -24,9 +24,6 @@ func TestIntersections_Placement(t *testing.T) {
 	got := map[[2]int]bool{}
 	for _, it := range g.Intersections() {
 		got[[2]int{it.X, it.Y}] = true
-		if it.LightState != grid.LightRed {
-			t.Fatalf("expected default light state red, got %s", it.LightState)
-		}
 	}
 	for k := range expected {
 		if !got[k] {
@@ -73,3 +70,23 @@ func TestBoundsChecks(t *testing.T) {
 		t.Fatalf("FromID(400) should return (-1,-1), got (%d,%d)", x, y)
 	}
 }
+
+func TestNewGridWithIntersections_SkipsInvalidAndDuplicates(t *testing.T) {
+	noLeft := grid.TurnRules{Prohibited: map[grid.Turn]bool{grid.Left: true}}
+	g := grid.NewGridWithIntersections(10, 10, []grid.Intersection{
+		{X: 2, Y: 2},
+		{X: 2, Y: 2, Turns: noLeft},
+		{X: 12, Y: 3},
+		{X: 7, Y: 4, Turns: noLeft},
+	})
+	its := g.Intersections()
+	if len(its) != 2 {
+		t.Fatalf("expected 2 intersections, got %d", len(its))
+	}
+	if !its[0].Turns.Allows(grid.Left) || its[1].Turns.Allows(grid.Left) {
+		t.Fatalf("expected the first of the duplicates kept, got %+v", its)
+	}
+	if len(grid.NewGridWithIntersections(10, 10, nil).Intersections()) != 0 {
+		t.Fatalf("expected no intersections from an empty list")
+	}
+}
@@ -0,0 +1,103 @@
+package grid
+
+import (
+	"fmt"
+	"math"
+)
+
+// Turn classifies a movement through a cell by how the heading changes.
+type Turn int
+
+const (
+	Straight Turn = iota
+	Right
+	Left
+	UTurn
+)
+
+func (t Turn) String() string {
+	switch t {
+	case Straight:
+		return "straight"
+	case Right:
+		return "right"
+	case Left:
+		return "left"
+	case UTurn:
+		return "uturn"
+	}
+	return "unknown"
+}
+
+// TurnBetween classifies the movement of a vehicle that arrives heading in
+// and leaves heading out. Either heading being NoDirection counts as Straight.
+func TurnBetween(in, out Direction) Turn {
+	if in == NoDirection || out == NoDirection {
+		return Straight
+	}
+	switch (int(out) - int(in) + 4) % 4 {
+	case 0:
+		return Straight
+	case 1:
+		return Right
+	case 2:
+		return UTurn
+	}
+	return Left
+}
+
+// Turned returns the heading after making turn t while travelling in d.
+func (d Direction) Turned(t Turn) Direction {
+	if d == NoDirection {
+		return NoDirection
+	}
+	i := int(d - North) // index into Directions
+	switch t {
+	case Right:
+		return Directions[(i+1)%4]
+	case Left:
+		return Directions[(i+3)%4]
+	case UTurn:
+		return d.Opposite()
+	}
+	return d
+}
+
+// TurnRules restrict and price the movements made through an intersection.
+// The zero value allows every turn at no extra cost.
+type TurnRules struct {
+	Prohibited map[Turn]bool    // turns that may not be made, e.g. no left turn
+	Penalty    map[Turn]float64 // extra routing cost for making a turn
+}
+
+// Allows reports whether turn t may be made.
+func (r TurnRules) Allows(t Turn) bool { return !r.Prohibited[t] }
+
+// Cost returns the extra routing cost of making turn t.
+func (r TurnRules) Cost(t Turn) float64 { return r.Penalty[t] }
+
+// Validate checks every penalty is a finite cost of at least zero: a negative
+// one would make a turn cheaper than free, which routing cannot price.
+func (r TurnRules) Validate() error {
+	for t, c := range r.Penalty {
+		if !(c >= 0) || math.IsInf(c, 1) {
+			return fmt.Errorf("turn penalty for %s must be a finite non-negative cost, got %g", t, c)
+		}
+	}
+	return nil
+}
+
+// Empty reports whether the rules neither prohibit nor penalise any turn.
+func (r TurnRules) Empty() bool {
+	for _, banned := range r.Prohibited {
+		if banned {
+			return false
+		}
+	}
+	for _, c := range r.Penalty {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,480 @@
+package osm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultCellSize is the side of a grid cell in metres. Vehicles cross at
+// most one cell per simulated second, so 25 m cells give a top speed of
+// 90 km/h.
+const DefaultCellSize = 25.0
+
+// MaxSize bounds the width and height of an imported grid.
+const MaxSize = 1000
+
+// DrivableHighways are the highway=* values imported by default.
+var DrivableHighways = []string{
+	"motorway", "motorway_link", "trunk", "trunk_link", "primary", "primary_link",
+	"secondary", "secondary_link", "tertiary", "tertiary_link", "unclassified",
+	"residential", "living_street", "service", "road",
+}
+
+// defaultSpeeds is the speed in km/h assumed for roads without a usable
+// maxspeed tag, by highway type; link roads take their parent's.
+var defaultSpeeds = map[string]float64{
+	"motorway": 110, "trunk": 90, "primary": 60, "secondary": 50, "tertiary": 50,
+	"unclassified": 40, "residential": 30, "living_street": 10, "service": 20, "road": 40,
+}
+
+// Options control how an extract is converted.
+type Options struct {
+	CellSize     float64  // metres per cell side; DefaultCellSize if zero
+	Bounds       Bounds   // area to import; the file's bounds, or else the extent of its roads, if empty
+	Highways     []string // highway=* values to import; DrivableHighways if empty
+	SignalRadius float64  // metres within which a signal is moved onto a junction; 1.5 cells if zero
+}
+
+// Load reads an extract with ReadFile and converts it.
+func Load(path string, opts Options) (*Network, error) {
+	d, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	n, err := Convert(d, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return n, nil
+}
+
+// Convert rasterises the roads of d onto a grid. Each way becomes the
+// 4-connected run of cells its line crosses, and vehicles may only step
+// between neighbouring cells along a road, in the road's direction if it is
+// one-way. A cell's speed limit is the fastest of its roads' maxspeed tags.
+// Traffic signal nodes become intersections, moved onto the nearest
+// junction within SignalRadius since mappers often tag the stop lines
+// rather than the junction. Cells that cannot be left, such as one-way
+// roads cut off by the bounds, are removed.
+func Convert(d *Data, opts Options) (*Network, error) {
+	if opts.CellSize == 0 {
+		opts.CellSize = DefaultCellSize
+	}
+	if opts.CellSize < 0 || math.IsNaN(opts.CellSize) {
+		return nil, fmt.Errorf("cell size must be positive, got %g", opts.CellSize)
+	}
+	if opts.SignalRadius <= 0 {
+		opts.SignalRadius = 1.5 * opts.CellSize
+	}
+	highways := opts.Highways
+	if len(highways) == 0 {
+		highways = DrivableHighways
+	}
+	include := make(map[string]bool, len(highways))
+	for _, h := range highways {
+		include[h] = true
+	}
+	var ways []Way
+	for _, w := range d.Ways {
+		if include[w.Tags["highway"]] && drivable(w.Tags) && len(w.Nodes) > 1 {
+			ways = append(ways, w)
+		}
+	}
+	if len(ways) == 0 {
+		return nil, errors.New("no roads to import")
+	}
+
+	bounds := opts.Bounds
+	if bounds.Empty() {
+		bounds = d.Bounds
+	}
+	if bounds.Empty() {
+		bounds = extent(d, ways)
+	}
+	ref := GeoRef{Bounds: bounds, CellSize: opts.CellSize}
+	w, h := ref.size()
+	if w > MaxSize || h > MaxSize {
+		return nil, fmt.Errorf("the area needs a %dx%d grid of %g m cells, more than %d a side; use larger cells or a smaller area",
+			w, h, opts.CellSize, MaxSize)
+	}
+	c := converter{
+		net: &Network{
+			Width: w, Height: h, Ref: ref,
+			Roads:       make(map[[2]int]bool),
+			SpeedLimits: make(map[[2]int]float64),
+		},
+		allowed: make(map[[4]int]bool),
+		refs:    make(map[int64]int),
+	}
+	for _, way := range ways {
+		c.addWay(d, way)
+	}
+	c.prune()
+	c.addSignals(d, opts.SignalRadius)
+	c.closeEdges()
+	return c.net, nil
+}
+
+// drivable reports whether the tags of a highway let motor vehicles use it.
+func drivable(tags map[string]string) bool {
+	if tags["area"] == "yes" {
+		return false
+	}
+	for _, k := range []string{"access", "vehicle", "motor_vehicle"} {
+		if v := tags[k]; v == "no" || v == "private" {
+			return false
+		}
+	}
+	return true
+}
+
+// extent is the box around every node of ways.
+func extent(d *Data, ways []Way) Bounds {
+	b := Bounds{MinLat: math.Inf(1), MinLon: math.Inf(1), MaxLat: math.Inf(-1), MaxLon: math.Inf(-1)}
+	for _, w := range ways {
+		for _, id := range w.Nodes {
+			n, ok := d.Nodes[id]
+			if !ok {
+				continue
+			}
+			b.MinLat, b.MaxLat = min(b.MinLat, n.Lat), max(b.MaxLat, n.Lat)
+			b.MinLon, b.MaxLon = min(b.MinLon, n.Lon), max(b.MaxLon, n.Lon)
+		}
+	}
+	if math.IsInf(b.MinLat, 1) {
+		return Bounds{}
+	}
+	return b
+}
+
+type converter struct {
+	net     *Network
+	allowed map[[4]int]bool // directed moves some road makes
+	refs    map[int64]int   // node ID -> number of imported ways through it
+}
+
+func (c *converter) inGrid(p [2]int) bool {
+	return p[0] >= 0 && p[0] < c.net.Width && p[1] >= 0 && p[1] < c.net.Height
+}
+
+func (c *converter) addWay(d *Data, w Way) {
+	dir := oneway(w.Tags)
+	limit := min(speedOf(w.Tags)/3.6/c.net.Ref.CellSize, 1)
+	seen := make(map[int64]bool, len(w.Nodes))
+	var chain [][2]int // cells of the current run of the road inside the grid
+	var px, py float64
+	prev := false // the previous node is in the file
+	for _, id := range w.Nodes {
+		n, ok := d.Nodes[id]
+		if !ok {
+			c.addChain(chain, dir, limit)
+			chain, prev = nil, false
+			continue
+		}
+		if !seen[id] {
+			seen[id] = true
+			c.refs[id]++
+		}
+		x, y := c.net.Ref.Project(n.Lat, n.Lon)
+		switch {
+		case !prev:
+			if p := cellOf(x, y); c.inGrid(p) {
+				chain = [][2]int{p}
+			}
+		default:
+			// only the part of the segment over the grid is walked, so
+			// far-away nodes cost nothing
+			x0, y0, x1, y1, ok := clip(px, py, x, y, float64(c.net.Width), float64(c.net.Height))
+			if !ok {
+				c.addChain(chain, dir, limit)
+				chain = nil
+				break
+			}
+			if chain == nil || x0 != px || y0 != py {
+				c.addChain(chain, dir, limit)
+				chain = [][2]int{cellOf(x0, y0)}
+			}
+			chain = traverse(chain, x0, y0, x1, y1)
+		}
+		px, py, prev = x, y, true
+	}
+	c.addChain(chain, dir, limit)
+	c.net.Ways++
+}
+
+// addChain marks the cells of a road and the moves along it.
+func (c *converter) addChain(cells [][2]int, dir int, limit float64) {
+	for i, p := range cells {
+		if !c.inGrid(p) {
+			continue
+		}
+		c.net.Roads[p] = true
+		if l, ok := c.net.SpeedLimits[p]; !ok || limit > l {
+			c.net.SpeedLimits[p] = limit
+		}
+		if i == 0 || !c.inGrid(cells[i-1]) {
+			continue
+		}
+		a := cells[i-1]
+		if dir >= 0 {
+			c.allowed[[4]int{a[0], a[1], p[0], p[1]}] = true
+		}
+		if dir <= 0 {
+			c.allowed[[4]int{p[0], p[1], a[0], a[1]}] = true
+		}
+	}
+}
+
+// neighbours are the unit steps between cells.
+var neighbours = [4][2]int{{0, -1}, {1, 0}, {0, 1}, {-1, 0}}
+
+// exits counts the road cells a vehicle at p may step to.
+func (c *converter) exits(p [2]int) int {
+	n := 0
+	for _, s := range neighbours {
+		q := [2]int{p[0] + s[0], p[1] + s[1]}
+		if c.net.Roads[q] && c.allowed[[4]int{p[0], p[1], q[0], q[1]}] {
+			n++
+		}
+	}
+	return n
+}
+
+// prune removes cells vehicles can never leave, and then any cells that
+// only led into them.
+func (c *converter) prune() {
+	work := make([][2]int, 0, len(c.net.Roads))
+	for p := range c.net.Roads {
+		work = append(work, p)
+	}
+	for len(work) > 0 {
+		p := work[len(work)-1]
+		work = work[:len(work)-1]
+		if !c.net.Roads[p] {
+			continue
+		}
+		if c.exits(p) > 0 {
+			continue
+		}
+		delete(c.net.Roads, p)
+		delete(c.net.SpeedLimits, p)
+		c.net.Pruned++
+		for _, s := range neighbours {
+			work = append(work, [2]int{p[0] + s[0], p[1] + s[1]})
+		}
+	}
+	for p, l := range c.net.SpeedLimits {
+		if l >= 1 {
+			delete(c.net.SpeedLimits, p)
+		}
+	}
+}
+
+// addSignals places an intersection for every traffic signal on an imported
+// road, at the nearest junction within radius metres if there is one.
+func (c *converter) addSignals(d *Data, radius float64) {
+	ref := c.net.Ref
+	junctions := make(map[[2]int][]Node)
+	for id, count := range c.refs {
+		if count > 1 {
+			n := d.Nodes[id]
+			x, y := ref.Cell(n.Lat, n.Lon)
+			junctions[[2]int{x, y}] = append(junctions[[2]int{x, y}], n)
+		}
+	}
+	for _, js := range junctions {
+		sort.Slice(js, func(i, j int) bool { return js[i].ID < js[j].ID })
+	}
+	reach := int(math.Ceil(radius / ref.CellSize))
+	placed := make(map[[2]int]bool)
+	for id := range c.refs {
+		n := d.Nodes[id]
+		if n.Tags["highway"] != "traffic_signals" {
+			continue
+		}
+		sx, sy := ref.Project(n.Lat, n.Lon)
+		at := [2]int{int(math.Floor(sx)), int(math.Floor(sy))}
+		if c.refs[id] < 2 {
+			best := math.Nextafter(radius, math.Inf(1))
+			for y := at[1] - reach; y <= at[1]+reach; y++ {
+				for x := at[0] - reach; x <= at[0]+reach; x++ {
+					for _, j := range junctions[[2]int{x, y}] {
+						jx, jy := ref.Project(j.Lat, j.Lon)
+						dist := math.Hypot(jx-sx, jy-sy) * ref.CellSize
+						if dist < best {
+							best = dist
+							at = [2]int{int(math.Floor(jx)), int(math.Floor(jy))}
+						}
+					}
+				}
+			}
+		}
+		if c.net.Roads[at] && !placed[at] {
+			placed[at] = true
+			c.net.Signals = append(c.net.Signals, at)
+		}
+	}
+	sort.Slice(c.net.Signals, func(i, j int) bool { return rowMajor(c.net.Signals[i], c.net.Signals[j]) })
+}
+
+// closeEdges lists the moves between neighbouring road cells that no road makes.
+func (c *converter) closeEdges() {
+	for p := range c.net.Roads {
+		for _, s := range neighbours {
+			q := [2]int{p[0] + s[0], p[1] + s[1]}
+			e := [4]int{p[0], p[1], q[0], q[1]}
+			if c.net.Roads[q] && !c.allowed[e] {
+				c.net.ClosedEdges = append(c.net.ClosedEdges, e)
+			}
+		}
+	}
+	sort.Slice(c.net.ClosedEdges, func(i, j int) bool {
+		a, b := c.net.ClosedEdges[i], c.net.ClosedEdges[j]
+		if a[1] != b[1] || a[0] != b[0] {
+			return rowMajor([2]int{a[0], a[1]}, [2]int{b[0], b[1]})
+		}
+		return rowMajor([2]int{a[2], a[3]}, [2]int{b[2], b[3]})
+	})
+}
+
+func rowMajor(a, b [2]int) bool {
+	if a[1] != b[1] {
+		return a[1] < b[1]
+	}
+	return a[0] < b[0]
+}
+
+func cellOf(x, y float64) [2]int {
+	return [2]int{int(math.Floor(x)), int(math.Floor(y))}
+}
+
+// clip returns the part of the segment from (x0,y0) to (x1,y1) inside the
+// rectangle from (0,0) to (w,h), and false if none of it is (Liang-Barsky).
+// Ends inside the rectangle are returned unchanged.
+func clip(x0, y0, x1, y1, w, h float64) (float64, float64, float64, float64, bool) {
+	dx, dy := x1-x0, y1-y0
+	t0, t1 := 0.0, 1.0
+	for _, e := range [4][2]float64{{-dx, x0}, {dx, w - x0}, {-dy, y0}, {dy, h - y0}} {
+		p, q := e[0], e[1]
+		switch {
+		case p == 0:
+			if q < 0 {
+				return 0, 0, 0, 0, false
+			}
+		case p < 0:
+			t0 = max(t0, q/p)
+		default:
+			t1 = min(t1, q/p)
+		}
+	}
+	if t0 > t1 {
+		return 0, 0, 0, 0, false
+	}
+	cx0, cy0, cx1, cy1 := x0, y0, x1, y1
+	if t0 > 0 {
+		cx0, cy0 = x0+t0*dx, y0+t0*dy
+	}
+	if t1 < 1 {
+		cx1, cy1 = x0+t1*dx, y0+t1*dy
+	}
+	return cx0, cy0, cx1, cy1, true
+}
+
+// traverse appends the cells after the one holding (x0,y0) that the line to
+// (x1,y1) passes through, stepping one axis at a time so consecutive cells
+// are always neighbours.
+func traverse(dst [][2]int, x0, y0, x1, y1 float64) [][2]int {
+	cx, cy := int(math.Floor(x0)), int(math.Floor(y0))
+	ex, ey := int(math.Floor(x1)), int(math.Floor(y1))
+	stepX, stepY := sign(ex-cx), sign(ey-cy)
+	tMaxX, tDeltaX := crossing(x0, x1-x0, stepX)
+	tMaxY, tDeltaY := crossing(y0, y1-y0, stepY)
+	for cx != ex || cy != ey {
+		if cy == ey || (cx != ex && tMaxX < tMaxY) {
+			cx += stepX
+			tMaxX += tDeltaX
+		} else {
+			cy += stepY
+			tMaxY += tDeltaY
+		}
+		dst = append(dst, [2]int{cx, cy})
+	}
+	return dst
+}
+
+// crossing returns the fraction of a line from p along d at which it first
+// crosses a cell border on this axis, and the fraction between borders.
+func crossing(p, d float64, step int) (first, every float64) {
+	if step == 0 || d == 0 {
+		return math.Inf(1), math.Inf(1)
+	}
+	next := math.Floor(p)
+	if step > 0 {
+		next++
+	}
+	return (next - p) / d, math.Abs(1 / d)
+}
+
+func sign(v int) int {
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	}
+	return 0
+}
+
+// oneway returns 1 if a road may only be driven in the order of its nodes,
+// -1 if only against it and 0 if both ways. Motorways and roundabouts are
+// one-way unless tagged otherwise.
+func oneway(tags map[string]string) int {
+	switch tags["oneway"] {
+	case "yes", "true", "1":
+		return 1
+	case "-1", "reverse":
+		return -1
+	case "no", "false", "0":
+		return 0
+	}
+	if j := tags["junction"]; j == "roundabout" || j == "circular" || tags["highway"] == "motorway" {
+		return 1
+	}
+	return 0
+}
+
+// speedOf returns a road's speed limit in km/h: its maxspeed tag, or the
+// default for its highway type.
+func speedOf(tags map[string]string) float64 {
+	if v, ok := parseMaxSpeed(tags["maxspeed"]); ok {
+		return v
+	}
+	if v, ok := defaultSpeeds[strings.TrimSuffix(tags["highway"], "_link")]; ok {
+		return v
+	}
+	return defaultSpeeds["road"]
+}
+
+// parseMaxSpeed reads maxspeed values such as "50", "50 km/h", "30 mph" or
+// "10 knots"; of several values separated by ';' it takes the first.
+// Symbolic values such as "none" or "DE:urban" are not understood.
+func parseMaxSpeed(s string) (kmh float64, ok bool) {
+	s, _, _ = strings.Cut(s, ";")
+	s = strings.TrimSpace(s)
+	unit := 1.0
+	for suffix, u := range map[string]float64{"km/h": 1, "kmh": 1, "kph": 1, "mph": 1.609344, "knots": 1.852} {
+		if strings.HasSuffix(s, suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, suffix)), u
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v * unit, true
+}
@@ -0,0 +1,121 @@
+package osm
+
+import (
+	"math"
+
+	grid "routeiq/internal/grid"
+	sim "routeiq/internal/sim"
+)
+
+// earthRadius is the mean radius of the Earth in metres.
+const earthRadius = 6371008.8
+
+// GeoRef places a grid on the map. Cell (0,0) is at the north-west corner
+// of Bounds, x grows east and y grows south (as grid.North is y-1), and each
+// cell is a CellSize metre square on an equirectangular projection, which is
+// accurate to well under a cell over a city-sized area.
+type GeoRef struct {
+	Bounds   Bounds  `json:"bounds"`
+	CellSize float64 `json:"cell_meters"`
+}
+
+// metresPerDegree returns the length of a degree of latitude and of
+// longitude at the middle of the bounds.
+func (g GeoRef) metresPerDegree() (lat, lon float64) {
+	lat = earthRadius * math.Pi / 180
+	mid := (g.Bounds.MinLat + g.Bounds.MaxLat) / 2
+	return lat, lat * math.Cos(mid*math.Pi/180)
+}
+
+// Project returns the position of (lat, lon) in cells, with whole numbers
+// on cell borders.
+func (g GeoRef) Project(lat, lon float64) (x, y float64) {
+	mlat, mlon := g.metresPerDegree()
+	return (lon - g.Bounds.MinLon) * mlon / g.CellSize, (g.Bounds.MaxLat - lat) * mlat / g.CellSize
+}
+
+// Cell returns the cell holding (lat, lon), which may be outside the grid.
+func (g GeoRef) Cell(lat, lon float64) (x, y int) {
+	fx, fy := g.Project(lat, lon)
+	return int(math.Floor(fx)), int(math.Floor(fy))
+}
+
+// LatLon returns the centre of cell (x,y).
+func (g GeoRef) LatLon(x, y int) (lat, lon float64) {
+	mlat, mlon := g.metresPerDegree()
+	return g.Bounds.MaxLat - (float64(y)+0.5)*g.CellSize/mlat, g.Bounds.MinLon + (float64(x)+0.5)*g.CellSize/mlon
+}
+
+// size returns the cells needed to cover the bounds, at least one each way.
+func (g GeoRef) size() (w, h int) {
+	fx, fy := g.Project(g.Bounds.MinLat, g.Bounds.MaxLon)
+	return max(1, int(math.Ceil(fx))), max(1, int(math.Ceil(fy)))
+}
+
+// Network is a road network rasterised onto a grid.
+type Network struct {
+	Width       int
+	Height      int
+	Ref         GeoRef
+	Roads       map[[2]int]bool    // cells on an imported road; all others are blocked
+	ClosedEdges [][4]int           // moves {fromX, fromY, toX, toY} between neighbouring road cells no road makes
+	SpeedLimits map[[2]int]float64 // cells per second, where below 1
+	Signals     [][2]int           // signalised junctions, in row-major order
+	Ways        int                // roads imported
+	Pruned      int                // dead-end cells removed
+}
+
+// Blocked returns every cell that is not a road.
+func (n *Network) Blocked() map[[2]int]bool {
+	blocked := make(map[[2]int]bool, n.Width*n.Height-len(n.Roads))
+	for y := 0; y < n.Height; y++ {
+		for x := 0; x < n.Width; x++ {
+			if !n.Roads[[2]int{x, y}] {
+				blocked[[2]int{x, y}] = true
+			}
+		}
+	}
+	return blocked
+}
+
+// EngineConfig returns a config for simulating the network: its size,
+// blocked cells, one-way moves, speed limits and a signal at every
+// signalised junction. Other fields are left for the caller.
+func (n *Network) EngineConfig() sim.EngineConfig {
+	cfg := sim.EngineConfig{
+		Width:         n.Width,
+		Height:        n.Height,
+		Blocked:       n.Blocked(),
+		ClosedEdges:   append([][4]int(nil), n.ClosedEdges...),
+		SpeedLimits:   make(map[[2]int]float64, len(n.SpeedLimits)),
+		Intersections: make([]sim.IntersectionConfig, 0, len(n.Signals)),
+	}
+	for k, l := range n.SpeedLimits {
+		cfg.SpeedLimits[k] = l
+	}
+	for _, s := range n.Signals {
+		cfg.Intersections = append(cfg.Intersections, sim.IntersectionConfig{X: s[0], Y: s[1]})
+	}
+	return cfg
+}
+
+// Grid returns the network's grid with an intersection at every signal.
+func (n *Network) Grid() *grid.Grid {
+	its := make([]grid.Intersection, 0, len(n.Signals))
+	for _, s := range n.Signals {
+		its = append(its, grid.Intersection{X: s[0], Y: s[1]})
+	}
+	return grid.NewGridWithIntersections(n.Width, n.Height, its)
+}
+
+// PathFinder returns a PathFinder over the network's roads.
+func (n *Network) PathFinder() *sim.PathFinder {
+	pf := sim.NewPathFinder(n.Width, n.Height, n.Blocked())
+	for _, e := range n.ClosedEdges {
+		pf.SetEdge(e[0], e[1], e[2], e[3], false)
+	}
+	for k, l := range n.SpeedLimits {
+		pf.SetSpeedLimit(k[0], k[1], l)
+	}
+	return pf
+}
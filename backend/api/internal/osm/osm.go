@@ -0,0 +1,254 @@
+// Package osm reads OpenStreetMap extracts and converts their road network
+// into the simulation's grid model.
+package osm
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Bounds is a latitude/longitude box in degrees.
+type Bounds struct {
+	MinLat float64 `json:"min_lat"`
+	MinLon float64 `json:"min_lon"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLon float64 `json:"max_lon"`
+}
+
+// Empty reports whether b encloses no area.
+func (b Bounds) Empty() bool { return b.MaxLat <= b.MinLat || b.MaxLon <= b.MinLon }
+
+// Contains reports whether (lat, lon) lies inside b.
+func (b Bounds) Contains(lat, lon float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lon >= b.MinLon && lon <= b.MaxLon
+}
+
+// Node is an OSM point.
+type Node struct {
+	ID   int64
+	Lat  float64
+	Lon  float64
+	Tags map[string]string
+}
+
+// checkCoords rejects a position that is not on the globe.
+func checkCoords(lat, lon float64) error {
+	if !(lat >= -90 && lat <= 90) || !(lon >= -180 && lon <= 180) {
+		return fmt.Errorf("position (%g, %g) is outside latitude [-90,90] or longitude [-180,180]", lat, lon)
+	}
+	return nil
+}
+
+// Way is an ordered list of node references.
+type Way struct {
+	ID    int64
+	Nodes []int64
+	Tags  map[string]string
+}
+
+// Data is the nodes and ways of an extract. Relations are not read.
+type Data struct {
+	Bounds Bounds // from the file's header; empty if it has none
+	Nodes  map[int64]Node
+	Ways   []Way
+}
+
+func newData() *Data { return &Data{Nodes: make(map[int64]Node)} }
+
+// ReadFile reads an extract in OSM XML (.osm, .xml, optionally compressed as
+// .gz or .bz2) or PBF (.pbf) format. The format is detected from the content,
+// so misnamed files still load.
+func ReadFile(path string) (*Data, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		defer zr.Close()
+		r = bufio.NewReader(zr)
+	case strings.HasSuffix(path, ".bz2"):
+		r = bufio.NewReader(bzip2.NewReader(r))
+	}
+	d, err := Read(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return d, nil
+}
+
+// Read reads an extract in XML or PBF format, telling them apart by the
+// first non-blank byte: XML starts with '<'.
+func Read(r *bufio.Reader) (*Data, error) {
+	if bom, _ := r.Peek(3); string(bom) == "\xEF\xBB\xBF" {
+		r.Discard(3)
+	}
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return nil, fmt.Errorf("empty OSM file")
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+		case '<':
+			return ReadXML(r)
+		default:
+			return ReadPBF(r)
+		}
+	}
+}
+
+// ReadXML reads an OSM XML document. Deleted and invisible elements are
+// skipped.
+func ReadXML(r io.Reader) (*Data, error) {
+	d := newData()
+	dec := xml.NewDecoder(r)
+	var (
+		node  *Node
+		way   *Way
+		found bool // saw an <osm> root
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("osm xml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			a := attrs(t.Attr)
+			switch t.Name.Local {
+			case "osm":
+				found = true
+			case "bounds":
+				d.Bounds = Bounds{
+					MinLat: a.float("minlat"), MinLon: a.float("minlon"),
+					MaxLat: a.float("maxlat"), MaxLon: a.float("maxlon"),
+				}
+				if a.err != nil {
+					return nil, fmt.Errorf("osm xml: bounds: %w", a.err)
+				}
+			case "node":
+				if !a.live() {
+					dec.Skip()
+					continue
+				}
+				node = &Node{ID: a.int("id"), Lat: a.float("lat"), Lon: a.float("lon")}
+				if a.err != nil {
+					return nil, fmt.Errorf("osm xml: node: %w", a.err)
+				}
+				if err := checkCoords(node.Lat, node.Lon); err != nil {
+					return nil, fmt.Errorf("osm xml: node %d: %w", node.ID, err)
+				}
+			case "way":
+				if !a.live() {
+					dec.Skip()
+					continue
+				}
+				way = &Way{ID: a.int("id")}
+				if a.err != nil {
+					return nil, fmt.Errorf("osm xml: way: %w", a.err)
+				}
+			case "nd":
+				if way != nil {
+					ref := a.int("ref")
+					if a.err != nil {
+						return nil, fmt.Errorf("osm xml: way %d: nd: %w", way.ID, a.err)
+					}
+					way.Nodes = append(way.Nodes, ref)
+				}
+			case "tag":
+				switch {
+				case node != nil:
+					node.Tags = setTag(node.Tags, a.get("k"), a.get("v"))
+				case way != nil:
+					way.Tags = setTag(way.Tags, a.get("k"), a.get("v"))
+				}
+			case "relation":
+				dec.Skip()
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "node":
+				if node != nil {
+					d.Nodes[node.ID] = *node
+					node = nil
+				}
+			case "way":
+				if way != nil {
+					d.Ways = append(d.Ways, *way)
+					way = nil
+				}
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("osm xml: no <osm> element")
+	}
+	return d, nil
+}
+
+func setTag(tags map[string]string, k, v string) map[string]string {
+	if k == "" {
+		return tags
+	}
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	tags[k] = v
+	return tags
+}
+
+// xmlAttrs reads typed attributes, keeping the first parse error.
+type xmlAttrs struct {
+	vals map[string]string
+	err  error
+}
+
+func attrs(list []xml.Attr) *xmlAttrs {
+	a := &xmlAttrs{vals: make(map[string]string, len(list))}
+	for _, at := range list {
+		a.vals[at.Name.Local] = at.Value
+	}
+	return a
+}
+
+func (a *xmlAttrs) get(name string) string { return a.vals[name] }
+
+func (a *xmlAttrs) live() bool {
+	return a.vals["action"] != "delete" && a.vals["visible"] != "false"
+}
+
+func (a *xmlAttrs) float(name string) float64 {
+	f, err := strconv.ParseFloat(a.vals[name], 64)
+	a.fail(name, err)
+	return f
+}
+
+func (a *xmlAttrs) int(name string) int64 {
+	i, err := strconv.ParseInt(a.vals[name], 10, 64)
+	a.fail(name, err)
+	return i
+}
+
+func (a *xmlAttrs) fail(name string, err error) {
+	if err != nil && a.err == nil {
+		a.err = fmt.Errorf("attribute %s=%q is not a number", name, a.vals[name])
+	}
+}
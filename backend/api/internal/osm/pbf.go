@@ -0,0 +1,510 @@
+package osm
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Size limits from the PBF format specification.
+const (
+	maxBlobHeaderSize = 64 << 10
+	maxBlobSize       = 32 << 20
+)
+
+// pbfFeatures are the required features ReadPBF understands.
+var pbfFeatures = map[string]bool{"OsmSchema-V0.6": true, "DenseNodes": true}
+
+// ReadPBF reads an OSM PBF file: a sequence of length-prefixed blobs, each
+// holding a header or a block of nodes, ways and relations. Blobs may be
+// stored raw or zlib-compressed; other compressions are rejected.
+func ReadPBF(r io.Reader) (*Data, error) {
+	d := newData()
+	var size [4]byte
+	for n := 0; ; n++ {
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			if err == io.EOF && n > 0 {
+				return d, nil
+			}
+			return nil, fmt.Errorf("osm pbf: blob %d: %w", n, unexpected(err))
+		}
+		hlen := binary.BigEndian.Uint32(size[:])
+		if hlen > maxBlobHeaderSize {
+			return nil, fmt.Errorf("osm pbf: blob %d: header of %d bytes is too large", n, hlen)
+		}
+		kind, blen, err := readBlobHeader(r, hlen)
+		if err != nil {
+			return nil, fmt.Errorf("osm pbf: blob %d: %w", n, err)
+		}
+		if blen > maxBlobSize {
+			return nil, fmt.Errorf("osm pbf: blob %d: %d bytes is too large", n, blen)
+		}
+		blob := make([]byte, blen)
+		if _, err := io.ReadFull(r, blob); err != nil {
+			return nil, fmt.Errorf("osm pbf: blob %d: %w", n, unexpected(err))
+		}
+		switch kind {
+		case "OSMHeader":
+			data, err := unpackBlob(blob)
+			if err == nil {
+				err = d.readHeaderBlock(data)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("osm pbf: header: %w", err)
+			}
+		case "OSMData":
+			data, err := unpackBlob(blob)
+			if err == nil {
+				err = d.readPrimitiveBlock(data)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("osm pbf: blob %d: %w", n, err)
+			}
+		}
+	}
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func readBlobHeader(r io.Reader, n uint32) (kind string, size uint64, err error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", 0, unexpected(err)
+	}
+	m := message{b: buf}
+	for m.next() {
+		switch m.field {
+		case 1:
+			kind = string(m.bytes())
+		case 3:
+			size = m.varint()
+		default:
+			m.skip()
+		}
+	}
+	return kind, size, m.err
+}
+
+// unpackBlob returns the uncompressed contents of a Blob message.
+func unpackBlob(buf []byte) ([]byte, error) {
+	m := message{b: buf}
+	var rawSize uint64
+	var raw, compressed []byte
+	for m.next() {
+		switch m.field {
+		case 1:
+			raw = m.bytes()
+		case 2:
+			rawSize = m.varint()
+		case 3:
+			compressed = m.bytes()
+		case 4, 5, 6, 7:
+			return nil, errors.New("only raw and zlib-compressed blobs are supported")
+		default:
+			m.skip()
+		}
+	}
+	switch {
+	case m.err != nil:
+		return nil, m.err
+	case raw != nil:
+		return raw, nil
+	case compressed == nil:
+		return nil, errors.New("empty blob")
+	case rawSize > maxBlobSize:
+		return nil, fmt.Errorf("blob of %d bytes uncompressed is too large", rawSize)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	out := bytes.NewBuffer(make([]byte, 0, rawSize))
+	if _, err := io.Copy(out, io.LimitReader(zr, maxBlobSize+1)); err != nil {
+		return nil, err
+	}
+	if out.Len() > maxBlobSize {
+		return nil, errors.New("blob is too large uncompressed")
+	}
+	return out.Bytes(), nil
+}
+
+func (d *Data) readHeaderBlock(buf []byte) error {
+	m := message{b: buf}
+	for m.next() {
+		switch m.field {
+		case 1: // bbox in nanodegrees
+			bb := message{b: m.bytes()}
+			var left, right, top, bottom int64
+			for bb.next() {
+				switch bb.field {
+				case 1:
+					left = bb.sint()
+				case 2:
+					right = bb.sint()
+				case 3:
+					top = bb.sint()
+				case 4:
+					bottom = bb.sint()
+				default:
+					bb.skip()
+				}
+			}
+			if bb.err != nil {
+				return bb.err
+			}
+			d.Bounds = Bounds{MinLat: nano(bottom), MinLon: nano(left), MaxLat: nano(top), MaxLon: nano(right)}
+		case 4:
+			if f := string(m.bytes()); !pbfFeatures[f] {
+				return fmt.Errorf("required feature %q is not supported", f)
+			}
+		default:
+			m.skip()
+		}
+	}
+	return m.err
+}
+
+func nano(v int64) float64 { return float64(v) * 1e-9 }
+
+// block holds the string table and coordinate encoding of a PrimitiveBlock.
+type block struct {
+	strings     [][]byte
+	granularity int64
+	latOffset   int64
+	lonOffset   int64
+}
+
+func (b *block) str(i uint64) (string, error) {
+	if i >= uint64(len(b.strings)) {
+		return "", fmt.Errorf("string index %d out of range", i)
+	}
+	return string(b.strings[i]), nil
+}
+
+func (b *block) lat(v int64) float64 { return nano(b.latOffset + b.granularity*v) }
+func (b *block) lon(v int64) float64 { return nano(b.lonOffset + b.granularity*v) }
+
+// tags pairs up key and value string indexes.
+func (b *block) tags(keys, vals []uint64) (map[string]string, error) {
+	if len(keys) != len(vals) {
+		return nil, errors.New("keys and values differ in length")
+	}
+	var tags map[string]string
+	for i := range keys {
+		k, err := b.str(keys[i])
+		if err != nil {
+			return nil, err
+		}
+		v, err := b.str(vals[i])
+		if err != nil {
+			return nil, err
+		}
+		tags = setTag(tags, k, v)
+	}
+	return tags, nil
+}
+
+func (d *Data) readPrimitiveBlock(buf []byte) error {
+	b := block{granularity: 100}
+	var groups [][]byte
+	m := message{b: buf}
+	for m.next() {
+		switch m.field {
+		case 1:
+			st := message{b: m.bytes()}
+			for st.next() {
+				if st.field == 1 {
+					b.strings = append(b.strings, st.bytes())
+				} else {
+					st.skip()
+				}
+			}
+			if st.err != nil {
+				return st.err
+			}
+		case 2:
+			groups = append(groups, m.bytes())
+		case 17:
+			b.granularity = int64(m.varint())
+		case 19:
+			b.latOffset = int64(m.varint())
+		case 20:
+			b.lonOffset = int64(m.varint())
+		default:
+			m.skip()
+		}
+	}
+	if m.err != nil {
+		return m.err
+	}
+	for _, g := range groups {
+		if err := d.readGroup(&b, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Data) readGroup(b *block, buf []byte) error {
+	m := message{b: buf}
+	for m.next() {
+		var err error
+		switch m.field {
+		case 1:
+			err = d.readNode(b, m.bytes())
+		case 2:
+			err = d.readDenseNodes(b, m.bytes())
+		case 3:
+			err = d.readWay(b, m.bytes())
+		default: // relations and changesets
+			m.skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return m.err
+}
+
+func (d *Data) readNode(b *block, buf []byte) error {
+	var n Node
+	var keys, vals []uint64
+	var lat, lon int64
+	m := message{b: buf}
+	for m.next() {
+		switch m.field {
+		case 1:
+			n.ID = m.sint()
+		case 2:
+			keys = m.packed(keys)
+		case 3:
+			vals = m.packed(vals)
+		case 8:
+			lat = m.sint()
+		case 9:
+			lon = m.sint()
+		default:
+			m.skip()
+		}
+	}
+	if m.err != nil {
+		return fmt.Errorf("node: %w", m.err)
+	}
+	tags, err := b.tags(keys, vals)
+	if err != nil {
+		return fmt.Errorf("node %d: %w", n.ID, err)
+	}
+	n.Lat, n.Lon, n.Tags = b.lat(lat), b.lon(lon), tags
+	if err := checkCoords(n.Lat, n.Lon); err != nil {
+		return fmt.Errorf("node %d: %w", n.ID, err)
+	}
+	d.Nodes[n.ID] = n
+	return nil
+}
+
+// readDenseNodes reads delta-coded parallel arrays of ids and coordinates,
+// with every node's tags in one array of key, value indexes, each node's
+// ended by a zero.
+func (d *Data) readDenseNodes(b *block, buf []byte) error {
+	var ids, lats, lons, kv []uint64
+	m := message{b: buf}
+	for m.next() {
+		switch m.field {
+		case 1:
+			ids = m.packed(ids)
+		case 8:
+			lats = m.packed(lats)
+		case 9:
+			lons = m.packed(lons)
+		case 10:
+			kv = m.packed(kv)
+		default:
+			m.skip()
+		}
+	}
+	if m.err != nil {
+		return fmt.Errorf("dense nodes: %w", m.err)
+	}
+	if len(lats) != len(ids) || len(lons) != len(ids) {
+		return errors.New("dense nodes: ids and coordinates differ in length")
+	}
+	var id, lat, lon int64
+	for i := range ids {
+		id += unzigzag(ids[i])
+		lat += unzigzag(lats[i])
+		lon += unzigzag(lons[i])
+		n := Node{ID: id, Lat: b.lat(lat), Lon: b.lon(lon)}
+		if err := checkCoords(n.Lat, n.Lon); err != nil {
+			return fmt.Errorf("node %d: %w", id, err)
+		}
+		for len(kv) > 0 && kv[0] != 0 {
+			if len(kv) < 2 {
+				return fmt.Errorf("node %d: tag without a value", id)
+			}
+			tags, err := b.tags(kv[:1], kv[1:2])
+			if err != nil {
+				return fmt.Errorf("node %d: %w", id, err)
+			}
+			for k, v := range tags {
+				n.Tags = setTag(n.Tags, k, v)
+			}
+			kv = kv[2:]
+		}
+		if len(kv) > 0 {
+			kv = kv[1:] // the node's terminating zero
+		}
+		d.Nodes[id] = n
+	}
+	return nil
+}
+
+func (d *Data) readWay(b *block, buf []byte) error {
+	var w Way
+	var keys, vals, refs []uint64
+	m := message{b: buf}
+	for m.next() {
+		switch m.field {
+		case 1:
+			w.ID = int64(m.varint())
+		case 2:
+			keys = m.packed(keys)
+		case 3:
+			vals = m.packed(vals)
+		case 8:
+			refs = m.packed(refs)
+		default:
+			m.skip()
+		}
+	}
+	if m.err != nil {
+		return fmt.Errorf("way: %w", m.err)
+	}
+	tags, err := b.tags(keys, vals)
+	if err != nil {
+		return fmt.Errorf("way %d: %w", w.ID, err)
+	}
+	w.Tags = tags
+	var ref int64
+	for _, r := range refs {
+		ref += unzigzag(r)
+		w.Nodes = append(w.Nodes, ref)
+	}
+	d.Ways = append(d.Ways, w)
+	return nil
+}
+
+// message walks the fields of an encoded protocol buffer message. After
+// next returns true, field and wire describe the current field and exactly
+// one of the value methods or skip must be called to consume it.
+type message struct {
+	b     []byte
+	field int
+	wire  int
+	err   error
+}
+
+const (
+	wireVarint = 0
+	wire64     = 1
+	wireBytes  = 2
+	wire32     = 5
+)
+
+var errTruncated = errors.New("truncated message")
+
+func (m *message) fail(err error) {
+	if m.err == nil {
+		m.err = err
+	}
+	m.b = nil
+}
+
+func (m *message) next() bool {
+	if m.err != nil || len(m.b) == 0 {
+		return false
+	}
+	key := m.rawVarint()
+	m.field, m.wire = int(key>>3), int(key&7)
+	return m.err == nil
+}
+
+func (m *message) rawVarint() uint64 {
+	v, n := binary.Uvarint(m.b)
+	if n <= 0 {
+		m.fail(errTruncated)
+		return 0
+	}
+	m.b = m.b[n:]
+	return v
+}
+
+func (m *message) varint() uint64 {
+	if m.wire != wireVarint {
+		m.fail(fmt.Errorf("field %d: expected a varint", m.field))
+		return 0
+	}
+	return m.rawVarint()
+}
+
+func (m *message) sint() int64 { return unzigzag(m.varint()) }
+
+func unzigzag(v uint64) int64 { return int64(v>>1) ^ -int64(v&1) }
+
+func (m *message) bytes() []byte {
+	if m.wire != wireBytes {
+		m.fail(fmt.Errorf("field %d: expected bytes", m.field))
+		return nil
+	}
+	n := m.rawVarint()
+	if n > uint64(len(m.b)) {
+		m.fail(errTruncated)
+		return nil
+	}
+	out := m.b[:n:n]
+	m.b = m.b[n:]
+	return out
+}
+
+// packed appends a repeated varint field, packed or not, to dst.
+func (m *message) packed(dst []uint64) []uint64 {
+	if m.wire == wireVarint {
+		return append(dst, m.rawVarint())
+	}
+	p := message{b: m.bytes()}
+	for len(p.b) > 0 && p.err == nil {
+		dst = append(dst, p.rawVarint())
+	}
+	if p.err != nil {
+		m.fail(fmt.Errorf("field %d: %w", m.field, p.err))
+	}
+	return dst
+}
+
+func (m *message) skip() {
+	switch m.wire {
+	case wireVarint:
+		m.rawVarint()
+	case wireBytes:
+		m.bytes()
+	case wire64, wire32:
+		n := 8
+		if m.wire == wire32 {
+			n = 4
+		}
+		if len(m.b) < n {
+			m.fail(errTruncated)
+			return
+		}
+		m.b = m.b[n:]
+	default:
+		m.fail(fmt.Errorf("field %d: unsupported wire type %d", m.field, m.wire))
+	}
+}
@@ -0,0 +1,163 @@
+package osm_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	osm "routeiq/internal/osm"
+	sim "routeiq/internal/sim"
+)
+
+// neighbourhood is a 10x10-cell map: a two-way main street along row 5, a
+// one-way street running south down column 5 and out of the area with a
+// signal just north of the junction, a one-way spur north from (1,5) that
+// goes nowhere, a side street along row 6 that does not meet the main
+// street, a lane with a node missing from the extract, and a footpath.
+func neighbourhood() *osm.Data {
+	mlat := 6371008.8 * math.Pi / 180
+	b := osm.Bounds{MaxLat: 51.5, MinLon: -0.1}
+	b.MinLat = b.MaxLat - 9.5*osm.DefaultCellSize/mlat
+	b.MaxLon = b.MinLon + 9.5*osm.DefaultCellSize/(mlat*math.Cos((b.MinLat+b.MaxLat)/2*math.Pi/180))
+	ref := osm.GeoRef{Bounds: b, CellSize: osm.DefaultCellSize}
+
+	d := &osm.Data{Bounds: b, Nodes: map[int64]osm.Node{}}
+	node := func(id int64, x, y int, tags ...string) int64 {
+		lat, lon := ref.LatLon(x, y)
+		n := osm.Node{ID: id, Lat: lat, Lon: lon}
+		for i := 0; i+1 < len(tags); i += 2 {
+			if n.Tags == nil {
+				n.Tags = map[string]string{}
+			}
+			n.Tags[tags[i]] = tags[i+1]
+		}
+		d.Nodes[id] = n
+		return id
+	}
+	way := func(id int64, tags string, nodes ...int64) {
+		w := osm.Way{ID: id, Nodes: nodes, Tags: map[string]string{}}
+		for _, kv := range strings.Fields(tags) {
+			k, v, _ := strings.Cut(kv, "=")
+			w.Tags[k] = v
+		}
+		d.Ways = append(d.Ways, w)
+	}
+	junction := node(1, 5, 5)
+	way(10, "highway=primary maxspeed=50", node(2, 0, 5), node(3, 1, 5), junction, node(4, 9, 5))
+	way(11, "highway=residential oneway=yes", node(5, 5, 0), node(6, 5, 4, "highway", "traffic_signals"), junction, node(7, 5, 12))
+	way(12, "highway=residential oneway=yes", 3, node(8, 1, 2))
+	way(13, "highway=residential", node(9, 6, 6), node(10, 9, 6))
+	way(15, "highway=service", node(13, 0, 8), node(15, 2, 8), 99, node(14, 6, 8))
+	way(14, "highway=footway", node(11, 0, 2), node(12, 9, 2))
+	return d
+}
+
+func TestConvert_Neighbourhood(t *testing.T) {
+	n, err := osm.Convert(neighbourhood(), osm.Options{})
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if n.Width != 10 || n.Height != 10 || n.Ways != 5 {
+		t.Fatalf("expected 5 roads on a 10x10 grid, got %d on %dx%d", n.Ways, n.Width, n.Height)
+	}
+	for _, c := range [][2]int{{0, 5}, {9, 5}, {5, 0}, {5, 4}, {5, 5}, {7, 6}, {0, 8}, {2, 8}} {
+		if !n.Roads[c] {
+			t.Fatalf("expected a road at %v", c)
+		}
+	}
+	for _, c := range [][2]int{{3, 2}, {1, 3}, {5, 6}, {5, 9}, {4, 8}, {6, 8}} {
+		if n.Roads[c] {
+			t.Fatalf("expected no road at %v", c)
+		}
+	}
+	// the spur (1,2)-(1,4), column 5 below the junction and the lane's
+	// stranded end at (6,8) lead nowhere
+	if n.Pruned != 8 {
+		t.Fatalf("expected 8 dead-end cells pruned, got %d", n.Pruned)
+	}
+	if got := n.SpeedLimits[[2]int{2, 5}]; math.Abs(got-50/3.6/25) > 1e-9 {
+		t.Fatalf("expected 50 km/h as %v cells/s, got %v", 50/3.6/25, got)
+	}
+	if got := n.SpeedLimits[[2]int{5, 2}]; math.Abs(got-30/3.6/25) > 1e-9 {
+		t.Fatalf("expected residential default 30 km/h, got %v cells/s", got)
+	}
+	if got := n.SpeedLimits[[2]int{5, 5}]; math.Abs(got-50/3.6/25) > 1e-9 {
+		t.Fatalf("expected the junction at the faster road's limit, got %v", got)
+	}
+	if len(n.Signals) != 1 || n.Signals[0] != [2]int{5, 5} {
+		t.Fatalf("expected the signal moved onto the junction, got %v", n.Signals)
+	}
+	lat, lon := n.Ref.LatLon(3, 7)
+	if x, y := n.Ref.Cell(lat, lon); x != 3 || y != 7 {
+		t.Fatalf("expected cell centre to map back to (3,7), got (%d,%d)", x, y)
+	}
+
+	pf := n.PathFinder()
+	if !pf.CanMove(5, 3, 5, 4) || pf.CanMove(5, 4, 5, 3) || pf.CanMove(5, 5, 5, 4) {
+		t.Fatalf("expected column 5 one-way southbound")
+	}
+	if pf.CanMove(7, 5, 7, 6) || pf.CanMove(7, 6, 7, 5) {
+		t.Fatalf("expected no move between side-by-side roads that do not meet")
+	}
+	if len(pf.Path(5, 0, 9, 5)) == 0 || len(pf.Path(9, 5, 5, 0)) != 0 {
+		t.Fatalf("expected routes down the one-way street only")
+	}
+	if len(pf.TrappedCells()) != 0 {
+		t.Fatalf("expected no trapped cells, got %v", pf.TrappedCells())
+	}
+}
+
+func TestConvert_EngineRunsOnRoads(t *testing.T) {
+	n, err := osm.Convert(neighbourhood(), osm.Options{})
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	cfg := n.EngineConfig()
+	cfg.Vehicles, cfg.Seed = 8, 5
+	e := sim.NewEngine(cfg)
+	if its := e.Grid().Intersections(); len(its) != 1 || its[0].X != 5 || its[0].Y != 5 {
+		t.Fatalf("expected one signalised intersection at (5,5), got %+v", its)
+	}
+	for i := 0; i < 60; i++ {
+		e.Step()
+		for _, v := range e.Vehicles() {
+			if !n.Roads[[2]int{v.X, v.Y}] {
+				t.Fatalf("tick %d: vehicle %s off the road at (%d,%d)", i, v.ID, v.X, v.Y)
+			}
+		}
+	}
+}
+
+func TestConvert_ClipsRoadsToTheGrid(t *testing.T) {
+	d := neighbourhood()
+	n, err := osm.Convert(d, osm.Options{})
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	// a road down column 3 between the poles, millions of cells long
+	_, lon := n.Ref.LatLon(3, 0)
+	d.Nodes[20] = osm.Node{ID: 20, Lat: 89, Lon: lon}
+	d.Nodes[21] = osm.Node{ID: 21, Lat: -89, Lon: lon}
+	d.Ways = append(d.Ways, osm.Way{ID: 16, Nodes: []int64{20, 21}, Tags: map[string]string{"highway": "residential"}})
+	n, err = osm.Convert(d, osm.Options{})
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	for y := 0; y < n.Height; y++ {
+		if !n.Roads[[2]int{3, y}] {
+			t.Fatalf("expected the clipped road on (3,%d)", y)
+		}
+	}
+}
+
+func TestConvert_Errors(t *testing.T) {
+	if _, err := osm.Convert(neighbourhood(), osm.Options{CellSize: 0.01}); err == nil || !strings.Contains(err.Error(), "larger cells") {
+		t.Fatalf("expected an oversized grid to be refused, got %v", err)
+	}
+	if _, err := osm.Convert(neighbourhood(), osm.Options{Highways: []string{"cycleway"}}); err == nil {
+		t.Fatalf("expected an error with no roads to import")
+	}
+	if _, err := osm.Convert(neighbourhood(), osm.Options{CellSize: -5}); err == nil {
+		t.Fatalf("expected a negative cell size to be refused")
+	}
+}
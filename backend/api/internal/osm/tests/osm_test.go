@@ -0,0 +1,121 @@
+package osm_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	osm "routeiq/internal/osm"
+)
+
+const sampleXML = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6" generator="test">
+  <bounds minlat="51.4990" minlon="-0.1010" maxlat="51.5010" maxlon="-0.0990"/>
+  <node id="1" lat="51.5000" lon="-0.1000"/>
+  <node id="2" lat="51.5005" lon="-0.0995">
+    <tag k="highway" v="traffic_signals"/>
+  </node>
+  <node id="3" lat="51.5009" lon="-0.0991" action="delete"/>
+  <way id="10">
+    <nd ref="1"/>
+    <nd ref="2"/>
+    <tag k="highway" v="residential"/>
+    <tag k="oneway" v="yes"/>
+  </way>
+  <way id="11" visible="false">
+    <nd ref="2"/>
+    <nd ref="3"/>
+  </way>
+  <relation id="20">
+    <member type="way" ref="10" role=""/>
+    <tag k="type" v="route"/>
+  </relation>
+</osm>
+`
+
+func TestReadXML(t *testing.T) {
+	d, err := osm.ReadXML(strings.NewReader(sampleXML))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	want := osm.Bounds{MinLat: 51.499, MinLon: -0.101, MaxLat: 51.501, MaxLon: -0.099}
+	if d.Bounds != want {
+		t.Fatalf("expected bounds %+v, got %+v", want, d.Bounds)
+	}
+	if len(d.Nodes) != 2 || d.Nodes[2].Tags["highway"] != "traffic_signals" || d.Nodes[1].Lon != -0.1 {
+		t.Fatalf("expected two live nodes with tags, got %+v", d.Nodes)
+	}
+	if len(d.Ways) != 1 || d.Ways[0].ID != 10 || len(d.Ways[0].Nodes) != 2 || d.Ways[0].Tags["oneway"] != "yes" {
+		t.Fatalf("expected one live way, got %+v", d.Ways)
+	}
+}
+
+func TestReadXML_Errors(t *testing.T) {
+	cases := map[string]string{
+		"not osm":       `<gpx></gpx>`,
+		"bad number":    `<osm><node id="1" lat="north" lon="0"/></osm>`,
+		"bad nd":        `<osm><way id="1"><nd ref="x"/></way></osm>`,
+		"broken":        `<osm><node id="1"`,
+		"bad bounds":    `<osm><bounds minlat="" minlon="0" maxlat="1" maxlon="1"/></osm>`,
+		"off the globe": `<osm><node id="1" lat="1e12" lon="0"/></osm>`,
+	}
+	for name, doc := range cases {
+		if _, err := osm.ReadXML(strings.NewReader(doc)); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestReadFile_DetectsFormat(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "area.osm")
+	if err := os.WriteFile(plain, []byte("\xEF\xBB\xBF\n  "+sampleXML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gz := filepath.Join(dir, "area.osm.gz")
+	f, err := os.Create(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte(sampleXML))
+	zw.Close()
+	f.Close()
+	pbf := filepath.Join(dir, "area.pbf")
+	if err := os.WriteFile(pbf, samplePBF(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{plain, gz, pbf} {
+		d, err := osm.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(path), err)
+		}
+		if len(d.Ways) != 1 || len(d.Nodes) < 2 {
+			t.Fatalf("%s: expected the sample's way and nodes, got %d ways, %d nodes", filepath.Base(path), len(d.Ways), len(d.Nodes))
+		}
+	}
+	if _, err := osm.Read(bufio.NewReader(strings.NewReader(""))); err == nil {
+		t.Fatalf("expected an empty file to be refused")
+	}
+	if _, err := osm.ReadFile(filepath.Join(dir, "missing.osm")); err == nil {
+		t.Fatalf("expected a missing file to be reported")
+	}
+}
+
+func TestLoad_ConvertsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "area.osm")
+	twoWay := strings.Replace(sampleXML, `k="oneway" v="yes"`, `k="oneway" v="no"`, 1)
+	if err := os.WriteFile(path, []byte(twoWay), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := osm.Load(path, osm.Options{CellSize: 20})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if n.Ref.CellSize != 20 || n.Width < 6 || n.Height < 11 || len(n.Roads) == 0 {
+		t.Fatalf("expected the file's bounds at 20 m cells, got %dx%d with %d road cells", n.Width, n.Height, len(n.Roads))
+	}
+}
@@ -0,0 +1,123 @@
+package osm_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+
+	osm "routeiq/internal/osm"
+)
+
+// pb builds protocol buffer messages for test files.
+type pb []byte
+
+func (m pb) key(field, wire int) pb { return binary.AppendUvarint(m, uint64(field<<3|wire)) }
+
+func (m pb) uint(field int, v uint64) pb { return binary.AppendUvarint(m.key(field, 0), v) }
+
+func (m pb) sint(field int, v int64) pb { return m.uint(field, uint64(v<<1^(v>>63))) }
+
+func (m pb) bytes(field int, b []byte) pb {
+	return append(binary.AppendUvarint(m.key(field, 2), uint64(len(b))), b...)
+}
+
+func (m pb) packed(field int, vs ...uint64) pb {
+	var p []byte
+	for _, v := range vs {
+		p = binary.AppendUvarint(p, v)
+	}
+	return m.bytes(field, p)
+}
+
+func zz(v int64) uint64 { return uint64(v<<1 ^ (v >> 63)) }
+
+// fileBlock frames a blob of the given type, zlib-compressing it if asked.
+func fileBlock(kind string, data []byte, compress bool) []byte {
+	var blob pb
+	if compress {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(data)
+		zw.Close()
+		blob = blob.uint(2, uint64(len(data))).bytes(3, z.Bytes())
+	} else {
+		blob = blob.bytes(1, data)
+	}
+	header := pb{}.bytes(1, []byte(kind)).uint(3, uint64(len(blob)))
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(header)))
+	return append(append(out, header...), blob...)
+}
+
+func pbfHeader(features ...string) []byte {
+	bbox := pb{}.sint(1, -101e6).sint(2, -99e6).sint(3, 51501e6).sint(4, 51499e6)
+	h := pb{}.bytes(1, bbox)
+	for _, f := range features {
+		h = h.bytes(4, []byte(f))
+	}
+	return fileBlock("OSMHeader", h, false)
+}
+
+// samplePBF encodes sampleXML's live nodes and way: node 1 as dense nodes
+// at granularity 100, node 2 as a plain node with a tag, and way 10.
+func samplePBF() []byte {
+	strs := []string{"", "highway", "traffic_signals", "residential", "oneway", "yes"}
+	var st pb
+	for _, s := range strs {
+		st = st.bytes(1, []byte(s))
+	}
+	// lat = granularity * value nanodegrees
+	dense := pb{}.packed(1, zz(1)).packed(8, zz(515000000)).packed(9, zz(-1000000)).packed(10, 0)
+	node := pb{}.sint(1, 2).packed(2, 1).packed(3, 2).sint(8, 515005000).sint(9, -995000)
+	way := pb{}.uint(1, 10).packed(2, 1, 4).packed(3, 3, 5).packed(8, zz(1), zz(1))
+	group1 := pb{}.bytes(2, dense).bytes(1, node)
+	group2 := pb{}.bytes(3, way)
+	block := pb{}.bytes(1, st).bytes(2, group1).bytes(2, group2)
+	out := pbfHeader("OsmSchema-V0.6", "DenseNodes")
+	return append(out, fileBlock("OSMData", block, true)...)
+}
+
+func TestReadPBF(t *testing.T) {
+	d, err := osm.ReadPBF(bytes.NewReader(samplePBF()))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	want, err := osm.ReadXML(strings.NewReader(sampleXML))
+	if err != nil {
+		t.Fatalf("read xml: %v", err)
+	}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	if !near(d.Bounds.MinLat, want.Bounds.MinLat) || !near(d.Bounds.MaxLon, want.Bounds.MaxLon) {
+		t.Fatalf("expected bounds %+v, got %+v", want.Bounds, d.Bounds)
+	}
+	for id, w := range want.Nodes {
+		n, ok := d.Nodes[id]
+		if !ok || !near(n.Lat, w.Lat) || !near(n.Lon, w.Lon) || n.Tags["highway"] != w.Tags["highway"] {
+			t.Fatalf("expected node %+v, got %+v", w, n)
+		}
+	}
+	if len(d.Ways) != 1 || d.Ways[0].ID != 10 || d.Ways[0].Nodes[0] != 1 || d.Ways[0].Nodes[1] != 2 ||
+		d.Ways[0].Tags["highway"] != "residential" || d.Ways[0].Tags["oneway"] != "yes" {
+		t.Fatalf("expected way 10 from node 1 to 2, got %+v", d.Ways)
+	}
+}
+
+func TestReadPBF_Errors(t *testing.T) {
+	good := samplePBF()
+	cases := map[string][]byte{
+		"empty":       nil,
+		"truncated":   good[:len(good)-3],
+		"feature":     pbfHeader("OsmSchema-V0.6", "HistoricalInformation", "LocationsOnWays"),
+		"huge header": binary.BigEndian.AppendUint32(nil, 1<<20),
+		"bad string":  fileBlock("OSMData", pb{}.bytes(2, pb{}.bytes(3, pb{}.uint(1, 1).packed(2, 7).packed(3, 7))), false),
+		"off the globe": fileBlock("OSMData", pb{}.bytes(1, pb{}.bytes(1, nil)).
+			bytes(2, pb{}.bytes(1, pb{}.sint(1, 2).sint(8, 1e12).sint(9, 0))), false),
+	}
+	for name, data := range cases {
+		if _, err := osm.ReadPBF(bytes.NewReader(data)); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,726 @@
+// Package scenario reads declarative simulation setups from YAML or JSON files:
+// the grid, which cells are road, signalised intersections and their plans,
+// closures, scheduled incidents, demand and how long to run.
+package scenario
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	grid "routeiq/internal/grid"
+	sim "routeiq/internal/sim"
+)
+
+// MaxSize bounds the width and height of a scenario grid.
+const MaxSize = 1000
+
+// Scenario is a simulation setup. Cells are [x, y] pairs and durations are Go
+// durations ("90s", "1h30m") or plain numbers of seconds.
+type Scenario struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+
+	Width     int    `yaml:"width"`
+	Height    int    `yaml:"height"`
+	Seed      uint64 `yaml:"seed"`       // random if zero
+	StartTime string `yaml:"start_time"` // HH:MM; 00:00 if empty
+	Duration  string `yaml:"duration"`   // run length; unlimited if empty
+
+	Roads         []Area         `yaml:"roads"`    // cells vehicles may use; every cell if empty
+	Closures      []Area         `yaml:"closures"` // road cells closed for the whole run
+	Control       string         `yaml:"control"`  // default signal control; fixed-time if empty
+	Intersections []Intersection `yaml:"intersections"`
+	Incidents     []Incident     `yaml:"incidents"`
+	Congestion    []Congestion   `yaml:"congestion"` // predicted congestion for timed routes
+
+	Vehicles        int     `yaml:"vehicles"` // spawned on open cells at the start
+	Demand          *Demand `yaml:"demand"`
+	Lanes           int     `yaml:"lanes"`        // per cell; 1 if zero
+	LaneStorage     int     `yaml:"lane_storage"` // vehicles per lane; 1 if zero
+	Kinematics      bool    `yaml:"kinematics"`   // accelerating vehicles with sub-cell progress
+	RandomIncidents bool    `yaml:"random_incidents"`
+}
+
+// Area is a rectangle of cells from From to To inclusive, or the single cell
+// From if To is omitted. A row or column segment is a one-cell-wide rectangle.
+type Area struct {
+	From []int `yaml:"from"`
+	To   []int `yaml:"to"`
+}
+
+// Intersection is a signalised intersection. Without phases it runs the
+// default four-phase plan.
+type Intersection struct {
+	At          []int              `yaml:"at"`
+	Control     string             `yaml:"control"` // the scenario's control if empty
+	Offset      int                `yaml:"offset"`
+	Phases      []Phase            `yaml:"phases"`
+	NoTurns     []string           `yaml:"no_turns"`     // e.g. [left, uturn]
+	TurnPenalty map[string]float64 `yaml:"turn_penalty"` // extra routing cost per turn
+}
+
+// Phase gives right of way to movements written "<heading>:<turn>", e.g.
+// "north:straight" for northbound through traffic. Yellow and all-red default
+// to 3 and 2 seconds.
+type Phase struct {
+	Name      string   `yaml:"name"`
+	Green     []string `yaml:"green"`
+	GreenSec  int      `yaml:"green_sec"`
+	YellowSec *int     `yaml:"yellow_sec"`
+	AllRedSec *int     `yaml:"all_red_sec"`
+}
+
+// Incident is raised Start into the run and, if Duration is set, resolved
+// after it.
+type Incident struct {
+	ID       string `yaml:"id"`
+	Type     string `yaml:"type"` // accident, closure or construction
+	At       []int  `yaml:"at"`
+	Severity int    `yaml:"severity"`
+	Start    string `yaml:"start"`
+	Duration string `yaml:"duration"`
+}
+
+// Demand generates trips continuously: either the commuter model at
+// TripsPerHour, or flows between named zones.
+type Demand struct {
+	TripsPerHour float64   `yaml:"trips_per_hour"`
+	Profile      string    `yaml:"profile"` // daily (default), morning, evening or flat
+	Hourly       []float64 `yaml:"hourly"`  // 24 factors instead of a named profile
+	Zones        []Zone    `yaml:"zones"`
+	Flows        []Flow    `yaml:"flows"`
+}
+
+// Zone is a named area where trips start or end.
+type Zone struct {
+	Name string `yaml:"name"`
+	Area `yaml:",inline"`
+}
+
+// Flow is trips per hour between two zones at profile factor 1.
+type Flow struct {
+	From         string    `yaml:"from"`
+	To           string    `yaml:"to"`
+	TripsPerHour float64   `yaml:"trips_per_hour"`
+	Profile      string    `yaml:"profile"` // the demand's profile if empty
+	Hourly       []float64 `yaml:"hourly"`
+}
+
+// Congestion predicts the congestion multiplier of an area by hour of the
+// day, for routes that depart or arrive at a set time. The engine replaces
+// each hour's prediction with what it learns once it has seen enough of it.
+type Congestion struct {
+	Area    `yaml:",inline"`
+	Profile string    `yaml:"profile"` // daily, morning, evening or flat
+	Hourly  []float64 `yaml:"hourly"`  // 24 multipliers instead of a named profile
+}
+
+// ValidationError lists every problem found in a scenario, each prefixed
+// with the path of the offending field.
+type ValidationError struct {
+	Scenario string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("scenario %q is invalid:\n  %s", e.Scenario, strings.Join(e.Problems, "\n  "))
+}
+
+// Parse decodes a YAML or JSON scenario and validates it. Unknown fields are errors.
+func Parse(data []byte) (*Scenario, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var s Scenario
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("parse scenario: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// LoadFile reads and validates a scenario file. A scenario without a name is
+// named after the file.
+func LoadFile(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return s, nil
+}
+
+//go:embed scenarios
+var builtin embed.FS
+
+// extensions are the file types Load looks for, in order.
+var extensions = []string{".yaml", ".yml", ".json"}
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ErrNotFound is returned by Load for a name no directory defines.
+var ErrNotFound = errors.New("scenario not found")
+
+// Load finds the scenario called name in dirs, in order, and then among the
+// built-in scenarios. Names are lower case letters, digits, '-' and '_'.
+func Load(name string, dirs ...string) (*Scenario, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid scenario name %q", name)
+	}
+	for _, dir := range dirs {
+		for _, ext := range extensions {
+			path := filepath.Join(dir, name+ext)
+			if _, err := os.Stat(path); err == nil {
+				return LoadFile(path)
+			}
+		}
+	}
+	for _, ext := range extensions {
+		data, err := builtin.ReadFile("scenarios/" + name + ext)
+		if err != nil {
+			continue
+		}
+		s, err := Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("built-in %s: %w", name, err)
+		}
+		if s.Name == "" {
+			s.Name = name
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrNotFound, name)
+}
+
+// Names lists the scenarios Load can find in dirs and among the built-in ones.
+func Names(dirs ...string) []string {
+	seen := make(map[string]bool)
+	add := func(file string) {
+		for _, ext := range extensions {
+			if name, ok := strings.CutSuffix(file, ext); ok && validName.MatchString(name) {
+				seen[name] = true
+			}
+		}
+	}
+	for _, dir := range dirs {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			add(e.Name())
+		}
+	}
+	entries, _ := fs.ReadDir(builtin, "scenarios")
+	for _, e := range entries {
+		add(e.Name())
+	}
+	out := make([]string, 0, len(seen))
+	for name := range seen {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Validate checks the scenario against the schema and the grid, and returns a
+// *ValidationError listing every problem.
+func (s *Scenario) Validate() error {
+	_, err := s.EngineConfig()
+	return err
+}
+
+// EngineConfig returns the engine configuration the scenario describes.
+func (s *Scenario) EngineConfig() (sim.EngineConfig, error) {
+	v := &validator{s: s}
+	v.check()
+	if len(v.problems) > 0 {
+		return sim.EngineConfig{}, &ValidationError{Scenario: s.Name, Problems: v.problems}
+	}
+	cfg := sim.EngineConfig{
+		Width:     s.Width,
+		Height:    s.Height,
+		Vehicles:  s.Vehicles,
+		Seed:      s.Seed,
+		Control:   s.Control,
+		StartTime: v.start,
+		Duration:  v.duration,
+		Blocked:   make(map[[2]int]bool),
+		DefaultCapacity: sim.CellCapacity{
+			Lanes:   max(s.Lanes, 1),
+			Storage: max(s.LaneStorage, 1),
+		},
+		Intersections: v.intersections,
+		Demand:        v.demand,
+	}
+	if len(v.congestion) > 0 {
+		cfg.CongestionProfiles = v.congestion
+	}
+	for y := 0; y < s.Height; y++ {
+		for x := 0; x < s.Width; x++ {
+			if !v.open(x, y) {
+				cfg.Blocked[[2]int{x, y}] = true
+			}
+		}
+	}
+	for i, inc := range s.Incidents {
+		cfg.ScheduledIncidents = append(cfg.ScheduledIncidents, sim.ScheduledIncident{
+			Incident: sim.Incident{
+				ID: inc.ID, Type: sim.IncidentType(inc.Type), X: inc.At[0], Y: inc.At[1], Severity: inc.Severity,
+			},
+			At:       v.incidentTimes[i][0],
+			Duration: v.incidentTimes[i][1],
+		})
+	}
+	if s.Kinematics {
+		k := sim.DefaultKinematics()
+		cfg.Kinematics = &k
+	}
+	if s.RandomIncidents {
+		ic := sim.DefaultIncidentGeneratorConfig()
+		cfg.Incidents = &ic
+	}
+	return cfg, nil
+}
+
+// Ticks returns how many ticks the scenario runs for, or 0 if unlimited.
+func (s *Scenario) Ticks() int64 {
+	d, err := parseDuration(s.Duration)
+	if err != nil {
+		return 0
+	}
+	return int64(d / time.Second)
+}
+
+// validator collects problems while converting the scenario.
+type validator struct {
+	s        *Scenario
+	problems []string
+
+	roads, closed map[[2]int]bool
+	start         time.Duration
+	duration      time.Duration
+	intersections []sim.IntersectionConfig
+	incidentTimes [][2]time.Duration // start and duration of each incident
+	demand        *sim.DemandModel
+	congestion    map[[2]int]sim.Profile
+}
+
+func (v *validator) addf(path, format string, args ...any) {
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+// open reports whether (x,y) is a road cell that is not closed.
+func (v *validator) open(x, y int) bool {
+	k := [2]int{x, y}
+	return (v.roads == nil || v.roads[k]) && !v.closed[k]
+}
+
+func (v *validator) check() {
+	s := v.s
+	if s.Width < 1 || s.Width > MaxSize {
+		v.addf("width", "must be between 1 and %d, got %d", MaxSize, s.Width)
+	}
+	if s.Height < 1 || s.Height > MaxSize {
+		v.addf("height", "must be between 1 and %d, got %d", MaxSize, s.Height)
+	}
+	if len(v.problems) > 0 {
+		return // nothing else can be checked against the grid
+	}
+	if s.StartTime != "" {
+		t, err := time.Parse("15:04", s.StartTime)
+		if err != nil {
+			v.addf("start_time", "must be HH:MM, got %q", s.StartTime)
+		}
+		v.start = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if s.Duration != "" {
+		d, err := parseDuration(s.Duration)
+		if err != nil || d <= 0 {
+			v.addf("duration", "must be a positive duration such as 2h or 7200, got %q", s.Duration)
+		}
+		v.duration = d
+	}
+	if s.Vehicles < 0 {
+		v.addf("vehicles", "must not be negative")
+	}
+	if s.Lanes < 0 || s.LaneStorage < 0 {
+		v.addf("lanes", "lanes and lane_storage must not be negative")
+	}
+	v.checkControl("control", s.Control)
+
+	if len(s.Roads) > 0 {
+		v.roads = make(map[[2]int]bool)
+		for i, a := range s.Roads {
+			v.area(fmt.Sprintf("roads[%d]", i), a, v.roads)
+		}
+	}
+	v.closed = make(map[[2]int]bool)
+	for i, a := range s.Closures {
+		v.area(fmt.Sprintf("closures[%d]", i), a, v.closed)
+	}
+	v.checkIntersections()
+	v.checkIncidents()
+	if s.Demand != nil {
+		v.checkDemand(s.Demand)
+	}
+	v.checkCongestion()
+}
+
+func (v *validator) checkControl(path, control string) {
+	if _, err := sim.NewController(control, sim.SignalPlan{}); err != nil {
+		v.addf(path, "must be %s, %s or %s, got %q", sim.ControlFixed, sim.ControlActuated, sim.ControlMaxPressure, control)
+	}
+}
+
+// cell checks p is an [x, y] pair inside the grid.
+func (v *validator) cell(path string, p []int) ([2]int, bool) {
+	if len(p) != 2 {
+		v.addf(path, "must be [x, y], got %v", p)
+		return [2]int{}, false
+	}
+	if p[0] < 0 || p[0] >= v.s.Width || p[1] < 0 || p[1] >= v.s.Height {
+		v.addf(path, "(%d,%d) is outside the %dx%d grid", p[0], p[1], v.s.Width, v.s.Height)
+		return [2]int{}, false
+	}
+	return [2]int{p[0], p[1]}, true
+}
+
+// rect checks an area and returns its corners in order.
+func (v *validator) rect(path string, a Area) (lo, hi [2]int, ok bool) {
+	if a.From == nil {
+		v.addf(path+".from", "is required")
+		return lo, hi, false
+	}
+	from, ok1 := v.cell(path+".from", a.From)
+	to, ok2 := from, true
+	if a.To != nil {
+		to, ok2 = v.cell(path+".to", a.To)
+	}
+	if !ok1 || !ok2 {
+		return lo, hi, false
+	}
+	lo = [2]int{min(from[0], to[0]), min(from[1], to[1])}
+	hi = [2]int{max(from[0], to[0]), max(from[1], to[1])}
+	return lo, hi, true
+}
+
+func (v *validator) area(path string, a Area, into map[[2]int]bool) {
+	lo, hi, ok := v.rect(path, a)
+	if !ok {
+		return
+	}
+	for y := lo[1]; y <= hi[1]; y++ {
+		for x := lo[0]; x <= hi[0]; x++ {
+			into[[2]int{x, y}] = true
+		}
+	}
+}
+
+// onRoad checks an intersection or incident sits on an open road cell.
+func (v *validator) onRoad(path string, p []int) ([2]int, bool) {
+	k, ok := v.cell(path, p)
+	if !ok {
+		return k, false
+	}
+	if !v.open(k[0], k[1]) {
+		v.addf(path, "(%d,%d) is not an open road cell", k[0], k[1])
+		return k, false
+	}
+	return k, true
+}
+
+var headings = map[string]grid.Direction{"north": grid.North, "east": grid.East, "south": grid.South, "west": grid.West}
+
+var turns = map[string]grid.Turn{"straight": grid.Straight, "right": grid.Right, "left": grid.Left, "uturn": grid.UTurn}
+
+func (v *validator) checkIntersections() {
+	seen := make(map[[2]int]int)
+	for i, it := range v.s.Intersections {
+		path := fmt.Sprintf("intersections[%d]", i)
+		k, ok := v.onRoad(path+".at", it.At)
+		if ok {
+			if j, dup := seen[k]; dup {
+				v.addf(path+".at", "(%d,%d) repeats intersections[%d]", k[0], k[1], j)
+			}
+			seen[k] = i
+		}
+		v.checkControl(path+".control", it.Control)
+		ic := sim.IntersectionConfig{X: k[0], Y: k[1], Control: it.Control, Plan: sim.SignalPlan{Offset: it.Offset}}
+		names := make(map[string]bool)
+		for j, ph := range it.Phases {
+			ic.Plan.Phases = append(ic.Plan.Phases, v.phase(fmt.Sprintf("%s.phases[%d]", path, j), ph, names))
+		}
+		for j, t := range it.NoTurns {
+			turn, ok := turns[t]
+			if !ok {
+				v.addf(fmt.Sprintf("%s.no_turns[%d]", path, j), "unknown turn %q; use straight, right, left or uturn", t)
+				continue
+			}
+			if ic.Turns.Prohibited == nil {
+				ic.Turns.Prohibited = make(map[grid.Turn]bool)
+			}
+			ic.Turns.Prohibited[turn] = true
+		}
+		for t, cost := range it.TurnPenalty {
+			turn, ok := turns[t]
+			if !ok || !(cost >= 0) || math.IsInf(cost, 1) {
+				v.addf(path+".turn_penalty."+t, "must be a finite non-negative cost for straight, right, left or uturn")
+				continue
+			}
+			if ic.Turns.Penalty == nil {
+				ic.Turns.Penalty = make(map[grid.Turn]float64)
+			}
+			ic.Turns.Penalty[turn] = cost
+		}
+		v.intersections = append(v.intersections, ic)
+	}
+	if v.intersections == nil {
+		v.intersections = []sim.IntersectionConfig{} // no signals rather than the default four
+	}
+}
+
+func (v *validator) phase(path string, ph Phase, names map[string]bool) sim.Phase {
+	out := sim.Phase{Name: ph.Name, GreenSec: ph.GreenSec, YellowSec: 3, AllRedSec: 2}
+	if ph.Name == "" {
+		v.addf(path+".name", "is required")
+	} else if names[ph.Name] {
+		v.addf(path+".name", "%q is used by another phase", ph.Name)
+	}
+	names[ph.Name] = true
+	if ph.GreenSec < 1 {
+		v.addf(path+".green_sec", "must be at least 1, got %d", ph.GreenSec)
+	}
+	if ph.YellowSec != nil {
+		out.YellowSec = *ph.YellowSec
+	}
+	if ph.AllRedSec != nil {
+		out.AllRedSec = *ph.AllRedSec
+	}
+	if out.YellowSec < 0 || out.AllRedSec < 0 {
+		v.addf(path, "yellow_sec and all_red_sec must not be negative")
+	}
+	if len(ph.Green) == 0 {
+		v.addf(path+".green", "must list at least one movement")
+	}
+	for j, m := range ph.Green {
+		heading, turn, _ := strings.Cut(m, ":")
+		d, ok1 := headings[heading]
+		t, ok2 := turns[turn]
+		if !ok1 || !ok2 {
+			v.addf(fmt.Sprintf("%s.green[%d]", path, j), "%q is not <heading>:<turn>, e.g. north:straight", m)
+			continue
+		}
+		out.Green = append(out.Green, sim.Movement{Approach: d, Turn: t})
+	}
+	return out
+}
+
+func (v *validator) checkIncidents() {
+	ids := make(map[string]bool)
+	for i, inc := range v.s.Incidents {
+		path := fmt.Sprintf("incidents[%d]", i)
+		if inc.ID != "" && ids[inc.ID] {
+			v.addf(path+".id", "%q is used by another incident", inc.ID)
+		}
+		ids[inc.ID] = true
+		var times [2]time.Duration
+		k, _ := v.onRoad(path+".at", inc.At)
+		si := sim.Incident{Type: sim.IncidentType(inc.Type), X: k[0], Y: k[1], Severity: inc.Severity}
+		if err := si.Validate(); err != nil {
+			v.addf(path, "%v", err)
+		}
+		if inc.Start != "" {
+			d, err := parseDuration(inc.Start)
+			if err != nil || d < 0 {
+				v.addf(path+".start", "must be a duration from the start of the run, got %q", inc.Start)
+			}
+			times[0] = d
+		}
+		if inc.Duration != "" {
+			d, err := parseDuration(inc.Duration)
+			if err != nil || d <= 0 {
+				v.addf(path+".duration", "must be a positive duration, got %q", inc.Duration)
+			}
+			times[1] = d
+		}
+		v.incidentTimes = append(v.incidentTimes, times)
+	}
+}
+
+var profiles = map[string]sim.Profile{
+	"daily":   sim.DailyProfile,
+	"morning": sim.MorningPeakProfile,
+	"evening": sim.EveningPeakProfile,
+	"flat":    {1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+}
+
+// profile resolves a named or hourly profile; ok is false if neither is given.
+func (v *validator) profile(path, name string, hourly []float64) (p sim.Profile, ok bool) {
+	switch {
+	case name != "" && hourly != nil:
+		v.addf(path, "set profile or hourly, not both")
+	case hourly != nil:
+		if len(hourly) != 24 {
+			v.addf(path+".hourly", "must have 24 factors, got %d", len(hourly))
+			return p, false
+		}
+		for h, f := range hourly {
+			if f < 0 {
+				v.addf(fmt.Sprintf("%s.hourly[%d]", path, h), "must not be negative")
+			}
+			p[h] = f
+		}
+		return p, true
+	case name != "":
+		p, ok = profiles[name]
+		if !ok {
+			v.addf(path+".profile", "unknown profile %q; use daily, morning, evening or flat", name)
+		}
+		return p, ok
+	}
+	return p, false
+}
+
+// checkCongestion resolves the congestion predictions; a later entry wins
+// where areas overlap.
+func (v *validator) checkCongestion() {
+	for i, c := range v.s.Congestion {
+		path := fmt.Sprintf("congestion[%d]", i)
+		p, ok := v.profile(path, c.Profile, c.Hourly)
+		if !ok && c.Profile == "" && c.Hourly == nil {
+			v.addf(path, "set profile or hourly")
+		}
+		cells := make(map[[2]int]bool)
+		v.area(path, c.Area, cells)
+		if !ok {
+			continue
+		}
+		if v.congestion == nil {
+			v.congestion = make(map[[2]int]sim.Profile)
+		}
+		for k := range cells {
+			v.congestion[k] = p
+		}
+	}
+}
+
+// checkDemand resolves the demand model and checks it as the engine would,
+// and that every zone has an open road cell for trips to start and end on.
+func (v *validator) checkDemand(d *Demand) {
+	n := len(v.problems)
+	m := v.demandModel(d)
+	if m == nil || len(v.problems) > n {
+		return
+	}
+	if err := m.Validate(v.s.Width, v.s.Height); err != nil {
+		v.addf("demand", "%v", err)
+		return
+	}
+	for _, z := range m.Zones {
+		if !v.anyOpen(z) {
+			v.addf("demand", "zone %q (%d,%d)-(%d,%d) has no open road cell", z.Name, z.MinX, z.MinY, z.MaxX, z.MaxY)
+		}
+	}
+	v.demand = m
+}
+
+// anyOpen reports whether z has an open road cell.
+func (v *validator) anyOpen(z sim.Zone) bool {
+	for y := z.MinY; y <= z.MaxY; y++ {
+		for x := z.MinX; x <= z.MaxX; x++ {
+			if v.open(x, y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// demandModel converts d, or returns nil if it generates no trips.
+func (v *validator) demandModel(d *Demand) *sim.DemandModel {
+	if d.TripsPerHour < 0 {
+		v.addf("demand.trips_per_hour", "must not be negative")
+	}
+	def, _ := v.profile("demand", d.Profile, d.Hourly)
+	if len(d.Zones) == 0 {
+		if len(d.Flows) > 0 {
+			v.addf("demand.flows", "need zones")
+		}
+		if d.TripsPerHour > 0 {
+			m := sim.CommuterDemand(v.s.Width, v.s.Height, d.TripsPerHour)
+			if def != (sim.Profile{}) {
+				m.Profile = def
+			}
+			return &m
+		}
+		return nil
+	}
+	if d.TripsPerHour != 0 {
+		v.addf("demand.trips_per_hour", "set trips_per_hour for the commuter model, or zones and flows, not both")
+	}
+	m := &sim.DemandModel{Profile: def}
+	index := make(map[string]int)
+	for i, z := range d.Zones {
+		path := fmt.Sprintf("demand.zones[%d]", i)
+		if z.Name == "" {
+			v.addf(path+".name", "is required")
+		} else if _, dup := index[z.Name]; dup {
+			v.addf(path+".name", "%q is used by another zone", z.Name)
+		}
+		index[z.Name] = i
+		lo, hi, _ := v.rect(path, z.Area)
+		m.Zones = append(m.Zones, sim.Zone{Name: z.Name, MinX: lo[0], MinY: lo[1], MaxX: hi[0], MaxY: hi[1]})
+	}
+	for i, f := range d.Flows {
+		path := fmt.Sprintf("demand.flows[%d]", i)
+		from, ok1 := index[f.From]
+		to, ok2 := index[f.To]
+		if !ok1 {
+			v.addf(path+".from", "unknown zone %q", f.From)
+		}
+		if !ok2 {
+			v.addf(path+".to", "unknown zone %q", f.To)
+		}
+		if f.TripsPerHour < 0 {
+			v.addf(path+".trips_per_hour", "must not be negative")
+		}
+		flow := sim.ODFlow{From: from, To: to, TripsPerHour: f.TripsPerHour}
+		if p, ok := v.profile(path, f.Profile, f.Hourly); ok {
+			flow.Profile = &p
+		}
+		m.Flows = append(m.Flows, flow)
+	}
+	if len(m.Flows) == 0 {
+		v.addf("demand.flows", "must list at least one flow between zones")
+	}
+	return m
+}
+
+// parseDuration reads a Go duration or a plain number of seconds.
+func parseDuration(s string) (time.Duration, error) {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		if math.IsNaN(n) || math.Abs(n) > math.MaxInt64/float64(time.Second) {
+			return 0, fmt.Errorf("%q seconds is not finite or out of range", s)
+		}
+		return time.Duration(n * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,203 @@
+package scenario_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	scenario "routeiq/internal/scenario"
+	sim "routeiq/internal/sim"
+)
+
+func TestLoad_BuiltInScenarios(t *testing.T) {
+	names := scenario.Names()
+	for _, want := range []string{"arterial", "downtown", "grid20"} {
+		s, err := scenario.Load(want)
+		if err != nil {
+			t.Fatalf("load %s: %v", want, err)
+		}
+		if s.Name != want {
+			t.Fatalf("expected scenario named %s, got %q", want, s.Name)
+		}
+		if !strings.Contains(strings.Join(names, ","), want) {
+			t.Fatalf("expected %s among %v", want, names)
+		}
+	}
+	if _, err := scenario.Load("nowhere"); !errors.Is(err, scenario.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := scenario.Load("../etc/passwd"); err == nil {
+		t.Fatalf("expected a path to be refused as a name")
+	}
+}
+
+func TestLoad_DirectoryOverridesBuiltIn(t *testing.T) {
+	dir := t.TempDir()
+	data := "name: grid20\nwidth: 8\nheight: 6\n"
+	if err := os.WriteFile(filepath.Join(dir, "grid20.yml"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := scenario.Load("grid20", dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if s.Width != 8 || s.Height != 6 {
+		t.Fatalf("expected the directory's scenario, got %dx%d", s.Width, s.Height)
+	}
+}
+
+func TestParse_ReportsEveryProblem(t *testing.T) {
+	_, err := scenario.Parse([]byte(`
+name: broken
+width: 10
+height: 10
+duration: soon
+roads:
+  - {from: [0, 0], to: [9, 0]}
+intersections:
+  - at: [4, 4]
+  - at: [3, 0]
+    control: psychic
+    phases:
+      - {name: a, green: [north:sideways], green_sec: 0}
+incidents:
+  - {type: flood, at: [1, 0], severity: 2}
+  - {id: crash, type: accident, at: [2, 0], severity: 2, start: NaN}
+  - {id: crash, type: accident, at: [3, 0], severity: 2, duration: Inf}
+demand:
+  zones: [{name: here, from: [0, 0], to: [12, 0]}]
+  flows: [{from: here, to: there, trips_per_hour: 10}]
+congestion:
+  - {from: [0, 0], hourly: [2, 2]}
+`))
+	var verr *scenario.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	for _, want := range []string{
+		"duration:",
+		"intersections[0].at: (4,4) is not an open road cell",
+		"intersections[1].control:",
+		"intersections[1].phases[0].green_sec:",
+		`intersections[1].phases[0].green[0]: "north:sideways"`,
+		"incidents[0]:",
+		`incidents[1].start: must be a duration from the start of the run, got "NaN"`,
+		`incidents[2].id: "crash" is used by another incident`,
+		`incidents[2].duration: must be a positive duration, got "Inf"`,
+		"demand.zones[0].to: (12,0) is outside the 10x10 grid",
+		`demand.flows[0].to: unknown zone "there"`,
+		"congestion[0].hourly: must have 24 factors, got 2",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q in:\n%v", want, err)
+		}
+	}
+	// the commuter model's quadrants are empty on a grid one cell wide
+	if _, err := scenario.Parse([]byte("width: 1\nheight: 10\ndemand: {trips_per_hour: 100}\n")); err == nil || !strings.Contains(err.Error(), `demand: zone "northwest"`) {
+		t.Fatalf("expected the commuter model to be checked against the grid, got %v", err)
+	}
+	_, err = scenario.Parse([]byte(`
+width: 10
+height: 10
+roads:
+  - {from: [0, 0], to: [9, 0]}
+demand:
+  zones: [{name: a, from: [0, 0], to: [3, 0]}, {name: b, from: [0, 5], to: [9, 9]}]
+  flows: [{from: a, to: b, trips_per_hour: 10}]
+`))
+	if err == nil || !strings.Contains(err.Error(), `demand: zone "b" (0,5)-(9,9) has no open road cell`) {
+		t.Fatalf("expected a zone off the roads to be reported, got %v", err)
+	}
+	if _, err := scenario.Parse([]byte("width: 5\nheight: 5\ncolour: red\n")); err == nil || !strings.Contains(err.Error(), "colour") {
+		t.Fatalf("expected unknown field to be reported, got %v", err)
+	}
+}
+
+func TestEngineConfig_CongestionPredictions(t *testing.T) {
+	s, err := scenario.Parse([]byte(`
+width: 10
+height: 10
+start_time: "08:00"
+congestion:
+  - {from: [0, 0], to: [9, 0], profile: morning}
+  - {from: [5, 0], hourly: [1, 1, 1, 1, 1, 1, 1, 1, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1]}
+`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	cfg, err := s.EngineConfig()
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	if len(cfg.CongestionProfiles) != 10 || cfg.CongestionProfiles[[2]int{5, 0}][8] != 3 {
+		t.Fatalf("expected the row predicted with the later entry winning, got %v", cfg.CongestionProfiles)
+	}
+	p, _, _ := sim.NewEngine(cfg).CongestionProfile(2, 0)
+	if p[8] != max(sim.MorningPeakProfile[8], 1) || p[8] <= 1 {
+		t.Fatalf("expected the morning peak predicted at 08:00, got %v", p)
+	}
+}
+
+func TestEngineConfig_DowntownRuns(t *testing.T) {
+	s, err := scenario.Load("downtown")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	cfg, err := s.EngineConfig()
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	if !cfg.Blocked[[2]int{3, 3}] || cfg.Blocked[[2]int{3, 5}] || !cfg.Blocked[[2]int{19, 7}] {
+		t.Fatalf("expected buildings and the closure blocked and streets open")
+	}
+	if cfg.Duration != time.Hour || cfg.StartTime != 7*time.Hour+30*time.Minute || s.Ticks() != 3600 {
+		t.Fatalf("expected a one-hour run from 07:30, got %v from %v", cfg.Duration, cfg.StartTime)
+	}
+	e := sim.NewEngine(cfg)
+	if got := len(e.Grid().Intersections()); got != 9 {
+		t.Fatalf("expected 9 intersections, got %d", got)
+	}
+	for _, sig := range e.Signals() {
+		if sig.X == 10 && sig.Y == 10 && sig.Phase != "north-south" {
+			t.Fatalf("expected the scenario's plan downtown, got phase %s", sig.Phase)
+		}
+	}
+	if _, ok := findIncident(e, "roadworks-5-12"); !ok {
+		t.Fatalf("expected roadworks from the start")
+	}
+	if _, ok := findIncident(e, "crash-10-7"); ok {
+		t.Fatalf("expected the accident to wait for 10 minutes")
+	}
+	for i := 0; i < 600; i++ {
+		e.Step()
+	}
+	if inc, ok := findIncident(e, "crash-10-7"); !ok || inc.Resolved {
+		t.Fatalf("expected the accident raised at 10 minutes, got %+v", inc)
+	}
+	for !e.Finished() {
+		e.Step()
+	}
+	if inc, _ := findIncident(e, "crash-10-7"); !inc.Resolved || inc.ResolvedTick != 1800 {
+		t.Fatalf("expected the accident resolved after 20 minutes, got %+v", inc)
+	}
+	if e.Ticks() != 3600 || e.Stats().Departures == 0 {
+		t.Fatalf("expected the run to finish at one hour with demand, got %d ticks, %+v", e.Ticks(), e.Stats())
+	}
+	for _, v := range e.Vehicles() {
+		if cfg.Blocked[[2]int{v.X, v.Y}] {
+			t.Fatalf("expected every vehicle on a street, found one at (%d,%d)", v.X, v.Y)
+		}
+	}
+}
+
+func findIncident(e *sim.Engine, id string) (sim.Incident, bool) {
+	for _, inc := range e.Incidents(true) {
+		if inc.ID == id {
+			return inc, true
+		}
+	}
+	return sim.Incident{}, false
+}
@@ -0,0 +1,259 @@
+package sim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxAlternatives bounds the alternatives one route query may ask for.
+const MaxAlternatives = 5
+
+// alternativeOverlap is the largest share of its steps an alternative may
+// have in common with the best route or any alternative already chosen.
+const alternativeOverlap = 0.7
+
+// yenPathsPerRoute bounds the loopless paths Yen's algorithm ranks per route
+// asked for, so a query whose next-best paths all overlap gives up.
+const yenPathsPerRoute = 10
+
+// yenExpansions bounds the states the searches for alternatives may expand
+// in all, so a long route on a large grid, which needs a search from every
+// cell of every path found, cannot hold up the simulation; alternatives not
+// found by then are left out.
+const yenExpansions = 1 << 16
+
+// RouteDiff summarises how an alternative differs from the best route.
+type RouteDiff struct {
+	ExtraSeconds  float64 // ETA minus the best route's; negative if faster
+	ExtraDistance int     // cells moved minus the best route's
+	Shared        float64 // share of the best route's steps this one also takes, 0..1
+	DivergeAt     [2]int  // last cell in common with the best route before they part
+	RejoinAt      [2]int  // first cell back on the best route after they part
+	Summary       string  // the above in words
+}
+
+// Alternative is a route other than the best one.
+type Alternative struct {
+	Route
+	Differences RouteDiff
+}
+
+// Alternatives returns the best route from (sx,sy) to (gx,gy), as Route
+// does, and up to n alternatives in increasing cost. Candidates come from
+// Yen's k-shortest loopless paths; one is kept only if at most 70% of its
+// steps are shared with each route already kept, so the alternatives are
+// genuinely different ways rather than small detours. Fewer than n are
+// returned if the network has no more sufficiently different routes, or if
+// finding them would take more than yenExpansions search states. With
+// RouteOptions.ArriveBy the alternatives leave when the best route does, so
+// they may arrive after the deadline.
+func (e *Engine) Alternatives(sx, sy, gx, gy int, opts RouteOptions, n int) (Route, []Alternative, error) {
+	if err := opts.Validate(); err != nil {
+		return Route{}, nil, err
+	}
+	if n < 0 || n > MaxAlternatives {
+		return Route{}, nil, fmt.Errorf("alternatives must be between 0 and %d, got %d", MaxAlternatives, n)
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	q, first, err := e.planLocked(sx, sy, gx, gy, opts)
+	if err != nil {
+		return Route{}, nil, err
+	}
+	ys := newYenSearch(e.pf, first, q)
+	best := e.routeAlongLocked(first, q)
+	kept := []map[[2]point]bool{steps(first)}
+	var alts []Alternative
+	for tries := 0; len(alts) < n && tries < yenPathsPerRoute*n; tries++ {
+		path, ok := ys.next()
+		if !ok {
+			break
+		}
+		own := steps(path)
+		distinct := true
+		for _, other := range kept {
+			if overlap(own, other) > alternativeOverlap {
+				distinct = false
+				break
+			}
+		}
+		if !distinct {
+			continue
+		}
+		kept = append(kept, own)
+		r := e.routeAlongLocked(path, q)
+		alts = append(alts, Alternative{Route: r, Differences: diffRoutes(best, r, kept[0], own)})
+	}
+	return best, alts, nil
+}
+
+// steps returns the moves of a path.
+func steps(path []point) map[[2]point]bool {
+	out := make(map[[2]point]bool, len(path))
+	for i := 1; i < len(path); i++ {
+		out[[2]point{path[i-1], path[i]}] = true
+	}
+	return out
+}
+
+// overlap is the share of a's moves that b also makes.
+func overlap(a, b map[[2]point]bool) float64 {
+	if len(a) == 0 {
+		return 1
+	}
+	shared := 0
+	for s := range a {
+		if b[s] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a))
+}
+
+func diffRoutes(best, alt Route, bestSteps, altSteps map[[2]point]bool) RouteDiff {
+	d := RouteDiff{
+		ExtraSeconds:  alt.ETASeconds - best.ETASeconds,
+		ExtraDistance: alt.Distance - best.Distance,
+		Shared:        overlap(bestSteps, altSteps),
+	}
+	i := 0
+	for i+1 < len(best.Path) && i+1 < len(alt.Path) && best.Path[i+1] == alt.Path[i+1] {
+		i++
+	}
+	d.DivergeAt = alt.Path[i]
+	j, k := len(best.Path)-1, len(alt.Path)-1
+	for j > i && k > i && best.Path[j-1] == alt.Path[k-1] {
+		j, k = j-1, k-1
+	}
+	d.RejoinAt = alt.Path[k]
+
+	var parts []string
+	switch {
+	case d.ExtraSeconds >= 0.5:
+		parts = append(parts, fmt.Sprintf("%.0f s slower", d.ExtraSeconds))
+	case d.ExtraSeconds <= -0.5:
+		parts = append(parts, fmt.Sprintf("%.0f s faster", -d.ExtraSeconds))
+	default:
+		parts = append(parts, "as fast")
+	}
+	switch {
+	case d.ExtraDistance > 0:
+		parts = append(parts, fmt.Sprintf("%d cells longer", d.ExtraDistance))
+	case d.ExtraDistance < 0:
+		parts = append(parts, fmt.Sprintf("%d cells shorter", -d.ExtraDistance))
+	}
+	d.Summary = fmt.Sprintf("%s; shares %.0f%% of the best route, leaving it at (%d,%d) and rejoining at (%d,%d)",
+		strings.Join(parts, " and "), 100*d.Shared, d.DivergeAt[0], d.DivergeAt[1], d.RejoinAt[0], d.RejoinAt[1])
+	return d
+}
+
+// yenSearch ranks the loopless paths between two cells by cost with Yen's
+// algorithm: each next path is the cheapest deviation from one already
+// found, branching off at one of its cells with the moves every found path
+// sharing that prefix takes from there forbidden.
+type yenSearch struct {
+	pf          *PathFinder
+	start, goal point
+	q           query
+	found       [][]point
+	cands       []yenCandidate
+	seen        map[string]bool // paths found or queued
+	budget      int             // states the spur searches may still expand
+}
+
+type yenCandidate struct {
+	path []point
+	cost float64
+	key  string
+}
+
+// newYenSearch ranks the paths after best, the cheapest path under q.
+func newYenSearch(pf *PathFinder, best []point, q query) *yenSearch {
+	return &yenSearch{
+		pf: pf, start: best[0], goal: best[len(best)-1], q: q,
+		found: [][]point{best}, seen: map[string]bool{pathKey(best): true},
+		budget: yenExpansions,
+	}
+}
+
+func pathKey(path []point) string {
+	var b strings.Builder
+	for _, pt := range path {
+		fmt.Fprintf(&b, "%d,%d;", pt.X, pt.Y)
+	}
+	return b.String()
+}
+
+// next returns the next cheapest path, or false when there are no more or
+// the budget ran out before they could be ranked.
+func (y *yenSearch) next() ([]point, bool) {
+	y.branch(y.found[len(y.found)-1])
+	if y.budget <= 0 || len(y.cands) == 0 {
+		return nil, false
+	}
+	sort.Slice(y.cands, func(i, j int) bool {
+		a, b := y.cands[i], y.cands[j]
+		if a.cost != b.cost {
+			return a.cost < b.cost
+		}
+		if len(a.path) != len(b.path) {
+			return len(a.path) < len(b.path)
+		}
+		return a.key < b.key
+	})
+	c := y.cands[0]
+	y.cands = y.cands[1:]
+	y.found = append(y.found, c.path)
+	return c.path, true
+}
+
+// branch queues the cheapest deviation from last at each of its cells.
+func (y *yenSearch) branch(last []point) {
+	tm, _ := y.pf.timePath(last, y.q)
+	for i := 0; i+1 < len(last) && y.budget > 0; i++ {
+		root := last[:i+1]
+		forbidden := make(map[[2]point]bool)
+		for _, p := range y.found {
+			if len(p) > i+1 && samePrefix(p, root) {
+				forbidden[[2]point{p[i], p[i+1]}] = true
+			}
+		}
+		onRoot := make(map[point]bool, i)
+		for _, pt := range root[:i] {
+			onRoot[pt] = true
+		}
+		sq := y.q
+		if i > 0 {
+			sq.heading = dirBetween(root[i-1], root[i])
+			sq.at, sq.ready, sq.midway = tm.reach[i], tm.ready[i], true
+		}
+		sq.avoid = func(pt point) bool { return onRoot[pt] || (y.q.avoid != nil && y.q.avoid(pt)) }
+		sq.forbid = func(from, to point) bool { return forbidden[[2]point{from, to}] }
+		sq.expansions = &y.budget
+		spur, _ := y.pf.search(root[i].X, root[i].Y, y.goal.X, y.goal.Y, sq)
+		if spur == nil {
+			continue
+		}
+		path := append(append(make([]point, 0, i+len(spur)), root[:i]...), spur...)
+		key := pathKey(path)
+		if y.seen[key] {
+			continue
+		}
+		y.seen[key] = true
+		ct, ok := y.pf.timePath(path, y.q)
+		if !ok {
+			continue
+		}
+		y.cands = append(y.cands, yenCandidate{path: path, cost: ct.total, key: key})
+	}
+}
+
+func samePrefix(p, prefix []point) bool {
+	for i := range prefix {
+		if p[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
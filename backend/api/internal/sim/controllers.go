@@ -0,0 +1,253 @@
+package sim
+
+import (
+	"fmt"
+
+	grid "routeiq/internal/grid"
+)
+
+// Queues is the demand a controller sees at its intersection.
+type Queues struct {
+	In  map[Movement]int       // vehicles approaching, by the movement they will make
+	Out map[grid.Direction]int // vehicles queued on the exit leading away in each direction
+}
+
+// Controller decides the lights at one intersection. The engine calls Step
+// once per simulated second with the live queues.
+type Controller interface {
+	Step(q Queues)
+	StateFor(m Movement) string
+	Plan() SignalPlan
+	Phase() Phase
+	Stage() (string, int)
+}
+
+// Step advances a fixed-time signal by one second; queues are ignored.
+func (s *Signal) Step(Queues) { s.Tick() }
+
+// phaseRunner sequences phases through green, yellow and all-red for
+// controllers whose green times are decided on the fly.
+type phaseRunner struct {
+	plan    SignalPlan
+	phases  []Phase
+	greens  []map[Movement]bool
+	phase   int
+	next    int
+	stage   string
+	elapsed int
+}
+
+func newPhaseRunner(plan SignalPlan) phaseRunner {
+	s := NewSignal(plan) // normalises the phases
+	return phaseRunner{plan: s.plan, phases: s.plan.Phases, greens: s.greens, stage: StageGreen}
+}
+
+func (r *phaseRunner) Plan() SignalPlan { return r.plan }
+
+func (r *phaseRunner) StateFor(m Movement) string {
+	if !r.greens[r.phase][m] || r.stage == StageAllRed {
+		return "red"
+	}
+	return r.stage
+}
+
+func (r *phaseRunner) Phase() Phase { return r.phases[r.phase] }
+
+func (r *phaseRunner) Stage() (string, int) { return r.stage, r.elapsed }
+
+// greenWait forecasts the lights as if the current phase and the ones after
+// it, in plan order, ran their nominal times; a green that has already run
+// past its nominal time is expected to end now. Decisions the controller makes
+// on live demand later are not predicted.
+func (r *phaseRunner) greenWait(after int, m Movement) (int, bool) {
+	t := 0
+	for _, ph := range r.phases[:r.phase] {
+		t += ph.Duration()
+	}
+	ph := r.phases[r.phase]
+	switch r.stage {
+	case StageGreen:
+		t += min(r.elapsed, ph.GreenSec-1)
+	case StageYellow:
+		t += ph.GreenSec + r.elapsed
+	case StageAllRed:
+		t += ph.GreenSec + ph.YellowSec + r.elapsed
+	}
+	s := &Signal{plan: r.plan, greens: r.greens, cycle: r.plan.CycleLength(), t: t}
+	return s.greenWait(after, m)
+}
+
+// step advances one second. While green, decide returns the phase to switch
+// to, or -1 (or the current phase) to hold green.
+func (r *phaseRunner) step(decide func() int) {
+	r.elapsed++
+	ph := r.phases[r.phase]
+	switch r.stage {
+	case StageGreen:
+		if n := decide(); n >= 0 && n != r.phase {
+			r.next = n
+			r.stage, r.elapsed = StageYellow, 0
+		}
+	case StageYellow:
+		if r.elapsed >= ph.YellowSec {
+			r.stage, r.elapsed = StageAllRed, 0
+		}
+	case StageAllRed:
+		if r.elapsed >= ph.AllRedSec {
+			r.phase = r.next
+			r.stage, r.elapsed = StageGreen, 0
+		}
+	}
+	// zero-length yellow or all-red stages are skipped immediately
+	if r.stage == StageYellow && ph.YellowSec == 0 {
+		r.stage = StageAllRed
+	}
+	if r.stage == StageAllRed && ph.AllRedSec == 0 {
+		r.phase = r.next
+		r.stage, r.elapsed = StageGreen, 0
+	}
+}
+
+// demand returns the number of vehicles waiting for movements of phase i.
+func (r *phaseRunner) demand(i int, q Queues) int {
+	n := 0
+	for m, c := range q.In {
+		if r.greens[i][m] {
+			n += c
+		}
+	}
+	return n
+}
+
+// ActuatedConfig bounds an actuated controller's green times, in seconds.
+type ActuatedConfig struct {
+	MinGreen int // green always lasts at least this long
+	MaxGreen int // green is cut off after this long if another phase is waiting
+	Passage  int // green is extended this long after each detected vehicle
+}
+
+// DefaultActuatedConfig returns common actuated timings.
+func DefaultActuatedConfig() ActuatedConfig {
+	return ActuatedConfig{MinGreen: 5, MaxGreen: 40, Passage: 3}
+}
+
+// ActuatedController extends green while vehicles keep arriving on the green
+// movements, between MinGreen and MaxGreen. When green gaps out it moves to
+// the next phase with waiting vehicles; with no demand elsewhere it rests in green.
+type ActuatedController struct {
+	phaseRunner
+	cfg ActuatedConfig
+	gap int // seconds of extension left
+}
+
+// NewActuatedController runs the phases of plan (their GreenSec is ignored)
+// under actuated control.
+func NewActuatedController(plan SignalPlan, cfg ActuatedConfig) *ActuatedController {
+	cfg.MinGreen = max(cfg.MinGreen, 1)
+	cfg.MaxGreen = max(cfg.MaxGreen, cfg.MinGreen)
+	return &ActuatedController{phaseRunner: newPhaseRunner(plan), cfg: cfg, gap: cfg.Passage}
+}
+
+func (c *ActuatedController) Step(q Queues) {
+	c.step(func() int {
+		if c.demand(c.phase, q) > 0 {
+			c.gap = c.cfg.Passage
+		} else if c.gap > 0 {
+			c.gap--
+		}
+		if c.elapsed < c.cfg.MinGreen || (c.gap > 0 && c.elapsed < c.cfg.MaxGreen) {
+			return -1
+		}
+		for i := 1; i < len(c.phases); i++ {
+			n := (c.phase + i) % len(c.phases)
+			if c.demand(n, q) > 0 {
+				c.gap = c.cfg.Passage
+				return n
+			}
+		}
+		return -1
+	})
+}
+
+// MaxPressureConfig bounds a max-pressure controller's green times, in seconds.
+type MaxPressureConfig struct {
+	MinGreen int // green always lasts at least this long before pressure is re-evaluated
+	MaxGreen int // if positive, green is cut off after this long when another phase has pressure
+}
+
+// DefaultMaxPressureConfig returns common max-pressure timings.
+func DefaultMaxPressureConfig() MaxPressureConfig {
+	return MaxPressureConfig{MinGreen: 5, MaxGreen: 60}
+}
+
+// MaxPressureController gives green to the phase with the highest pressure:
+// vehicles waiting for its movements minus vehicles already queued on the
+// exits those movements feed. It re-evaluates every second after MinGreen and
+// never switches to a phase nobody is waiting for.
+type MaxPressureController struct {
+	phaseRunner
+	cfg MaxPressureConfig
+}
+
+// NewMaxPressureController runs the phases of plan (their GreenSec is ignored)
+// under max-pressure control.
+func NewMaxPressureController(plan SignalPlan, cfg MaxPressureConfig) *MaxPressureController {
+	cfg.MinGreen = max(cfg.MinGreen, 1)
+	return &MaxPressureController{phaseRunner: newPhaseRunner(plan), cfg: cfg}
+}
+
+func (c *MaxPressureController) pressure(i int, q Queues) int {
+	p := 0
+	for m, n := range q.In {
+		if !c.greens[i][m] {
+			continue
+		}
+		p += n - q.Out[m.Approach.Turned(m.Turn)]
+	}
+	return p
+}
+
+func (c *MaxPressureController) Step(q Queues) {
+	c.step(func() int {
+		if c.elapsed < c.cfg.MinGreen {
+			return -1
+		}
+		best, bestP := c.phase, c.pressure(c.phase, q)
+		for i := range c.phases {
+			if p := c.pressure(i, q); p > bestP && c.demand(i, q) > 0 {
+				best, bestP = i, p
+			}
+		}
+		if best == c.phase && c.cfg.MaxGreen > 0 && c.elapsed >= c.cfg.MaxGreen {
+			// serve the strongest competing phase that has any demand
+			best, bestP = -1, 0
+			for i := range c.phases {
+				if p := c.pressure(i, q); i != c.phase && c.demand(i, q) > 0 && (best < 0 || p > bestP) {
+					best, bestP = i, p
+				}
+			}
+		}
+		return best
+	})
+}
+
+// Signal control strategies accepted by NewController.
+const (
+	ControlFixed       = "fixed"
+	ControlActuated    = "actuated"
+	ControlMaxPressure = "max-pressure"
+)
+
+// NewController builds a controller of the given kind running plan's phases
+// with default timings. An empty kind means fixed-time.
+func NewController(kind string, plan SignalPlan) (Controller, error) {
+	switch kind {
+	case "", ControlFixed:
+		return NewSignal(plan), nil
+	case ControlActuated:
+		return NewActuatedController(plan, DefaultActuatedConfig()), nil
+	case ControlMaxPressure:
+		return NewMaxPressureController(plan, DefaultMaxPressureConfig()), nil
+	}
+	return nil, fmt.Errorf("unknown signal control %q", kind)
+}
@@ -0,0 +1,259 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Zone is a rectangle of cells, from (MinX,MinY) to (MaxX,MaxY) inclusive.
+type Zone struct {
+	Name       string
+	MinX, MinY int
+	MaxX, MaxY int
+}
+
+// Contains reports whether (x,y) lies in the zone.
+func (z Zone) Contains(x, y int) bool {
+	return x >= z.MinX && x <= z.MaxX && y >= z.MinY && y <= z.MaxY
+}
+
+// Profile is a rate factor for each hour of the day. Factors are
+// interpolated linearly between the starts of consecutive hours.
+type Profile [24]float64
+
+// At returns the factor at time of day t.
+func (p Profile) At(t time.Duration) float64 {
+	h := float64(t%(24*time.Hour)) / float64(time.Hour)
+	i := int(h)
+	frac := h - float64(i)
+	return p[i]*(1-frac) + p[(i+1)%24]*frac
+}
+
+// Standard daily profiles.
+var (
+	// DailyProfile has a morning peak around 08:00 and an evening peak around 17:00.
+	DailyProfile = Profile{
+		0.2, 0.1, 0.1, 0.1, 0.2, 0.4, 0.9, 1.8, 2.5, 1.8, 1.1, 1.0,
+		1.1, 1.1, 1.0, 1.2, 1.8, 2.4, 1.9, 1.2, 0.9, 0.7, 0.5, 0.3,
+	}
+	// MorningPeakProfile is concentrated around 08:00, such as trips to work.
+	MorningPeakProfile = Profile{
+		0.1, 0, 0, 0, 0.1, 0.5, 1.5, 3.5, 5, 3, 1, 0.5,
+		0.4, 0.3, 0.3, 0.3, 0.3, 0.3, 0.2, 0.2, 0.2, 0.1, 0.1, 0.1,
+	}
+	// EveningPeakProfile is concentrated around 17:00, such as trips home.
+	EveningPeakProfile = Profile{
+		0.1, 0, 0, 0, 0, 0.1, 0.2, 0.3, 0.3, 0.3, 0.3, 0.4,
+		0.5, 0.5, 0.6, 1.2, 3, 5, 3.5, 1.5, 0.8, 0.5, 0.3, 0.2,
+	}
+)
+
+// ODFlow is one entry of an origin-destination matrix: trips per hour from
+// zone From to zone To at profile factor 1.
+type ODFlow struct {
+	From, To     int
+	TripsPerHour float64
+	Profile      *Profile // nil uses the model's profile
+}
+
+// DemandModel is a sparse origin-destination matrix between zones. Trips
+// arrive as a Poisson process whose rate follows each flow's daily profile.
+type DemandModel struct {
+	Zones   []Zone
+	Flows   []ODFlow
+	Profile Profile // default profile for flows without one; DailyProfile if all zero
+}
+
+// Validate checks zones lie within a width x height grid and flows reference them.
+func (m DemandModel) Validate(width, height int) error {
+	if len(m.Zones) == 0 {
+		return errors.New("demand model has no zones")
+	}
+	for _, z := range m.Zones {
+		if z.MinX > z.MaxX || z.MinY > z.MaxY || z.MinX < 0 || z.MinY < 0 || z.MaxX >= width || z.MaxY >= height {
+			return fmt.Errorf("zone %q (%d,%d)-(%d,%d) is empty or outside the %dx%d grid",
+				z.Name, z.MinX, z.MinY, z.MaxX, z.MaxY, width, height)
+		}
+	}
+	for _, f := range m.Flows {
+		if f.From < 0 || f.From >= len(m.Zones) || f.To < 0 || f.To >= len(m.Zones) {
+			return fmt.Errorf("flow %d->%d references a missing zone", f.From, f.To)
+		}
+		if f.TripsPerHour < 0 {
+			return fmt.Errorf("flow %d->%d has negative demand", f.From, f.To)
+		}
+	}
+	return nil
+}
+
+// rates returns the trip rate per second of each flow at time of day t.
+func (m DemandModel) rates(t time.Duration) []float64 {
+	def := m.Profile
+	if def == (Profile{}) {
+		def = DailyProfile
+	}
+	out := make([]float64, len(m.Flows))
+	for i, f := range m.Flows {
+		p := def
+		if f.Profile != nil {
+			p = *f.Profile
+		}
+		out[i] = f.TripsPerHour * p.At(t) / 3600
+	}
+	return out
+}
+
+// CommuterDemand returns a model for a width x height grid with a central
+// downtown zone and four residential quadrants. Trips per hour (at profile
+// factor 1) are split between morning commutes into downtown, evening
+// commutes home, and all-day trips between quadrants.
+func CommuterDemand(width, height int, tripsPerHour float64) DemandModel {
+	w, h := width/2, height/2
+	zones := []Zone{
+		{Name: "downtown", MinX: width / 3, MinY: height / 3, MaxX: (2*width - 1) / 3, MaxY: (2*height - 1) / 3},
+		{Name: "northwest", MinX: 0, MinY: 0, MaxX: w - 1, MaxY: h - 1},
+		{Name: "northeast", MinX: w, MinY: 0, MaxX: width - 1, MaxY: h - 1},
+		{Name: "southwest", MinX: 0, MinY: h, MaxX: w - 1, MaxY: height - 1},
+		{Name: "southeast", MinX: w, MinY: h, MaxX: width - 1, MaxY: height - 1},
+	}
+	m := DemandModel{Zones: zones, Profile: DailyProfile}
+	morning, evening := MorningPeakProfile, EveningPeakProfile
+	for r := 1; r <= 4; r++ {
+		m.Flows = append(m.Flows,
+			ODFlow{From: r, To: 0, TripsPerHour: tripsPerHour * 0.35 / 4, Profile: &morning},
+			ODFlow{From: 0, To: r, TripsPerHour: tripsPerHour * 0.35 / 4, Profile: &evening},
+		)
+		for o := 1; o <= 4; o++ {
+			if o != r {
+				m.Flows = append(m.Flows, ODFlow{From: r, To: o, TripsPerHour: tripsPerHour * 0.3 / 12})
+			}
+		}
+	}
+	return m
+}
+
+// demandStream is the seededSource stream used for trip generation.
+const demandStream = 2
+
+type demandGenerator struct {
+	model DemandModel
+	src   *rand.ChaCha8
+	rng   *rand.Rand
+
+	cells   [][]point // open cells of each zone, listed at network version
+	version uint64
+}
+
+func newDemandGenerator(m DemandModel, seed uint64) *demandGenerator {
+	src := seededSource(seed, demandStream)
+	return &demandGenerator{model: m, src: src, rng: rand.New(src)}
+}
+
+// SetDemand replaces the demand model; nil stops generating trips. Vehicles
+// already on the road are unaffected.
+func (e *Engine) SetDemand(m *DemandModel) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if m == nil {
+		e.demand = nil
+		return nil
+	}
+	if err := m.Validate(e.grid.Width, e.grid.Height); err != nil {
+		return err
+	}
+	g := newDemandGenerator(*m, e.Seed())
+	if err := e.checkZonesLocked(g); err != nil {
+		return err
+	}
+	e.demand = g
+	return nil
+}
+
+// zoneCellsLocked returns the open cells of each zone of g, listing them again
+// whenever the network has changed since they were last listed.
+func (e *Engine) zoneCellsLocked(g *demandGenerator) [][]point {
+	if g.cells != nil && g.version == e.pf.Version() {
+		return g.cells
+	}
+	g.cells, g.version = make([][]point, len(g.model.Zones)), e.pf.Version()
+	for i, z := range g.model.Zones {
+		for y := z.MinY; y <= z.MaxY; y++ {
+			for x := z.MinX; x <= z.MaxX; x++ {
+				if !e.pf.isBlocked(x, y) {
+					g.cells[i] = append(g.cells[i], point{x, y})
+				}
+			}
+		}
+	}
+	return g.cells
+}
+
+// checkZonesLocked reports a zone of g in which no trip can start or end.
+func (e *Engine) checkZonesLocked(g *demandGenerator) error {
+	for i, cells := range e.zoneCellsLocked(g) {
+		if len(cells) == 0 {
+			z := g.model.Zones[i]
+			return fmt.Errorf("zone %q (%d,%d)-(%d,%d) has no open cells", z.Name, z.MinX, z.MinY, z.MaxX, z.MaxY)
+		}
+	}
+	return nil
+}
+
+// generateDemandLocked adds the trips arriving this tick, each between two
+// distinct open cells drawn uniformly from its flow's zones. Trips that cannot
+// be placed, because closures left a zone without open cells, are counted in
+// Stats.UnservedTrips.
+func (e *Engine) generateDemandLocked() {
+	g := e.demand
+	if g == nil {
+		return
+	}
+	rates := g.model.rates(e.timeOfDayLocked())
+	total := 0.0
+	for _, r := range rates {
+		total += r
+	}
+	for n := poisson(g.rng, total); n > 0; n-- {
+		f := g.model.Flows[weightedIndex(g.rng, rates)]
+		cells := e.zoneCellsLocked(g)
+		from, to, ok := drawTrip(g.rng, cells[f.From], cells[f.To])
+		if !ok {
+			e.stats.UnservedTrips++
+			continue
+		}
+		v := &Vehicle{
+			Type: VehicleCar, X: from.X, Y: from.Y, Speed: 1.0,
+			DestX: to.X, DestY: to.Y, DepartTick: e.ticks,
+		}
+		e.equipLocked(v)
+		e.vehicles.Add(v)
+		e.stats.Departures++
+	}
+}
+
+// drawTrip draws an origin from origins and a different destination from
+// dests, or reports false if there is no such pair.
+func drawTrip(rng *rand.Rand, origins, dests []point) (point, point, bool) {
+	if len(origins) == 0 || len(dests) == 0 {
+		return point{}, point{}, false
+	}
+	from := origins[rng.IntN(len(origins))]
+	// draw the destination among the cells other than from
+	i, n := -1, len(dests)
+	for j, c := range dests {
+		if c == from {
+			i, n = j, n-1
+			break
+		}
+	}
+	if n == 0 {
+		return point{}, point{}, false
+	}
+	j := rng.IntN(n)
+	if i >= 0 && j >= i {
+		j++
+	}
+	return from, dests[j], true
+}
@@ -0,0 +1,54 @@
+package sim
+
+// preemptReach is how many cells ahead of an emergency vehicle signals are
+// preempted: enough for a full yellow and all-red clearance before it arrives.
+const preemptReach = 8
+
+// EmergencyResponse records one completed emergency trip.
+type EmergencyResponse struct {
+	VehicleID       string
+	DispatchTick    int64
+	ArrivalTick     int64
+	ResponseSeconds int64 // simulated seconds from dispatch to arrival
+}
+
+// EmergencySummary aggregates emergency response times.
+type EmergencySummary struct {
+	Responses   []EmergencyResponse
+	MeanSeconds float64
+	MaxSeconds  int64
+}
+
+// preemptLocked requests green at the next signal within preemptReach cells
+// on every emergency vehicle's route.
+func (e *Engine) preemptLocked(vehicles []*Vehicle) {
+	for _, v := range vehicles {
+		if !v.Type.Emergency() {
+			continue
+		}
+		v.ensureRoute(e.pf, point{v.DestX, v.DestY})
+		for i := v.routeIdx + 1; i < len(v.route) && i <= v.routeIdx+preemptReach; i++ {
+			if sig, ok := e.signals[[2]int{v.route[i].X, v.route[i].Y}]; ok {
+				sig.Request(v.movementAt(i))
+				break
+			}
+		}
+	}
+}
+
+// EmergencyResponses returns response-time metrics for completed emergency trips.
+func (e *Engine) EmergencyResponses() EmergencySummary {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := EmergencySummary{Responses: make([]EmergencyResponse, len(e.responses))}
+	copy(out.Responses, e.responses)
+	total := int64(0)
+	for _, r := range out.Responses {
+		total += r.ResponseSeconds
+		out.MaxSeconds = max(out.MaxSeconds, r.ResponseSeconds)
+	}
+	if n := len(out.Responses); n > 0 {
+		out.MeanSeconds = float64(total) / float64(n)
+	}
+	return out
+}
@@ -0,0 +1,755 @@
+package sim
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+
+	grid "routeiq/internal/grid"
+)
+
+// detectorReach is how many cells upstream of an intersection vehicles count
+// towards its queues, and how far downstream exit queues are measured.
+const detectorReach = 6
+
+// congestionWeight scales local vehicle density (0..1 over a 3x3 block) into
+// the congestion multiplier routes see: a fully packed block costs 1+congestionWeight.
+const congestionWeight = 2.0
+
+// DefaultTickInterval is the wall-clock time between ticks when none is configured.
+// One tick always advances the simulation by one simulated second.
+const DefaultTickInterval = time.Second
+
+// EngineConfig configures a simulation engine.
+type EngineConfig struct {
+	Width        int
+	Height       int
+	Vehicles     int                      // vehicles spawned at construction
+	TickInterval time.Duration            // wall-clock time per tick; DefaultTickInterval if zero
+	Blocked      map[[2]int]bool          // blocked cells passed to the PathFinder
+	ClosedEdges  [][4]int                 // moves {fromX, fromY, toX, toY} between neighbouring cells that are not allowed, e.g. against a one-way street
+	Seed         uint64                   // drives all randomness; a random seed is chosen if zero
+	SignalPlan   SignalPlan               // plan for every intersection; DefaultSignalPlan if it has no phases
+	Control      string                   // signal control strategy (see NewController); fixed-time if empty or unknown
+	StartTime    time.Duration            // simulated time of day at tick zero
+	Incidents    *IncidentGeneratorConfig // random incidents; none if nil
+	Demand       *DemandModel             // continuous trip generation; none if nil, logged and ignored if invalid
+
+	DefaultCapacity CellCapacity            // road space of every cell; one lane of one vehicle if zero
+	Capacity        map[[2]int]CellCapacity // per-cell overrides
+
+	Kinematics  *Kinematics        // given to spawned and generated vehicles, and added ones without their own; none if nil, logged and ignored if invalid
+	SpeedLimits map[[2]int]float64 // per-cell speed limits in cells per second; 1 if absent
+
+	CongestionProfiles map[[2]int]Profile   // predicted congestion multiplier of cells by hour, until the run has taught the engine its own
+	Intersections      []IntersectionConfig // replace the grid's default intersections when non-nil
+	ScheduledIncidents []ScheduledIncident  // incidents raised and resolved at fixed times
+	Duration           time.Duration        // simulated run length; the tick loop pauses once reached, never if zero
+}
+
+// IntersectionConfig places a signalised intersection.
+type IntersectionConfig struct {
+	X, Y    int
+	Plan    SignalPlan     // EngineConfig.SignalPlan if it has no phases
+	Control string         // EngineConfig.Control if empty
+	Turns   grid.TurnRules // movement rules for vehicles passing through
+}
+
+// Engine owns the grid, lights and vehicles of a simulation and advances them
+// together on a fixed tick interval.
+type Engine struct {
+	mu        sync.RWMutex
+	grid      *grid.Grid
+	pf        *PathFinder
+	signals   controllerSet
+	vehicles  *VehicleManager
+	occ       *Occupancy
+	congested map[[2]int]bool // cells with a congestion multiplier set last tick
+	profiles  *congestionProfiles
+	interval  time.Duration
+	ticks     int64
+	stats     Stats
+	stalled   map[[2]int]int // cells holding vehicles that waited last tick
+
+	// vehicles counted through each signal by movement, since flowSince
+	flows        map[[2]int]map[Movement]int
+	flowSince    int64
+	coordination *CoordinationPlan
+
+	responses  []EmergencyResponse // completed emergency trips
+	trips      []Trip              // most recent completed trips, up to tripHistory
+	tripTotals tripTotals
+
+	incidents   *IncidentStore
+	baseBlocked map[[2]int]bool // cells blocked by the config, independent of incidents
+	bus         *eventBus
+	generator   *incidentGenerator // nil when random incidents are off
+	start       time.Duration      // time of day at tick zero
+	demand      *demandGenerator   // nil when no demand model is set
+	kinematics  Kinematics         // given to vehicles added without their own
+	duration    time.Duration      // run length; unlimited if zero
+
+	schedule     []ScheduledIncident // by start time
+	scheduled    int                 // schedule entries raised so far
+	scheduleEnds map[string]int64    // scheduled incident ID -> tick it resolves
+
+	// run loop control, guarded by ctl
+	ctl     sync.Mutex
+	running bool
+	paused  bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewEngine builds an engine with one Signal per grid intersection and
+// spawns cfg.Vehicles vehicles. The engine is idle until Start or Step is called.
+// Two engines built from the same config and seed evolve identically.
+func NewEngine(cfg EngineConfig) *Engine {
+	if cfg.TickInterval <= 0 {
+		cfg.TickInterval = DefaultTickInterval
+	}
+	for cfg.Seed == 0 {
+		cfg.Seed = rand.Uint64()
+	}
+	g := grid.NewGrid(cfg.Width, cfg.Height)
+	custom := make(map[[2]int]IntersectionConfig, len(cfg.Intersections))
+	if cfg.Intersections != nil {
+		its := make([]grid.Intersection, 0, len(cfg.Intersections))
+		for _, ic := range cfg.Intersections {
+			if err := ic.Turns.Validate(); err != nil {
+				log.Printf("sim: ignoring the turn rules of (%d,%d): %v", ic.X, ic.Y, err)
+			}
+			its = append(its, grid.Intersection{X: ic.X, Y: ic.Y, Turns: ic.Turns})
+			if _, dup := custom[[2]int{ic.X, ic.Y}]; !dup {
+				custom[[2]int{ic.X, ic.Y}] = ic
+			}
+		}
+		g = grid.NewGridWithIntersections(cfg.Width, cfg.Height, its)
+	}
+	blocked := make(map[[2]int]bool, len(cfg.Blocked))
+	for k, b := range cfg.Blocked {
+		if b {
+			blocked[k] = true
+		}
+	}
+	pfBlocked := make(map[[2]int]bool, len(blocked))
+	for k := range blocked {
+		pfBlocked[k] = true
+	}
+	e := &Engine{
+		grid:      g,
+		pf:        NewPathFinder(cfg.Width, cfg.Height, pfBlocked),
+		signals:   make(controllerSet),
+		vehicles:  NewSeededVehicleManager(cfg.Seed),
+		occ:       NewOccupancy(),
+		congested: make(map[[2]int]bool),
+		profiles:  newCongestionProfiles(cfg.CongestionProfiles),
+		flows:     make(map[[2]int]map[Movement]int),
+		interval:  cfg.TickInterval,
+
+		incidents:   NewIncidentStore(),
+		baseBlocked: blocked,
+		bus:         newEventBus(),
+		start:       cfg.StartTime,
+		duration:    cfg.Duration,
+	}
+	if cfg.Incidents != nil {
+		e.generator = newIncidentGenerator(*cfg.Incidents, cfg.Seed)
+	}
+	for _, it := range g.Intersections() {
+		control, plan := cfg.Control, cfg.SignalPlan
+		if ic, ok := custom[[2]int{it.X, it.Y}]; ok {
+			if ic.Control != "" {
+				control = ic.Control
+			}
+			if len(ic.Plan.Phases) > 0 {
+				plan = ic.Plan
+			}
+		}
+		c, err := NewController(control, plan)
+		if err != nil {
+			c = NewSignal(plan)
+		}
+		e.signals[[2]int{it.X, it.Y}] = newPreemptible(c)
+		e.pf.SetTurnRules(it.X, it.Y, it.Turns)
+	}
+	e.occ.Default = cfg.DefaultCapacity
+	for k, c := range cfg.Capacity {
+		e.occ.SetCapacity(k[0], k[1], c)
+	}
+	for k, l := range cfg.SpeedLimits {
+		e.pf.SetSpeedLimit(k[0], k[1], l)
+	}
+	for _, ed := range cfg.ClosedEdges {
+		e.pf.SetEdge(ed[0], ed[1], ed[2], ed[3], false)
+	}
+	if cfg.Kinematics != nil {
+		if err := cfg.Kinematics.Validate(); err != nil {
+			log.Printf("sim: ignoring the configured kinematics: %v", err)
+		} else {
+			e.kinematics = *cfg.Kinematics
+		}
+	}
+	if cfg.Demand != nil {
+		g := newDemandGenerator(*cfg.Demand, cfg.Seed)
+		err := cfg.Demand.Validate(cfg.Width, cfg.Height)
+		if err == nil {
+			err = e.checkZonesLocked(g)
+		}
+		if err != nil {
+			log.Printf("sim: ignoring the configured demand model: %v", err)
+		} else {
+			e.demand = g
+		}
+	}
+	if len(cfg.ScheduledIncidents) > 0 {
+		e.schedule = append([]ScheduledIncident(nil), cfg.ScheduledIncidents...)
+		sort.SliceStable(e.schedule, func(i, j int) bool { return e.schedule[i].At < e.schedule[j].At })
+		e.scheduleEnds = make(map[string]int64)
+		e.runScheduleLocked()
+	}
+	if cfg.Vehicles > 0 {
+		e.Spawn(cfg.Vehicles)
+	}
+	return e
+}
+
+// Start runs the tick loop in the background, or resumes it if paused.
+func (e *Engine) Start() {
+	e.ctl.Lock()
+	defer e.ctl.Unlock()
+	e.paused = false
+	if e.running {
+		return
+	}
+	e.running = true
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	go e.loop(e.stop, e.done)
+}
+
+// Pause suspends ticking without stopping the loop. Step still works while paused.
+func (e *Engine) Pause() {
+	e.ctl.Lock()
+	defer e.ctl.Unlock()
+	e.paused = true
+}
+
+// Stop terminates the tick loop and waits for it to exit. The engine keeps its
+// state and may be started again.
+func (e *Engine) Stop() {
+	e.ctl.Lock()
+	if !e.running {
+		e.ctl.Unlock()
+		return
+	}
+	e.running = false
+	close(e.stop)
+	done := e.done
+	e.ctl.Unlock()
+	<-done
+}
+
+// Running reports whether the tick loop is active and not paused.
+func (e *Engine) Running() bool {
+	e.ctl.Lock()
+	defer e.ctl.Unlock()
+	return e.running && !e.paused
+}
+
+func (e *Engine) loop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			e.ctl.Lock()
+			paused := e.paused
+			e.ctl.Unlock()
+			if !paused {
+				e.Step()
+				if e.Finished() {
+					e.Pause()
+				}
+			}
+		}
+	}
+}
+
+// Step advances the simulation by exactly one tick: lights first, then vehicles.
+func (e *Engine) Step() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	vehicles := e.vehicles.List()
+	e.startTripsLocked(vehicles)
+	queues := e.queuesLocked(vehicles)
+	e.preemptLocked(vehicles)
+	for k, c := range e.signals {
+		c.Step(queues[k])
+	}
+	before := make([]point, len(vehicles))
+	entering := make([]Movement, len(vehicles))
+	for i, v := range vehicles {
+		before[i] = point{v.X, v.Y}
+		if v.planned && v.routeIdx+1 < len(v.route) {
+			entering[i] = v.nextMovement()
+		}
+	}
+	moves := MoveOneTick(vehicles, e.pf, e.signals, e.occ, nil)
+	e.stats.Blocked = moves.Blocked
+	e.stats.GridlockCycles += int64(moves.Cycles)
+	e.stats.GridlockVehicles += int64(moves.Rotated)
+	e.stats.Reroutes += int64(moves.Rerouted)
+	e.updateCongestionLocked(vehicles)
+	e.ticks++
+	e.profiles.observe(e.timeOfDayLocked(), e.pf.congestion)
+	e.generateIncidentsLocked()
+	e.runScheduleLocked()
+	e.generateDemandLocked()
+
+	e.stats.Moving, e.stats.Waiting = 0, 0
+	e.stalled = make(map[[2]int]int)
+	var speed float64
+	for i, v := range vehicles {
+		speed += v.Speed
+		crossed := (point{v.X, v.Y}) != before[i]
+		if !crossed && v.Speed > 0 { // kinematic vehicle moving within its cell
+			e.stats.Moving++
+			v.trackTrip(false, true, false)
+		} else if crossed {
+			e.stats.Moving++
+			k := [2]int{v.X, v.Y}
+			if _, ok := e.signals[k]; ok {
+				if e.flows[k] == nil {
+					e.flows[k] = make(map[Movement]int)
+				}
+				e.flows[k][entering[i]]++
+			}
+			v.trackTrip(true, true, false)
+		} else if _, ok := v.nextStep(); ok {
+			e.stats.Waiting++
+			e.stalled[[2]int{v.X, v.Y}]++
+			v.trackTrip(false, false, true)
+		}
+	}
+	e.stats.MeanSpeed = 0
+	if len(vehicles) > 0 {
+		e.stats.MeanSpeed = speed / float64(len(vehicles))
+	}
+	e.stats.WaitTicks += int64(e.stats.Waiting)
+	e.completeTripsLocked(vehicles)
+}
+
+// Subscribe returns a channel receiving engine events and a function that
+// cancels the subscription and closes the channel. Events are dropped for a
+// subscriber whose buffer is full rather than stalling the simulation.
+func (e *Engine) Subscribe(buffer int) (<-chan Event, func()) {
+	return e.bus.subscribe(buffer)
+}
+
+func (e *Engine) publishLocked(typ string, data any) {
+	e.bus.publish(Event{Type: typ, Tick: e.ticks, Timestamp: time.Now(), Data: data})
+}
+
+// CellQueue is a cell holding vehicles that are waiting.
+type CellQueue struct {
+	X, Y     int
+	Vehicles int // vehicles in the cell
+	Waiting  int // of which did not move last tick although they had somewhere to go
+	Capacity CellCapacity
+	Full     bool // no room for another vehicle, so the queue spills back upstream
+}
+
+// Queues returns the cells where vehicles waited last tick, in row-major order.
+func (e *Engine) Queues() []CellQueue {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	counts := make(map[[2]int]int)
+	for _, v := range e.vehicles.List() {
+		counts[[2]int{v.X, v.Y}]++
+	}
+	out := make([]CellQueue, 0, len(e.stalled))
+	for k, n := range e.stalled {
+		c := e.occ.Capacity(k[0], k[1])
+		out = append(out, CellQueue{X: k[0], Y: k[1], Vehicles: counts[k], Waiting: n, Capacity: c, Full: counts[k] >= c.Vehicles()})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Y != out[j].Y {
+			return out[i].Y < out[j].Y
+		}
+		return out[i].X < out[j].X
+	})
+	return out
+}
+
+// SetCellCapacity sets the lanes and per-lane storage of (x,y). It returns
+// false if the cell is outside the grid.
+func (e *Engine) SetCellCapacity(x, y int, c CellCapacity) bool {
+	if !e.grid.IsValid(x, y) {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.occ.SetCapacity(x, y, c)
+	return true
+}
+
+// Stats summarises the simulation so far.
+type Stats struct {
+	Ticks      int64
+	Vehicles   int
+	Moving     int     // vehicles that moved last tick
+	Waiting    int     // vehicles with somewhere to go that stood still last tick
+	MeanSpeed  float64 // mean speed of all vehicles last tick, cells per second
+	WaitTicks  int64   // cumulative vehicle-seconds spent waiting
+	Departures int64   // trips generated by the demand model
+
+	Blocked          int   // vehicles held up by other vehicles (not lights) last tick
+	GridlockCycles   int64 // wait-for cycles resolved by moving their vehicles together
+	GridlockVehicles int64 // vehicles moved to resolve those cycles
+	Reroutes         int64 // vehicles rerouted after being blocked for too long
+	UnservedTrips    int64 // demand trips dropped as a zone had no open cell for them
+}
+
+// Stats returns current simulation statistics.
+func (e *Engine) Stats() Stats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	st := e.stats
+	st.Ticks = e.ticks
+	st.Vehicles = e.vehicles.Count()
+	return st
+}
+
+// queuesLocked measures, for every signal, the vehicles due to pass through it
+// within detectorReach cells (by movement) and the vehicles stalled on each exit.
+func (e *Engine) queuesLocked(vehicles []*Vehicle) map[[2]int]Queues {
+	out := make(map[[2]int]Queues, len(e.signals))
+	for k := range e.signals {
+		q := Queues{In: make(map[Movement]int), Out: make(map[grid.Direction]int, 4)}
+		for _, d := range grid.Directions {
+			dx, dy := d.Delta()
+			for i := 1; i <= detectorReach; i++ {
+				q.Out[d] += e.stalled[[2]int{k[0] + dx*i, k[1] + dy*i}]
+			}
+		}
+		out[k] = q
+	}
+	for _, v := range vehicles {
+		if !v.planned {
+			continue
+		}
+		for i := v.routeIdx + 1; i < len(v.route) && i <= v.routeIdx+detectorReach; i++ {
+			k := [2]int{v.route[i].X, v.route[i].Y}
+			if q, ok := out[k]; ok {
+				q.In[v.movementAt(i)]++
+				break
+			}
+		}
+	}
+	return out
+}
+
+// updateCongestionLocked feeds local vehicle density back into the PathFinder
+// so new routes steer around crowded cells.
+func (e *Engine) updateCongestionLocked(vehicles []*Vehicle) {
+	counts := make(map[[2]int]int)
+	for _, v := range vehicles {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				counts[[2]int{v.X + dx, v.Y + dy}]++
+			}
+		}
+	}
+	for k := range e.congested {
+		if _, ok := counts[k]; !ok {
+			e.pf.SetCongestion(k[0], k[1], 1)
+			delete(e.congested, k)
+		}
+	}
+	for k, n := range counts {
+		if !e.grid.IsValid(k[0], k[1]) {
+			continue
+		}
+		e.pf.SetCongestion(k[0], k[1], 1+congestionWeight*float64(n)/9)
+		e.congested[k] = true
+	}
+}
+
+// CellCost returns the current routing cost of entering (x,y).
+func (e *Engine) CellCost(x, y int) float64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.pf.CellCost(x, y)
+}
+
+// Ticks returns the number of ticks simulated so far.
+func (e *Engine) Ticks() int64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.ticks
+}
+
+// TimeOfDay returns the simulated time of day: StartTime plus one second per tick, modulo 24h.
+func (e *Engine) TimeOfDay() time.Duration {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.timeOfDayLocked()
+}
+
+func (e *Engine) timeOfDayLocked() time.Duration {
+	day := 24 * time.Hour
+	t := (e.start + time.Duration(e.ticks)*time.Second) % day
+	if t < 0 {
+		t += day
+	}
+	return t
+}
+
+// Finished reports whether the configured run duration has elapsed. Step
+// still advances a finished engine.
+func (e *Engine) Finished() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.duration > 0 && time.Duration(e.ticks)*time.Second >= e.duration
+}
+
+// Seed returns the seed driving this run; pass it back in EngineConfig to replay it.
+func (e *Engine) Seed() uint64 { return e.vehicles.Seed() }
+
+// TickInterval returns the wall-clock duration of one tick.
+func (e *Engine) TickInterval() time.Duration { return e.interval }
+
+// Grid returns the grid the engine was built with.
+func (e *Engine) Grid() *grid.Grid { return e.grid }
+
+// SetTurnRules sets the movement rules of the intersection at (x,y) in both
+// the grid and the routing graph. Returns false if there is no intersection
+// there or the rules have a negative or non-finite penalty.
+func (e *Engine) SetTurnRules(x, y int, rules grid.TurnRules) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.grid.SetTurnRules(x, y, rules) {
+		return false
+	}
+	e.pf.SetTurnRules(x, y, rules)
+	return true
+}
+
+// SignalState describes a signal for reporting.
+type SignalState struct {
+	X, Y       int
+	Phase      string
+	Stage      string                    // green, yellow or all-red
+	Elapsed    int                       // seconds in the current stage
+	Preempted  bool                      // overridden for an emergency vehicle
+	Approaches map[grid.Direction]string // light shown to through traffic on each approach
+}
+
+// Signals returns the state of every signal, ordered by position.
+func (e *Engine) Signals() []SignalState {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := make([]SignalState, 0, len(e.signals))
+	for _, it := range e.grid.Intersections() {
+		sig, ok := e.signals[[2]int{it.X, it.Y}]
+		if !ok {
+			continue
+		}
+		stage, elapsed := sig.Stage()
+		st := SignalState{
+			X: it.X, Y: it.Y,
+			Phase:      sig.Phase().Name,
+			Stage:      stage,
+			Elapsed:    elapsed,
+			Preempted:  sig.Preempted(),
+			Approaches: make(map[grid.Direction]string, 4),
+		}
+		for _, d := range grid.Directions {
+			st.Approaches[d] = sig.StateFor(Movement{Approach: d, Turn: grid.Straight})
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+// SetSignalPlan replaces the controller at (x,y) with a fixed-time signal
+// running plan from its offset. Returns false if there is no signal there.
+func (e *Engine) SetSignalPlan(x, y int, plan SignalPlan) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	k := [2]int{x, y}
+	if _, ok := e.signals[k]; !ok {
+		return false
+	}
+	e.signals[k] = newPreemptible(NewSignal(plan))
+	return true
+}
+
+// PhaseFlows returns the observed critical flow (veh/h) of each phase of the
+// signal at (x,y): per phase, the busiest approach's flow over the movements
+// that phase serves. Returns nil if there is no signal there.
+func (e *Engine) PhaseFlows(x, y int) []float64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	c, ok := e.signals[[2]int{x, y}]
+	if !ok {
+		return nil
+	}
+	counts := e.flows[[2]int{x, y}]
+	seconds := float64(max(e.ticks-e.flowSince, 1))
+	phases := c.Plan().Phases
+	out := make([]float64, len(phases))
+	for i, ph := range phases {
+		perApproach := make(map[grid.Direction]int)
+		for _, m := range ph.Green {
+			perApproach[m.Approach] += counts[m]
+		}
+		for _, n := range perApproach {
+			out[i] = max(out[i], float64(n)*3600/seconds)
+		}
+	}
+	return out
+}
+
+// ResetFlows restarts flow counting, e.g. after signal timings change.
+func (e *Engine) ResetFlows() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.flows = make(map[[2]int]map[Movement]int)
+	e.flowSince = e.ticks
+}
+
+// ApplyCoordination installs the fixed-time plans of cp on their
+// intersections, all restarting now so the offsets line up, and remembers cp
+// for reporting. Returns an error, changing nothing, if an intersection of cp
+// has no signal.
+func (e *Engine) ApplyCoordination(cp CoordinationPlan) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range cp.Signals {
+		if _, ok := e.signals[[2]int{s.X, s.Y}]; !ok {
+			return fmt.Errorf("no signal at (%d,%d)", s.X, s.Y)
+		}
+	}
+	for _, s := range cp.Signals {
+		e.signals[[2]int{s.X, s.Y}] = newPreemptible(NewSignal(s.Plan))
+	}
+	e.coordination = &cp
+	return nil
+}
+
+// Coordination returns the last applied coordination plan, if any.
+func (e *Engine) Coordination() (CoordinationPlan, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.coordination == nil {
+		return CoordinationPlan{}, false
+	}
+	return *e.coordination, true
+}
+
+// SetController plugs a controller into the intersection at (x,y).
+// Returns false if there is no signalised intersection there.
+func (e *Engine) SetController(x, y int, c Controller) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	k := [2]int{x, y}
+	if _, ok := e.signals[k]; !ok {
+		return false
+	}
+	e.signals[k] = newPreemptible(c)
+	return true
+}
+
+// VehicleCount returns the number of active vehicles.
+func (e *Engine) VehicleCount() int { return e.vehicles.Count() }
+
+// Vehicles returns copies of all vehicles so callers can read them while the
+// engine keeps ticking.
+func (e *Engine) Vehicles() []Vehicle {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	list := e.vehicles.List()
+	out := make([]Vehicle, 0, len(list))
+	for _, v := range list {
+		out = append(out, *v)
+	}
+	return out
+}
+
+// AddVehicle inserts a copy of v and returns its ID, assigning one if v has
+// none. Returns false if the ID is already taken, and an error if v's
+// kinematics are invalid.
+func (e *Engine) AddVehicle(v Vehicle) (string, bool, error) {
+	if err := v.Dynamics.Validate(); err != nil {
+		return "", false, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	nv := v
+	nv.route, nv.planned = nil, false
+	nv.DepartTick = e.ticks
+	e.equipLocked(&nv)
+	ok := e.vehicles.Add(&nv)
+	return nv.ID, ok, nil
+}
+
+// UpsertVehicle updates the position, speed, destination and type of the
+// vehicle with v's ID, or adds v if there is none. Returns true if it was added.
+// A vehicle whose position or destination changed replans on the next tick.
+func (e *Engine) UpsertVehicle(v Vehicle) (bool, error) {
+	if !e.grid.IsValid(v.X, v.Y) || !e.grid.IsValid(v.DestX, v.DestY) {
+		return false, fmt.Errorf("position (%d,%d) or destination (%d,%d) outside the %dx%d grid",
+			v.X, v.Y, v.DestX, v.DestY, e.grid.Width, e.grid.Height)
+	}
+	if err := v.Dynamics.Validate(); err != nil {
+		return false, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if cur, ok := e.vehicles.Get(v.ID); v.ID != "" && ok {
+		cur.X, cur.Y, cur.Speed, cur.Type = v.X, v.Y, v.Speed, v.Type
+		if cur.DestX != v.DestX || cur.DestY != v.DestY {
+			cur.SetDestination(v.DestX, v.DestY)
+		}
+		return false, nil
+	}
+	nv := v
+	nv.route, nv.planned = nil, false
+	nv.DepartTick = e.ticks
+	e.equipLocked(&nv)
+	e.vehicles.Add(&nv)
+	return true, nil
+}
+
+// equipLocked gives a vehicle without kinematics of its own the configured
+// ones, and starts a kinematic vehicle from rest.
+func (e *Engine) equipLocked(v *Vehicle) {
+	if !v.Dynamics.Enabled() {
+		v.Dynamics = e.kinematics
+	}
+	if v.Dynamics.Enabled() {
+		v.Speed, v.progress = 0, 0
+	}
+}
+
+// Spawn adds n vehicles at random open positions and returns their IDs.
+func (e *Engine) Spawn(n int) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ids := e.vehicles.SpawnWhere(n, e.grid.Width, e.grid.Height, func(x, y int) bool { return !e.baseBlocked[[2]int{x, y}] })
+	for _, id := range ids {
+		if v, ok := e.vehicles.Get(id); ok {
+			v.DepartTick = e.ticks
+			e.equipLocked(v)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,58 @@
+package sim
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published by the engine.
+const (
+	EventIncidentUpdate = "incident_update"
+)
+
+// Event is a change in the simulation, published to subscribers as it happens.
+type Event struct {
+	Type      string
+	Tick      int64     // simulation tick at which it happened
+	Timestamp time.Time // wall-clock time it was published
+	Data      any
+}
+
+// eventBus fans events out to subscribers. Publishing never blocks: a
+// subscriber whose buffer is full misses the event.
+type eventBus struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]chan Event
+}
+
+func newEventBus() *eventBus { return &eventBus{subs: make(map[int]chan Event)} }
+
+func (b *eventBus) subscribe(buffer int) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	ch := make(chan Event, max(buffer, 1))
+	b.subs[id] = ch
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subs, id)
+			close(ch)
+		})
+	}
+}
+
+func (b *eventBus) publish(ev Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	grid "routeiq/internal/grid"
+)
+
+// SaturationFlow is the most vehicles per hour one approach can discharge
+// on green: one vehicle per cell per one-second tick.
+const SaturationFlow = 3600.0
+
+// Cycle length bounds, in seconds, for optimised plans.
+const (
+	MinCycle = 30
+	MaxCycle = 180
+)
+
+// minSplitGreen is the shortest green an optimised phase is given.
+const minSplitGreen = 4
+
+// LostTime returns the time per cycle in which no phase has usable green:
+// the yellow and all-red of every phase.
+func LostTime(plan SignalPlan) int {
+	lost := 0
+	for _, ph := range plan.Phases {
+		lost += ph.YellowSec + ph.AllRedSec
+	}
+	return lost
+}
+
+// WebsterCycle returns Webster's optimal cycle length (1.5L+5)/(1-Y) for lost
+// time L and critical flow ratios y (flow/saturation flow per phase),
+// clamped to [MinCycle, MaxCycle]. Oversaturated demand (Y >= 1) gets MaxCycle.
+func WebsterCycle(lostTime int, ratios []float64) int {
+	y := 0.0
+	for _, r := range ratios {
+		y += max(r, 0)
+	}
+	if y >= 1 {
+		return MaxCycle
+	}
+	c := (1.5*float64(lostTime) + 5) / (1 - y)
+	return min(max(int(math.Round(c)), MinCycle), MaxCycle)
+}
+
+// WebsterSplits returns a copy of plan with green times sharing cycle -
+// LostTime(plan) in proportion to each phase's critical flow ratio. Every
+// phase gets at least minSplitGreen; with no flow at all greens are equal.
+func WebsterSplits(plan SignalPlan, cycle int, ratios []float64) SignalPlan {
+	out := SignalPlan{Phases: make([]Phase, len(plan.Phases)), Offset: plan.Offset}
+	copy(out.Phases, plan.Phases)
+	n := len(out.Phases)
+	if n == 0 {
+		return out
+	}
+	effective := max(cycle-LostTime(plan), n*minSplitGreen)
+	spare := effective - n*minSplitGreen
+	y := 0.0
+	for i := 0; i < n && i < len(ratios); i++ {
+		y += max(ratios[i], 0)
+	}
+	assigned := 0
+	for i := range out.Phases {
+		share := 1 / float64(n)
+		if y > 0 {
+			share = 0
+			if i < len(ratios) {
+				share = max(ratios[i], 0) / y
+			}
+		}
+		g := minSplitGreen + int(math.Floor(share*float64(spare)))
+		out.Phases[i].GreenSec = g
+		assigned += g
+	}
+	// rounding leftovers go to the busiest phase so the cycle length is exact
+	busiest := 0
+	for i := range ratios {
+		if i < n && ratios[i] > ratios[busiest] {
+			busiest = i
+		}
+	}
+	out.Phases[busiest].GreenSec += effective - assigned
+	return out
+}
+
+// Corridor describes a street of signalised intersections to coordinate.
+type Corridor struct {
+	Intersections [][2]int             // in travel order, along one row or column
+	Speed         float64              // progression speed in cells per second
+	Flows         map[[2]int][]float64 // observed critical flow per phase (veh/h); missing means no data
+	Plan          SignalPlan           // phase template; DefaultSignalPlan if it has no phases
+}
+
+// CoordinatedSignal is the optimised timing of one corridor intersection.
+type CoordinatedSignal struct {
+	X, Y       int
+	TravelTime float64 // seconds from the first intersection at progression speed
+	Offset     int     // plan offset so the coordinated green starts on the platoon's arrival
+	Plan       SignalPlan
+}
+
+// CoordinationPlan is the result of a green-wave optimisation.
+type CoordinationPlan struct {
+	Direction grid.Direction // travel direction given the green wave
+	Phase     string         // coordinated phase serving that direction
+	Cycle     int            // common cycle length
+	Signals   []CoordinatedSignal
+}
+
+// OptimizeCorridor computes a common Webster cycle (from the most critical
+// intersection), per-intersection splits from observed flows, and offsets
+// that start the coordinated phase's green as a platoon leaving the first
+// intersection at c.Speed arrives at each of the others.
+func OptimizeCorridor(c Corridor) (CoordinationPlan, error) {
+	if len(c.Intersections) == 0 {
+		return CoordinationPlan{}, errors.New("corridor has no intersections")
+	}
+	if c.Speed <= 0 {
+		return CoordinationPlan{}, errors.New("corridor speed must be positive")
+	}
+	template := c.Plan
+	if len(template.Phases) == 0 {
+		template = DefaultSignalPlan()
+	}
+	template.Offset = 0
+
+	dir := grid.NoDirection
+	for i := 1; i < len(c.Intersections); i++ {
+		a, b := c.Intersections[i-1], c.Intersections[i]
+		dx, dy := sign(b[0]-a[0]), sign(b[1]-a[1])
+		d := grid.DirectionOf(dx, dy)
+		if d == grid.NoDirection || (dir != grid.NoDirection && d != dir) {
+			return CoordinationPlan{}, fmt.Errorf("intersections %v and %v are not in one straight line of travel", a, b)
+		}
+		dir = d
+	}
+	if dir == grid.NoDirection {
+		dir = grid.North // single intersection: coordinate the first phase's approach
+	}
+
+	coordinated := -1
+	for i, ph := range template.Phases {
+		for _, m := range ph.Green {
+			if m.Approach == dir && m.Turn == grid.Straight {
+				coordinated = i
+			}
+		}
+		if coordinated >= 0 {
+			break
+		}
+	}
+	if coordinated < 0 {
+		return CoordinationPlan{}, fmt.Errorf("no phase serves %s through traffic", dir)
+	}
+
+	ratios := make([][]float64, len(c.Intersections))
+	cycle := max(MinCycle, LostTime(template)+len(template.Phases)*minSplitGreen)
+	for i, p := range c.Intersections {
+		ratios[i] = make([]float64, len(template.Phases))
+		for j, f := range c.Flows[p] {
+			if j < len(ratios[i]) {
+				ratios[i][j] = f / SaturationFlow
+			}
+		}
+		cycle = max(cycle, WebsterCycle(LostTime(template), ratios[i]))
+	}
+
+	out := CoordinationPlan{Direction: dir, Phase: template.Phases[coordinated].Name, Cycle: cycle}
+	travel := 0.0
+	for i, p := range c.Intersections {
+		if i > 0 {
+			prev := c.Intersections[i-1]
+			travel += float64(abs(p[0]-prev[0])+abs(p[1]-prev[1])) / c.Speed
+		}
+		plan := WebsterSplits(template, cycle, ratios[i])
+		start := 0 // cycle time at which the coordinated green begins
+		for _, ph := range plan.Phases[:coordinated] {
+			start += ph.Duration()
+		}
+		plan.Offset = mod(start-int(math.Round(travel)), cycle)
+		out.Signals = append(out.Signals, CoordinatedSignal{
+			X: p[0], Y: p[1],
+			TravelTime: travel,
+			Offset:     plan.Offset,
+			Plan:       plan,
+		})
+	}
+	return out, nil
+}
+
+func sign(a int) int {
+	switch {
+	case a > 0:
+		return 1
+	case a < 0:
+		return -1
+	}
+	return 0
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
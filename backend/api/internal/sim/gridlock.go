@@ -0,0 +1,82 @@
+package sim
+
+// rotateCycles finds ready vehicles that could not move because each waits
+// for the next to leave its cell, and moves every vehicle in such a cycle at
+// once, ignoring lane limits. A two-vehicle cycle is a swap: two vehicles
+// passing head-on. Cell counts are unchanged, so no other vehicle gains room.
+// It returns the number of cycles and vehicles moved.
+func rotateCycles(ready []*Vehicle, occ *Occupancy) (cycles, rotated int) {
+	// stuck vehicles by cell, in priority order
+	at := make(map[point][]*Vehicle)
+	for _, v := range ready {
+		if !v.moved {
+			at[point{v.X, v.Y}] = append(at[point{v.X, v.Y}], v)
+		}
+	}
+	if len(at) == 0 {
+		return 0, 0
+	}
+	// each stuck vehicle waits for the first stuck vehicle in its next cell
+	waitsFor := func(v *Vehicle) *Vehicle {
+		next, _ := v.nextStep()
+		if q := at[next]; len(q) > 0 {
+			return q[0]
+		}
+		return nil
+	}
+	const (
+		unseen = iota
+		onPath
+		done
+	)
+	mark := make(map[*Vehicle]int)
+	for _, v := range ready {
+		if v.moved || mark[v] != unseen {
+			continue
+		}
+		var path []*Vehicle
+		u := v
+		for u != nil && mark[u] == unseen {
+			mark[u] = onPath
+			path = append(path, u)
+			u = waitsFor(u)
+		}
+		if u != nil && mark[u] == onPath { // the path closed on itself
+			i := 0
+			for path[i] != u {
+				i++
+			}
+			cycle := path[i:]
+			for _, c := range cycle {
+				occ.enter(c)
+			}
+			cycles++
+			rotated += len(cycle)
+		}
+		for _, p := range path {
+			mark[p] = done
+		}
+	}
+	return cycles, rotated
+}
+
+// detour replans the route around the cell the vehicle is stuck behind,
+// treating it as blocked. It reports false, keeping the route, if there is no
+// other way to the destination.
+func (v *Vehicle) detour(pf *PathFinder) bool {
+	next, ok := v.nextStep()
+	if !ok {
+		return false
+	}
+	path, _ := pf.search(v.X, v.Y, v.routeDest.X, v.routeDest.Y, query{
+		heading: v.Heading,
+		avoid:   func(pt point) bool { return pt == next },
+	})
+	if len(path) < 2 {
+		return false
+	}
+	v.route = path
+	v.routeIdx = 0
+	v.routeVersion = pf.Version()
+	return true
+}
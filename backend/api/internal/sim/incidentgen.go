@@ -0,0 +1,235 @@
+package sim
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"time"
+)
+
+// IncidentProfile describes how often and how badly one type of incident occurs.
+type IncidentProfile struct {
+	Rate     float64     // incidents per hour per 100 open cells at hourly factor 1 and no congestion
+	Hourly   [24]float64 // rate factor for each hour of the simulated day
+	Severity [5]float64  // relative weights of severities 1..5
+	Duration time.Duration
+	Spread   float64 // log-normal sigma of the duration; accident durations also grow with severity
+}
+
+// IncidentGeneratorConfig configures random incidents.
+type IncidentGeneratorConfig struct {
+	Accident     IncidentProfile
+	Closure      IncidentProfile
+	Construction IncidentProfile
+
+	CongestionFactor   float64            // rate multiplier is 1+CongestionFactor*density, density 0..1 around the cell
+	IntersectionWeight float64            // location weight of intersection cells; other open cells weigh 1
+	Hotspots           map[[2]int]float64 // extra location weight multipliers
+}
+
+// DefaultIncidentGeneratorConfig returns rates that give a 20x20 grid about
+// two accidents an hour at midday, more in the rush hours and in congestion,
+// with construction mostly at night.
+func DefaultIncidentGeneratorConfig() IncidentGeneratorConfig {
+	var flat, rush, night [24]float64
+	for h := range 24 {
+		flat[h] = 1
+		switch {
+		case h >= 7 && h <= 9, h >= 16 && h <= 18:
+			rush[h] = 2
+		case h < 5:
+			rush[h] = 0.4
+		default:
+			rush[h] = 1
+		}
+		night[h] = 0.2
+		if h >= 20 || h < 6 {
+			night[h] = 2
+		}
+	}
+	return IncidentGeneratorConfig{
+		Accident: IncidentProfile{
+			Rate: 0.5, Hourly: rush, Severity: [5]float64{35, 30, 20, 10, 5},
+			Duration: 20 * time.Minute, Spread: 0.5,
+		},
+		Closure: IncidentProfile{
+			Rate: 0.05, Hourly: flat, Severity: [5]float64{0, 0, 30, 40, 30},
+			Duration: time.Hour, Spread: 0.6,
+		},
+		Construction: IncidentProfile{
+			Rate: 0.1, Hourly: night, Severity: [5]float64{20, 40, 30, 10, 0},
+			Duration: 4 * time.Hour, Spread: 0.4,
+		},
+		CongestionFactor:   3,
+		IntersectionWeight: 3,
+	}
+}
+
+// incidentGenerator raises random incidents through the engine's incident
+// path and resolves them when their sampled duration is up.
+type incidentGenerator struct {
+	cfg     IncidentGeneratorConfig
+	src     *rand.ChaCha8
+	rng     *rand.Rand
+	expires map[string]int64 // generated incident ID -> tick it resolves
+}
+
+// incidentStream is the seededSource stream used for incident generation.
+const incidentStream = 1
+
+func newIncidentGenerator(cfg IncidentGeneratorConfig, seed uint64) *incidentGenerator {
+	src := seededSource(seed, incidentStream)
+	return &incidentGenerator{cfg: cfg, src: src, rng: rand.New(src), expires: make(map[string]int64)}
+}
+
+// poisson draws from a Poisson distribution with mean lambda. Large means
+// are split into chunks, as sums of Poisson draws are Poisson, so the
+// product of uniforms never underflows.
+func poisson(rng *rand.Rand, lambda float64) int {
+	n := 0
+	for lambda > 0 {
+		chunk := min(lambda, 30)
+		lambda -= chunk
+		limit, p := math.Exp(-chunk), rng.Float64()
+		for p > limit {
+			n++
+			p *= rng.Float64()
+		}
+	}
+	return n
+}
+
+// weightedIndex picks an index with probability proportional to its weight.
+func weightedIndex(rng *rand.Rand, weights []float64) int {
+	total := 0.0
+	for _, w := range weights {
+		total += max(w, 0)
+	}
+	if total <= 0 {
+		return -1
+	}
+	u := rng.Float64() * total
+	for i, w := range weights {
+		u -= max(w, 0)
+		if u < 0 {
+			return i
+		}
+	}
+	return len(weights) - 1
+}
+
+// EnableIncidents starts generating random incidents with cfg. Generation is
+// driven by its own stream of the engine seed, so runs stay reproducible.
+func (e *Engine) EnableIncidents(cfg IncidentGeneratorConfig) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.generator = newIncidentGenerator(cfg, e.Seed())
+}
+
+// DisableIncidents stops generating incidents. Active ones resolve as
+// ingested incidents do, only when reported resolved.
+func (e *Engine) DisableIncidents() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.generator = nil
+}
+
+// locationWeightLocked returns how likely (x,y) is to host an incident
+// relative to an ordinary open cell, including congestion around it. Cells
+// with an active incident are excluded by the caller.
+func (e *Engine) locationWeightLocked(x, y int) float64 {
+	k := [2]int{x, y}
+	if e.baseBlocked[k] {
+		return 0
+	}
+	cfg := e.generator.cfg
+	w := 1.0
+	if _, ok := e.signals[k]; ok && cfg.IntersectionWeight > 0 {
+		w = cfg.IntersectionWeight
+	}
+	if h, ok := cfg.Hotspots[k]; ok {
+		w *= max(h, 0)
+	}
+	if c, ok := e.pf.congestion[k]; ok {
+		w *= 1 + cfg.CongestionFactor*(c-1)/congestionWeight
+	}
+	return w
+}
+
+// generateIncidentsLocked resolves expired generated incidents and raises new
+// ones for this tick.
+func (e *Engine) generateIncidentsLocked() {
+	g := e.generator
+	if g == nil {
+		return
+	}
+	var expired []string
+	for id, until := range g.expires {
+		if e.ticks >= until {
+			expired = append(expired, id)
+		}
+	}
+	sort.Strings(expired) // publish resolutions in a reproducible order
+	for _, id := range expired {
+		delete(g.expires, id)
+		if inc, ok := e.incidents.Get(id); ok && !inc.Resolved {
+			inc.Resolved = true
+			e.reportIncidentLocked(inc)
+		}
+	}
+	hour := int(e.timeOfDayLocked() / time.Hour)
+	var weights []float64 // location weights, computed on the first incident of the tick
+	for _, kind := range []struct {
+		typ IncidentType
+		p   IncidentProfile
+	}{
+		{IncidentAccident, g.cfg.Accident},
+		{IncidentClosure, g.cfg.Closure},
+		{IncidentConstruction, g.cfg.Construction},
+	} {
+		// expected incidents this second over an average cell, scaled by the grid's total weight below
+		lambda := kind.p.Rate * kind.p.Hourly[hour] / 3600 / 100
+		if lambda <= 0 {
+			continue
+		}
+		if weights == nil {
+			weights = make([]float64, e.grid.Width*e.grid.Height)
+			for y := 0; y < e.grid.Height; y++ {
+				for x := 0; x < e.grid.Width; x++ {
+					weights[y*e.grid.Width+x] = e.locationWeightLocked(x, y)
+				}
+			}
+			for _, inc := range e.incidents.List(false) {
+				weights[inc.Y*e.grid.Width+inc.X] = 0 // one incident per cell
+			}
+		}
+		total := 0.0
+		for _, w := range weights {
+			total += w
+		}
+		for n := poisson(g.rng, lambda*total); n > 0; n-- {
+			i := weightedIndex(g.rng, weights)
+			if i < 0 {
+				break // no cell left for this kind
+			}
+			weights[i] = 0 // one incident per cell
+			sev := weightedIndex(g.rng, kind.p.Severity[:]) + 1
+			if sev == 0 {
+				sev = MinSeverity
+			}
+			dur := kind.p.Duration.Seconds() * math.Exp(kind.p.Spread*g.rng.NormFloat64())
+			if kind.typ == IncidentAccident {
+				dur *= 0.5 + 0.25*float64(sev)
+			}
+			inc, _, _ := e.reportIncidentLocked(Incident{
+				ID:        uuidFrom(g.src),
+				Type:      kind.typ,
+				X:         i % e.grid.Width,
+				Y:         i / e.grid.Width,
+				Severity:  sev,
+				Generated: true,
+			})
+			g.expires[inc.ID] = e.ticks + max(int64(math.Round(dur)), 1)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// IncidentType classifies an incident.
+type IncidentType string
+
+const (
+	IncidentAccident     IncidentType = "accident"
+	IncidentClosure      IncidentType = "closure"
+	IncidentConstruction IncidentType = "construction"
+)
+
+// Severity bounds; severity 5 blocks the cell whatever the incident type.
+const (
+	MinSeverity = 1
+	MaxSeverity = 5
+)
+
+// Incident is a disruption at one cell. While active it slows or blocks the cell.
+type Incident struct {
+	ID           string
+	Type         IncidentType
+	X, Y         int
+	Severity     int       // 1 (minor) to 5 (impassable)
+	Timestamp    time.Time // when it was reported
+	StartTick    int64     // tick it was first recorded
+	Resolved     bool
+	ResolvedTick int64 // tick it was resolved; zero while active
+	Generated    bool  // raised by the simulation's incident generator rather than reported
+}
+
+// Validate checks the type and severity.
+func (i Incident) Validate() error {
+	switch i.Type {
+	case IncidentAccident, IncidentClosure, IncidentConstruction:
+	default:
+		return fmt.Errorf("unknown incident type %q", i.Type)
+	}
+	if i.Severity < MinSeverity || i.Severity > MaxSeverity {
+		return fmt.Errorf("severity %d outside %d..%d", i.Severity, MinSeverity, MaxSeverity)
+	}
+	return nil
+}
+
+// Impact returns the penalty an active incident puts on its cell, and
+// whether it blocks the cell outright. Closures and severity 5 block; an
+// accident multiplies the cell cost by 1+severity and construction by
+// 1+severity/2. Vehicles crossing a penalised cell dwell there for
+// ceil(penalty)-1 extra ticks, so the penalty is also the capacity lost.
+func (i Incident) Impact() (penalty float64, blocked bool) {
+	if i.Resolved {
+		return 1, false
+	}
+	if i.Type == IncidentClosure || i.Severity >= MaxSeverity {
+		return 1, true
+	}
+	if i.Type == IncidentConstruction {
+		return 1 + float64(i.Severity)/2, false
+	}
+	return 1 + float64(i.Severity), false
+}
+
+// dwellTicks is how long a vehicle is held in a cell with the given incident penalty.
+func dwellTicks(penalty float64) int {
+	return max(int(math.Ceil(penalty))-1, 0)
+}
+
+// IncidentStore holds incidents by ID in the order they were first reported.
+type IncidentStore struct {
+	byID  map[string]*Incident
+	order []string
+}
+
+func NewIncidentStore() *IncidentStore {
+	return &IncidentStore{byID: make(map[string]*Incident)}
+}
+
+// Upsert records inc, replacing any incident with the same ID. It returns the
+// previous version and whether there was one.
+func (s *IncidentStore) Upsert(inc Incident) (Incident, bool) {
+	prev, ok := s.byID[inc.ID]
+	if !ok {
+		s.order = append(s.order, inc.ID)
+		s.byID[inc.ID] = &inc
+		return Incident{}, false
+	}
+	old := *prev
+	*prev = inc
+	return old, true
+}
+
+// Get returns the incident with the given ID.
+func (s *IncidentStore) Get(id string) (Incident, bool) {
+	inc, ok := s.byID[id]
+	if !ok {
+		return Incident{}, false
+	}
+	return *inc, true
+}
+
+// List returns incidents in report order; resolved ones only if all is set.
+func (s *IncidentStore) List(all bool) []Incident {
+	out := make([]Incident, 0, len(s.order))
+	for _, id := range s.order {
+		if inc := s.byID[id]; all || !inc.Resolved {
+			out = append(out, *inc)
+		}
+	}
+	return out
+}
+
+// At returns the active incidents at (x,y).
+func (s *IncidentStore) At(x, y int) []Incident {
+	var out []Incident
+	for _, id := range s.order {
+		if inc := s.byID[id]; !inc.Resolved && inc.X == x && inc.Y == y {
+			out = append(out, *inc)
+		}
+	}
+	return out
+}
+
+// ReportIncident records or updates an incident and applies its effect on
+// routing: the cell's incident penalty is the largest of its active
+// incidents, and it is blocked if any of them blocks it. Reporting an
+// incident as resolved restores the cell. Planned routes through changed cells
+// are replanned on the next tick, and subscribers get an EventIncidentUpdate.
+// A missing ID is assigned. Resolving a known incident only needs its ID: it
+// stays where it was, and a missing type or severity keeps the stored one. It
+// returns the stored incident and whether it was new.
+func (e *Engine) ReportIncident(inc Incident) (Incident, bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if prev, ok := e.incidents.Get(inc.ID); ok && inc.Resolved && inc.ID != "" {
+		inc.X, inc.Y = prev.X, prev.Y
+		if inc.Type == "" {
+			inc.Type = prev.Type
+		}
+		if inc.Severity == 0 {
+			inc.Severity = prev.Severity
+		}
+	}
+	if err := inc.Validate(); err != nil {
+		return Incident{}, false, err
+	}
+	if !e.grid.IsValid(inc.X, inc.Y) {
+		return Incident{}, false, fmt.Errorf("position (%d,%d) outside the %dx%d grid",
+			inc.X, inc.Y, e.grid.Width, e.grid.Height)
+	}
+	return e.reportIncidentLocked(inc)
+}
+
+func (e *Engine) reportIncidentLocked(inc Incident) (Incident, bool, error) {
+	if inc.ID == "" {
+		inc.ID = e.vehicles.NewID()
+	}
+	if inc.Timestamp.IsZero() {
+		inc.Timestamp = time.Now()
+	}
+	prev, existed := e.incidents.Get(inc.ID)
+	inc.StartTick = e.ticks
+	if existed {
+		inc.StartTick = prev.StartTick
+		if prev.Resolved && !inc.Resolved {
+			inc.StartTick = e.ticks // reopened
+		}
+	}
+	inc.ResolvedTick = 0
+	if inc.Resolved {
+		inc.ResolvedTick = e.ticks
+		if existed && prev.Resolved {
+			inc.ResolvedTick = prev.ResolvedTick
+		}
+	}
+	e.incidents.Upsert(inc)
+	if existed {
+		e.applyIncidentsAtLocked(prev.X, prev.Y)
+	}
+	e.applyIncidentsAtLocked(inc.X, inc.Y)
+	e.publishLocked(EventIncidentUpdate, inc)
+	return inc, !existed, nil
+}
+
+// ResolveIncident marks an incident resolved. It returns false if there is no such incident.
+func (e *Engine) ResolveIncident(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	inc, ok := e.incidents.Get(id)
+	if !ok {
+		return false
+	}
+	inc.Resolved = true
+	e.reportIncidentLocked(inc)
+	return true
+}
+
+// Incidents returns incidents in report order, including resolved ones if all is set.
+func (e *Engine) Incidents(all bool) []Incident {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.incidents.List(all)
+}
+
+// applyIncidentsAtLocked recomputes the penalty and blocking of (x,y) from its
+// active incidents. Cells blocked in the engine config stay blocked.
+func (e *Engine) applyIncidentsAtLocked(x, y int) {
+	penalty, blocked := 1.0, e.baseBlocked[[2]int{x, y}]
+	for _, inc := range e.incidents.At(x, y) {
+		p, b := inc.Impact()
+		penalty = max(penalty, p)
+		blocked = blocked || b
+	}
+	e.pf.SetIncidentPenalty(x, y, penalty)
+	e.pf.SetBlocked(x, y, blocked)
+}
+
+// ScheduledIncident is an incident raised At into the run and, if Duration is
+// positive, resolved Duration later. A missing ID is assigned when it is raised.
+type ScheduledIncident struct {
+	Incident
+	At       time.Duration
+	Duration time.Duration
+}
+
+// runScheduleLocked raises the scheduled incidents that are due and resolves
+// the ones whose duration is up, in start order.
+func (e *Engine) runScheduleLocked() {
+	now := time.Duration(e.ticks) * time.Second
+	ids := make([]string, 0, len(e.scheduleEnds))
+	for id := range e.scheduleEnds {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if e.scheduleEnds[id] > e.ticks {
+			continue
+		}
+		delete(e.scheduleEnds, id)
+		if inc, ok := e.incidents.Get(id); ok && !inc.Resolved {
+			inc.Resolved = true
+			e.reportIncidentLocked(inc)
+		}
+	}
+	for ; e.scheduled < len(e.schedule) && e.schedule[e.scheduled].At <= now; e.scheduled++ {
+		s := e.schedule[e.scheduled]
+		if s.Validate() != nil || !e.grid.IsValid(s.X, s.Y) {
+			continue
+		}
+		inc, _, err := e.reportIncidentLocked(s.Incident)
+		if err == nil && s.Duration > 0 {
+			secs := int64((s.Duration + time.Second - 1) / time.Second)
+			e.scheduleEnds[inc.ID] = e.ticks + secs
+		}
+	}
+}
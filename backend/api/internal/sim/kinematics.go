@@ -0,0 +1,150 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+)
+
+// lookahead is how many cells beyond the next one a kinematic vehicle scans
+// for something to stop at: enough to brake from full speed at any sensible
+// deceleration.
+const lookahead = 3
+
+// minKinematicRate is the lowest acceleration or deceleration a kinematic
+// vehicle may have, in cells per second squared: slower still, it would take
+// minutes to reach speed or to stop.
+const minKinematicRate = 0.01
+
+// Kinematics are a vehicle's performance limits, in cells and seconds. A
+// vehicle with zero Accel has no kinematics: it moves one cell per tick
+// whenever it can and its Speed reads 1 or 0.
+type Kinematics struct {
+	MaxSpeed float64 // desired speed in cells per second, capped at 1; 1 if zero
+	Accel    float64 // cells per second squared
+	Decel    float64 // comfortable braking in cells per second squared; Accel if zero
+	MinGap   float64 // distance in cells kept to the vehicle or stop line ahead
+}
+
+// DefaultKinematics returns a car that reaches full speed in four seconds and
+// stops from it in two.
+func DefaultKinematics() Kinematics {
+	return Kinematics{MaxSpeed: 1, Accel: 0.25, Decel: 0.5, MinGap: 0.1}
+}
+
+// Validate checks the limits are finite and in range. The zero value, no
+// kinematics, is valid.
+func (k Kinematics) Validate() error {
+	for _, f := range []struct {
+		name string
+		v    float64
+	}{{"max speed", k.MaxSpeed}, {"acceleration", k.Accel}, {"deceleration", k.Decel}, {"minimum gap", k.MinGap}} {
+		if math.IsNaN(f.v) || math.IsInf(f.v, 0) || f.v < 0 {
+			return fmt.Errorf("kinematics: %s must be a finite non-negative number, got %g", f.name, f.v)
+		}
+	}
+	if k.Accel != 0 && k.Accel < minKinematicRate || k.Decel != 0 && k.Decel < minKinematicRate {
+		return fmt.Errorf("kinematics: acceleration and deceleration must be zero or at least %g, got %g and %g",
+			minKinematicRate, k.Accel, k.Decel)
+	}
+	return nil
+}
+
+// Enabled reports whether the vehicle moves kinematically.
+func (k Kinematics) Enabled() bool { return k.Accel > 0 }
+
+func (k Kinematics) maxSpeed() float64 {
+	if k.MaxSpeed > 0 {
+		return min(k.MaxSpeed, 1)
+	}
+	return 1
+}
+
+func (k Kinematics) decel() float64 {
+	if k.Decel > 0 {
+		return k.Decel
+	}
+	return k.Accel
+}
+
+// position is how far the vehicle is through its cell. A vehicle without
+// kinematics may leave at any time, so it counts as at the far end.
+func (v *Vehicle) position() float64 {
+	if v.Dynamics.Enabled() {
+		return v.progress
+	}
+	return 1
+}
+
+// clearAhead returns the distance from v to the first thing on its route it
+// must stop behind, as things stand at the start of the tick: the entry of a
+// cell whose light is red or yellow to it, or the rearmost vehicle of a full
+// cell. It returns +Inf if the next lookahead+1 cells are clear.
+func (v *Vehicle) clearAhead(lights Signals, occ *Occupancy, green bool) float64 {
+	free := 1 - v.progress
+	for i := v.routeIdx + 1; i < len(v.route) && i <= v.routeIdx+1+lookahead; i++ {
+		p := v.route[i]
+		if i > v.routeIdx+1 && lights != nil && !v.Type.Emergency() {
+			if state, ok := lights.SignalFor(p.X, p.Y, v.movementAt(i)); ok && state != "green" {
+				green = false
+			}
+		}
+		if !green {
+			return free
+		}
+		k := [2]int{p.X, p.Y}
+		if !v.Type.Emergency() && occ.count[k] >= occ.Capacity(p.X, p.Y).Vehicles() {
+			return free + occ.rear[k]
+		}
+		free++
+	}
+	return math.Inf(1)
+}
+
+// accelerate updates the speed of a kinematic vehicle and its progress
+// through the current cell, and reports whether it reaches the next cell this
+// tick. The speed follows the acceleration, the desired speed and the speed
+// limits of this cell and the next, and stays low enough to stop at the
+// comfortable deceleration MinGap short of whatever is ahead; if that is
+// closer than the vehicle can brake for, it brakes harder rather than hit it.
+func (v *Vehicle) accelerate(pf *PathFinder, lights Signals, occ *Occupancy, green bool) bool {
+	k := v.Dynamics
+	next := v.route[v.routeIdx+1]
+	limit := min(k.maxSpeed(), pf.SpeedLimit(v.X, v.Y), pf.SpeedLimit(next.X, next.Y))
+	room := max(v.clearAhead(lights, occ, green)-k.MinGap, 0)
+	v.Speed = min(v.Speed+k.Accel, limit, stoppingSpeed(room, k.decel()))
+	v.progress += v.Speed
+	return v.progress >= 1
+}
+
+// stoppingSpeed is the highest speed at which a vehicle can cover this tick's
+// distance and then stop within room, slowing by decel each tick after: the
+// largest v with v + (v-decel) + ... + (v-n*decel) <= room, n = floor(v/decel).
+// That is v = room/(n+1) + decel*n/2 for the smallest n with
+// (n+1)(n+2)/2 > room/decel, which the square root finds directly.
+func stoppingSpeed(room, decel float64) float64 {
+	if math.IsInf(room, 1) {
+		return room
+	}
+	r := room / decel
+	n := max(math.Floor((math.Sqrt(8*r+1)-3)/2), 0)
+	// rounding may leave the root one out either way
+	for n > 0 && n*(n+1)/2 > r {
+		n--
+	}
+	for (n+1)*(n+2)/2 <= r {
+		n++
+	}
+	return room/(n+1) + decel*n/2
+}
+
+// settle finishes a kinematic vehicle's tick: it carries its progress into
+// the cell it entered, or stops at the end of its cell if it could not leave.
+func (v *Vehicle) settle() {
+	if v.moved {
+		v.progress = max(v.progress-1, 0)
+		return
+	}
+	if v.progress >= 1 {
+		v.progress, v.Speed = 1, 0
+	}
+}
@@ -1,6 +1,8 @@
 package sim
 
 import (
+	"encoding/binary"
+	"io"
 	"math/rand/v2"
 	"sync"
 	"time"
@@ -9,29 +11,105 @@ import (
 )
 
 // VehicleManager manages the lifecycle of vehicles in-memory.
+// All randomness (positions, destinations and IDs) comes from one seeded
+// source, and vehicles are listed in insertion order, so a seed fully
+// determines the traffic it produces.
 type VehicleManager struct {
 	mu       sync.RWMutex
 	vehicles map[string]*Vehicle
+	order    []string // insertion order of vehicle IDs
+	seed     uint64
+	src      *rand.ChaCha8
+	rng      *rand.Rand
 }
 
+// NewVehicleManager returns a manager seeded from a random seed. Use Seed to
+// recover the seed and replay the run with NewSeededVehicleManager.
 func NewVehicleManager() *VehicleManager {
-	return &VehicleManager{vehicles: make(map[string]*Vehicle)}
+	return NewSeededVehicleManager(rand.Uint64())
+}
+
+// NewSeededVehicleManager returns a manager whose spawns are fully determined by seed.
+func NewSeededVehicleManager(seed uint64) *VehicleManager {
+	src := seededSource(seed, 0)
+	return &VehicleManager{
+		vehicles: make(map[string]*Vehicle),
+		seed:     seed,
+		src:      src,
+		rng:      rand.New(src),
+	}
+}
+
+// seededSource returns an independent random stream derived from seed, so
+// each part of the simulation draws reproducibly without disturbing the others.
+func seededSource(seed uint64, stream byte) *rand.ChaCha8 {
+	var key [32]byte
+	binary.LittleEndian.PutUint64(key[:8], seed)
+	key[8] = stream
+	return rand.NewChaCha8(key)
+}
+
+// Seed returns the seed that drives this manager's randomness.
+func (m *VehicleManager) Seed() uint64 { return m.seed }
+
+// newID draws a UUID from the seeded source rather than the global one.
+func (m *VehicleManager) newID() string { return uuidFrom(m.src) }
+
+// uuidFrom draws a random UUID from src.
+func uuidFrom(src io.Reader) string {
+	id, err := uuid.NewRandomFromReader(src)
+	if err != nil { // ChaCha8 reads never fail
+		panic(err)
+	}
+	return id.String()
+}
+
+// NewID returns a fresh UUID from the seeded source, for other simulation
+// objects that need reproducible IDs.
+func (m *VehicleManager) NewID() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.newID()
 }
 
 // Spawn creates n vehicles at random positions within bounds [0,width) x [0,height).
 func (m *VehicleManager) Spawn(n, width, height int) []string {
+	return m.SpawnWhere(n, width, height, nil)
+}
+
+// SpawnWhere is Spawn with positions and destinations drawn uniformly from the
+// cells allowed accepts. A nil allowed accepts every cell; if no cell is
+// allowed, no vehicles are spawned.
+func (m *VehicleManager) SpawnWhere(n, width, height int, allowed func(x, y int) bool) []string {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	draw := func() (int, int) { return m.rng.IntN(width), m.rng.IntN(height) }
+	if allowed != nil {
+		var cells [][2]int
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				if allowed(x, y) {
+					cells = append(cells, [2]int{x, y})
+				}
+			}
+		}
+		if len(cells) == 0 {
+			return nil
+		}
+		draw = func() (int, int) {
+			c := cells[m.rng.IntN(len(cells))]
+			return c[0], c[1]
+		}
+	}
 	ids := make([]string, 0, n)
 	for i := 0; i < n; i++ {
-		id := uuid.New().String()
-		x := rand.IntN(width)
-		y := rand.IntN(height)
-		dx := rand.IntN(width)
-		dy := rand.IntN(height)
+		id := m.newID()
+		x, y := draw()
+		dx, dy := draw()
 		v := &Vehicle{
 			ID:        id,
+			Type:      VehicleCar,
 			X:         x,
 			Y:         y,
 			Speed:     1.0,
@@ -40,11 +118,34 @@ func (m *VehicleManager) Spawn(n, width, height int) []string {
 			CreatedAt: time.Now(),
 		}
 		m.vehicles[id] = v
+		m.order = append(m.order, id)
 		ids = append(ids, id)
 	}
 	return ids
 }
 
+// Add inserts v, assigning a fresh ID if it has none. Returns false if a
+// vehicle with the same ID already exists.
+func (m *VehicleManager) Add(v *Vehicle) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v.ID == "" {
+		v.ID = m.newID()
+	}
+	if v.Type == "" {
+		v.Type = VehicleCar
+	}
+	if _, ok := m.vehicles[v.ID]; ok {
+		return false
+	}
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	m.vehicles[v.ID] = v
+	m.order = append(m.order, v.ID)
+	return true
+}
+
 // Despawn removes the vehicles with the provided IDs.
 func (m *VehicleManager) Despawn(ids ...string) int {
 	m.mu.Lock()
@@ -56,6 +157,15 @@ func (m *VehicleManager) Despawn(ids ...string) int {
 			removed++
 		}
 	}
+	if removed > 0 {
+		kept := m.order[:0]
+		for _, id := range m.order {
+			if _, ok := m.vehicles[id]; ok {
+				kept = append(kept, id)
+			}
+		}
+		m.order = kept
+	}
 	return removed
 }
 
@@ -74,13 +184,13 @@ func (m *VehicleManager) Count() int {
 	return len(m.vehicles)
 }
 
-// List returns a snapshot of vehicles.
+// List returns a snapshot of vehicles in insertion order.
 func (m *VehicleManager) List() []*Vehicle {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	out := make([]*Vehicle, 0, len(m.vehicles))
-	for _, v := range m.vehicles {
-		out = append(out, v)
+	out := make([]*Vehicle, 0, len(m.order))
+	for _, id := range m.order {
+		out = append(out, m.vehicles[id])
 	}
 	return out
 }
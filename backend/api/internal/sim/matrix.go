@@ -0,0 +1,340 @@
+package sim
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	grid "routeiq/internal/grid"
+)
+
+// MaxMatrixPoints bounds the origins, and separately the destinations, of a
+// travel-time matrix, and MaxMatrixEntries the pairs between them.
+const (
+	MaxMatrixPoints  = 100
+	MaxMatrixEntries = 2500
+)
+
+// MaxMatrixWork bounds the search states one matrix may expand, shared
+// evenly between its origins. It keeps a matrix on a large grid from holding
+// up the simulation, as the searches run while ticks wait: about half a
+// second of one core.
+const MaxMatrixWork = 1 << 19
+
+// ErrMatrixTooLarge means a matrix has more points or pairs than allowed, or
+// needs more search than MaxMatrixWork.
+var ErrMatrixTooLarge = errors.New("matrix too large")
+
+// Matrix holds the travel between every origin and every destination: row i
+// is origin i and column j destination j. Pairs with no route are -1 in both.
+type Matrix struct {
+	Durations [][]float64   // seconds, measured as Route measures its ETA
+	Distances [][]int       // cells moved
+	Depart    time.Duration // time of day the trips leave
+}
+
+// Matrix returns the travel time and distance from each origin to each
+// destination under the same conditions Route plans with. Rather than one
+// route query per pair it grows a shortest-path tree from each origin
+// (Dijkstra over the same cell and heading states), stopping once every
+// destination is settled, and grows the trees in parallel. Each entry is the
+// cheapest route's, so it matches the ETA and distance Route would report.
+// Destinations in another connected region of the network than an origin
+// are known to be unreachable and are not searched for.
+//
+// RouteOptions.AvoidIncidents, IgnoreSignals and DepartAt apply; Weight and
+// ArriveBy do not, as the trees are exact and an arrival time would need a
+// departure per pair. The lists are limited by MaxMatrixPoints and
+// MaxMatrixEntries, and each tree may expand MaxMatrixWork divided by the
+// number of origins states; a tree that needs more fails the matrix with
+// ErrMatrixTooLarge.
+func (e *Engine) Matrix(origins, destinations [][2]int, opts RouteOptions) (Matrix, error) {
+	if err := opts.Validate(); err != nil {
+		return Matrix{}, err
+	}
+	if opts.Weight != 0 || opts.ArriveBy != nil {
+		return Matrix{}, errors.New("matrices take no weight or arrival time")
+	}
+	switch {
+	case len(origins) == 0 || len(destinations) == 0:
+		return Matrix{}, errors.New("origins and destinations are required")
+	case len(origins) > MaxMatrixPoints || len(destinations) > MaxMatrixPoints:
+		return Matrix{}, fmt.Errorf("%w: at most %d origins and %d destinations, got %d and %d",
+			ErrMatrixTooLarge, MaxMatrixPoints, MaxMatrixPoints, len(origins), len(destinations))
+	case len(origins)*len(destinations) > MaxMatrixEntries:
+		return Matrix{}, fmt.Errorf("%w: at most %d pairs, got %d",
+			ErrMatrixTooLarge, MaxMatrixEntries, len(origins)*len(destinations))
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	goals := make([]point, len(destinations))
+	for i, c := range origins {
+		if err := e.checkPointLocked(fmt.Sprintf("origins[%d]", i), c[0], c[1]); err != nil {
+			return Matrix{}, err
+		}
+	}
+	for i, c := range destinations {
+		if err := e.checkPointLocked(fmt.Sprintf("destinations[%d]", i), c[0], c[1]); err != nil {
+			return Matrix{}, err
+		}
+		goals[i] = point{c[0], c[1]}
+	}
+
+	q := query{}
+	if !opts.IgnoreSignals {
+		q.wait = e.signalWaitLocked
+	}
+	if opts.DepartAt != nil {
+		e.departLocked(&q, e.secondsUntilLocked(*opts.DepartAt))
+	}
+	m := Matrix{
+		Durations: make([][]float64, len(origins)),
+		Distances: make([][]int, len(origins)),
+		Depart:    (e.timeOfDayLocked() + time.Duration(q.at)*time.Second) % (24 * time.Hour),
+	}
+	region := e.pf.regions.get(e.pf)
+	budget := MaxMatrixWork / len(origins)
+	// the searches only read the engine, which the read lock keeps still
+	var (
+		wg     sync.WaitGroup
+		failed sync.Once
+		err    error
+		stop   atomic.Bool // a tree ran out of budget; the rest need not run
+	)
+	jobs := make(chan int)
+	for range min(runtime.GOMAXPROCS(0), len(origins)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if stop.Load() {
+					continue
+				}
+				start := point{origins[i][0], origins[i][1]}
+				oq := q
+				if opts.AvoidIncidents {
+					// goals are exempt only as the end of their own route: see searchTree
+					oq.avoid = func(pt point) bool {
+						return pt != start && e.pf.IncidentPenalty(pt.X, pt.Y) > 1
+					}
+				}
+				skip := make([]bool, len(goals))
+				for j, g := range goals {
+					skip[j] = region(start) != region(g)
+				}
+				times, cells, ok := e.pf.searchTree(start, goals, skip, oq, budget)
+				if !ok {
+					failed.Do(func() {
+						err = fmt.Errorf("%w: the search from origins[%d] (%d,%d) needs more than %d states; ask for fewer origins",
+							ErrMatrixTooLarge, i, start.X, start.Y, budget)
+						stop.Store(true)
+					})
+				}
+				m.Durations[i], m.Distances[i] = times, cells
+			}
+		}()
+	}
+	for i := range origins {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	if err != nil {
+		return Matrix{}, err
+	}
+	return m, nil
+}
+
+// regionCache labels the cells of a network by connected region, ignoring
+// the direction of moves, and relabels when the routing topology changes.
+// Cells in different regions cannot reach each other.
+type regionCache struct {
+	mu      sync.Mutex
+	version uint64
+	label   []int32 // per cell in row-major order; -1 for blocked cells
+}
+
+// get returns the region of each cell of p.
+func (c *regionCache) get(p *PathFinder) func(point) int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.label == nil || c.version != p.Version() {
+		c.label, c.version = p.labelRegions(), p.Version()
+	}
+	label, w := c.label, p.Width
+	return func(pt point) int32 { return label[pt.Y*w+pt.X] }
+}
+
+// labelRegions floods the grid over allowed moves in either direction.
+func (p *PathFinder) labelRegions() []int32 {
+	label := make([]int32, p.Width*p.Height)
+	for i := range label {
+		label[i] = -1
+	}
+	var next int32
+	var stack []point
+	for y := 0; y < p.Height; y++ {
+		for x := 0; x < p.Width; x++ {
+			if label[y*p.Width+x] >= 0 || p.isBlocked(x, y) {
+				continue
+			}
+			label[y*p.Width+x] = next
+			stack = append(stack[:0], point{x, y})
+			for len(stack) > 0 {
+				c := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				for _, d := range grid.Directions {
+					dx, dy := d.Delta()
+					n := point{c.X + dx, c.Y + dy}
+					if !p.CanMove(c.X, c.Y, n.X, n.Y) && (p.isBlocked(n.X, n.Y) || !p.CanMove(n.X, n.Y, c.X, c.Y)) {
+						continue
+					}
+					if i := n.Y*p.Width + n.X; label[i] < 0 {
+						label[i] = next
+						stack = append(stack, n)
+					}
+				}
+			}
+			next++
+		}
+	}
+	return label
+}
+
+// treeNode is a state reached by a tree search.
+type treeNode struct {
+	st    state
+	g     float64 // cost from the start, in seconds from now as in search
+	ready float64 // when the cell is ready to be entered
+	eta   float64 // driving time from the start, turn penalties left out
+	steps int32   // cells moved
+	pos   int32   // place in the heap; -1 once settled
+	leaf  bool    // an avoided goal: routes may end here but not pass through
+}
+
+// tree is the state of a tree search: the states reached, indexed densely in
+// the order they were found, and a heap of the unsettled ones.
+type tree struct {
+	index map[state]int32
+	nodes []treeNode
+	open  []int32
+}
+
+func (t *tree) Len() int           { return len(t.open) }
+func (t *tree) Less(i, j int) bool { return t.nodes[t.open[i]].g < t.nodes[t.open[j]].g }
+func (t *tree) Swap(i, j int) {
+	t.open[i], t.open[j] = t.open[j], t.open[i]
+	t.nodes[t.open[i]].pos, t.nodes[t.open[j]].pos = int32(i), int32(j)
+}
+func (t *tree) Push(x any) {
+	n := x.(int32)
+	t.nodes[n].pos = int32(len(t.open))
+	t.open = append(t.open, n)
+}
+func (t *tree) Pop() any {
+	n := t.open[len(t.open)-1]
+	t.open = t.open[:len(t.open)-1]
+	t.nodes[n].pos = -1
+	return n
+}
+
+// searchTree runs Dijkstra from start under q until every goal not skipped
+// is settled, and returns for the cheapest route to each goal its driving
+// time, as routeAlongLocked measures it (turn penalties steer the search but
+// take no time), and the cells it moves, or -1 for goals it cannot reach or
+// skips. The search stops once the cheapest state left costs at least as
+// much as every goal's route, since no later state can improve on one. A
+// goal on a cell q avoids is reached as Route would reach it as its
+// destination, but no route to another goal passes through it. It returns
+// false if settling the goals takes more than budget states.
+func (p *PathFinder) searchTree(start point, goals []point, skip []bool, q query, budget int) ([]float64, []int, bool) {
+	times := make([]float64, len(goals))
+	cells := make([]int, len(goals))
+	for i := range goals {
+		times[i], cells[i] = -1, -1
+	}
+	type reached struct {
+		cost, time float64
+		cells      int
+	}
+	best := make(map[point]reached, len(goals))
+	pending := make(map[point]bool, len(goals))
+	for i, g := range goals {
+		if !skip[i] {
+			pending[g] = true
+		}
+	}
+	isGoal := make(map[point]bool, len(pending))
+	for g := range pending {
+		isGoal[g] = true
+	}
+	if pending[start] {
+		best[start] = reached{}
+		delete(pending, start)
+	}
+	bound := 0.0 // highest route cost found; no goal can need more once all have one
+
+	t := &tree{index: make(map[state]int32)}
+	first := state{start, q.heading}
+	t.index[first] = 0
+	t.nodes = append(t.nodes, treeNode{st: first, g: q.at, ready: q.ready})
+	heap.Push(t, int32(0))
+	expanded := 0
+	for t.Len() > 0 {
+		cur := t.nodes[heap.Pop(t).(int32)]
+		if len(pending) == 0 && cur.g-q.at >= bound {
+			break
+		}
+		if expanded++; expanded > budget {
+			return nil, nil, false
+		}
+		if isGoal[cur.st.pt] && cur.st != first {
+			if w, ok := p.finalWait(cur.st.pt, cur.st.heading, cur.ready, q); ok {
+				r := reached{cur.g + w - q.at, cur.eta + w, int(cur.steps)}
+				if b, found := best[cur.st.pt]; !found || r.cost < b.cost {
+					best[cur.st.pt] = r
+					delete(pending, cur.st.pt)
+					bound = max(bound, r.cost)
+				}
+			}
+		}
+		if cur.leaf {
+			continue
+		}
+
+		for _, d := range grid.Directions {
+			dx, dy := d.Delta()
+			nb := state{point{cur.st.pt.X + dx, cur.st.pt.Y + dy}, d}
+			i, seen := t.index[nb]
+			leaf := q.avoid != nil && q.avoid(nb.pt)
+			if seen && t.nodes[i].pos < 0 || leaf && !isGoal[nb.pt] {
+				continue // settled, or avoided
+			}
+			r, tentative, w, ok := p.advance(cur.st.pt, cur.st.heading, cur.ready, cur.g, cur.st != first || q.midway, nb.pt, q)
+			if !ok || seen && tentative >= t.nodes[i].g {
+				continue
+			}
+			n := treeNode{st: nb, g: tentative, ready: r, eta: cur.eta + w + tentative - r, steps: cur.steps + 1, leaf: leaf}
+			if seen {
+				n.pos = t.nodes[i].pos
+				t.nodes[i] = n
+				heap.Fix(t, int(n.pos))
+				continue
+			}
+			i = int32(len(t.nodes))
+			t.index[nb] = i
+			t.nodes = append(t.nodes, n)
+			heap.Push(t, i)
+		}
+	}
+	for i, g := range goals {
+		if r, ok := best[g]; ok && !skip[i] {
+			times[i], cells[i] = r.time, r.cells
+		}
+	}
+	return times, cells, true
+}
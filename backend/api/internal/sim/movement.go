@@ -1,38 +1,202 @@
 package sim
 
-// Occupancy tracks reserved cells per tick to avoid collisions.
-type Occupancy struct{ cells map[[2]int]bool }
-func NewOccupancy() *Occupancy { return &Occupancy{cells: make(map[[2]int]bool)} }
-func (o *Occupancy) Reset() { o.cells = make(map[[2]int]bool) }
-func (o *Occupancy) TryReserve(x,y int) bool {
-	k := [2]int{x,y}
-	if o.cells[k] { return false }
-	o.cells[k] = true
-	return true
-}
-
-// MoveOneTick moves vehicles by at most one cell along their path, respecting intersection lights and collisions.
-// intersections: map of intersection coords -> current light state ("red"/"yellow"/"green").
-func MoveOneTick(vehicles []*Vehicle, pf *PathFinder, intersections map[[2]int]string, occ *Occupancy, dests map[string]point) {
+import "sort"
+
+// DefaultStuckAfter is how many ticks a vehicle may be held up by other
+// vehicles (not by lights) before it is rerouted: one and a half default cycles.
+const DefaultStuckAfter = 90
+
+// CellCapacity is the road space of a cell: its lanes, and how many vehicles
+// queue in each lane. Up to Lanes vehicles enter and leave the cell per tick.
+type CellCapacity struct {
+	Lanes   int
+	Storage int
+}
+
+// Vehicles returns how many vehicles fit in the cell.
+func (c CellCapacity) Vehicles() int { return c.Lanes * c.Storage }
+
+// Occupancy tracks how many vehicles are in each cell while a tick is resolved.
+// Cell capacities and the order vehicles arrived in each cell persist across Reset.
+// StuckAfter overrides DefaultStuckAfter when positive.
+type Occupancy struct {
+	count map[[2]int]int
+	entered map[[2]int]int // vehicles that entered each cell this tick
+	rear map[[2]int]float64 // progress of the rearmost vehicle in each cell at the start of the tick
+	caps map[[2]int]CellCapacity
+	seq uint64 // arrival counter, orders the queue within a cell
+	StuckAfter int
+	Default CellCapacity // capacity of cells without their own; one lane of one vehicle if zero
+}
+func NewOccupancy() *Occupancy {
+	o := &Occupancy{caps: make(map[[2]int]CellCapacity)}
+	o.Reset()
+	return o
+}
+func (o *Occupancy) Reset() { o.count = make(map[[2]int]int); o.entered = make(map[[2]int]int); o.rear = make(map[[2]int]float64) }
+
+// SetCapacity sets the lanes and per-lane storage of (x,y); values below 1 count as 1.
+func (o *Occupancy) SetCapacity(x, y int, c CellCapacity) {
+	o.caps[[2]int{x, y}] = CellCapacity{Lanes: max(c.Lanes, 1), Storage: max(c.Storage, 1)}
+}
+
+// Capacity returns the lanes and per-lane storage of (x,y).
+func (o *Occupancy) Capacity(x, y int) CellCapacity {
+	if c, ok := o.caps[[2]int{x, y}]; ok { return c }
+	return CellCapacity{Lanes: max(o.Default.Lanes, 1), Storage: max(o.Default.Storage, 1)}
+}
+
+// hasRoom reports whether a vehicle can enter p this tick: there is space and a free lane.
+func (o *Occupancy) hasRoom(p point) bool {
+	c, k := o.Capacity(p.X, p.Y), [2]int{p.X, p.Y}
+	return o.count[k] < c.Vehicles() && o.entered[k] < c.Lanes
+}
+
+// enter moves v into its next cell and puts it at the back of that cell's queue.
+func (o *Occupancy) enter(v *Vehicle) {
+	next, _ := v.nextStep()
+	o.count[[2]int{v.X, v.Y}]--
+	o.count[[2]int{next.X, next.Y}]++
+	o.entered[[2]int{next.X, next.Y}]++
+	o.seq++
+	v.arrival = o.seq
+	v.advance()
+	v.moved = true
+}
+
+// rankQueues sets each vehicle's place in the queue of its cell: the order it
+// arrived in, ties kept in slice order.
+func rankQueues(vehicles []*Vehicle) {
+	cells := make(map[point][]*Vehicle)
+	for _, v := range vehicles { cells[point{v.X, v.Y}] = append(cells[point{v.X, v.Y}], v) }
+	for _, q := range cells {
+		sort.SliceStable(q, func(i, j int) bool { return q[i].arrival < q[j].arrival })
+		for i, v := range q { v.queueRank = i }
+	}
+}
+
+func (o *Occupancy) stuckAfter() int {
+	if o.StuckAfter > 0 { return o.StuckAfter }
+	return DefaultStuckAfter
+}
+
+// MoveResult summarises one tick of movement.
+type MoveResult struct {
+	Moved    int // vehicles that advanced a cell
+	Blocked  int // vehicles free to move (route, rules and lights allow it) but held up by other vehicles
+	Cycles   int // wait-for cycles resolved by moving every vehicle in them at once
+	Rotated  int // vehicles moved as part of those cycles
+	Rerouted int // vehicles given a detour after being blocked for StuckAfter ticks
+}
+
+// MoveOneTick moves vehicles by at most one cell along their planned route, respecting intersection lights and collisions.
+// Routes are planned once and only recomputed when the destination or the PathFinder topology changes;
+// moves the PathFinder forbids (blocked cells, one-way edges, turn restrictions) are never taken.
+// lights reports the state ("red"/"yellow"/"green") each intersection shows to the movement the vehicle is about to make;
+// wrap a plain coords -> state map in UniformLights to show one state to every approach.
+// A vehicle entering a cell with an incident penalty is held there for ceil(penalty)-1 extra ticks.
+// A vehicle only enters a cell with room left after this tick's moves, so it can follow a vehicle that is
+// leaving but not pass one that stays; when vehicles wait on each other in a cycle they all move at once.
+// Cells hold occ's capacity: vehicles queue in arrival order, and at most Lanes enter and leave each cell per tick.
+// Emergency vehicles move first, so the cells they reserve are yielded by everyone else, they are not held by lights,
+// and they may enter a full cell (other traffic pulls aside).
+func MoveOneTick(vehicles []*Vehicle, pf *PathFinder, lights Signals, occ *Occupancy, dests map[string]point) MoveResult {
 	if occ == nil { occ = NewOccupancy() } else { occ.Reset() }
 	for _, v := range vehicles {
-		d, ok := dests[v.ID]
-		if !ok { d = point{v.DestX, v.DestY} }
-		path := pf.Path(v.X, v.Y, d.X, d.Y)
-		if len(path) <= 1 { // at destination or no path
-			continue
-		}
-		next := path[1]
-		// stop at red or yellow when entering an intersection cell
-		if state, isIntersection := intersections[[2]int{next.X, next.Y}]; isIntersection {
-			if state == "red" || state == "yellow" {
+		k := [2]int{v.X, v.Y}
+		if r, ok := occ.rear[k]; !ok || v.position() < r { occ.rear[k] = v.position() }
+		occ.count[k]++
+	}
+	rankQueues(vehicles)
+	var ready []*Vehicle // in priority order: emergency vehicles first
+	var queued []*Vehicle // free to move but behind the front of their cell's queue
+	for _, emergency := range []bool{true, false} {
+		for _, v := range vehicles {
+			if v.Type.Emergency() != emergency || !wantsToMove(v, pf, lights, occ, dests) {
 				continue
 			}
+			if v.queueRank < occ.Capacity(v.X, v.Y).Lanes || emergency {
+				ready = append(ready, v)
+			} else {
+				queued = append(queued, v)
+			}
 		}
-		// collision prevention: reserve next cell
-		if !occ.TryReserve(next.X, next.Y) {
+	}
+	resolveMoves(ready, occ)
+	var res MoveResult
+	res.Cycles, res.Rotated = rotateCycles(ready, occ)
+	for _, v := range append(ready, queued...) {
+		if v.Dynamics.Enabled() { v.settle() } else if v.moved { v.Speed = 1 }
+		if v.moved {
+			res.Moved++
+			v.blocked = 0
+			v.dwell = dwellTicks(pf.IncidentPenalty(v.X, v.Y))
 			continue
 		}
-		v.X, v.Y = next.X, next.Y
+		res.Blocked++
+		if v.blocked++; v.blocked >= occ.stuckAfter() {
+			v.blocked = 0
+			if v.detour(pf) { res.Rerouted++ }
+		}
+	}
+	return res
+}
+
+// wantsToMove plans the vehicle's route and reports whether its next step is
+// allowed by the route, the PathFinder and the lights, and, for a kinematic
+// vehicle, whether it reaches the next cell this tick.
+func wantsToMove(v *Vehicle, pf *PathFinder, lights Signals, occ *Occupancy, dests map[string]point) bool {
+	v.moved = false
+	if !v.Dynamics.Enabled() { v.Speed = 0 }
+	if v.dwell > 0 { // held up by an incident in this cell
+		v.dwell--
+		v.Speed = 0
+		return false
+	}
+	d, ok := dests[v.ID]
+	if !ok { d = point{v.DestX, v.DestY} }
+	v.ensureRoute(pf, d)
+	next, ok := v.nextStep()
+	if !ok { // at destination or no path
+		v.Speed = 0
+		return false
+	}
+	// never take a forbidden edge or turn, even on a stale plan
+	if _, allowed := pf.stepCost(point{v.X, v.Y}, v.Heading, next); !allowed {
+		v.planned = false
+		v.Speed = 0
+		return false
+	}
+	// stop at red or yellow when entering an intersection cell on this approach
+	green := true
+	if lights != nil && !v.Type.Emergency() {
+		if state, isIntersection := lights.SignalFor(next.X, next.Y, v.nextMovement()); isIntersection {
+			green = state != "red" && state != "yellow"
+		}
+	}
+	if v.Dynamics.Enabled() { return v.accelerate(pf, lights, occ, green) }
+	return green
+}
+
+// resolveMoves advances every ready vehicle whose next cell has room, in
+// priority order. A vehicle blocked by a full cell (or one whose lanes have all
+// taken a vehicle this tick) waits on it and is retried,
+// ahead of later arrivals, as soon as a vehicle leaves that cell.
+func resolveMoves(ready []*Vehicle, occ *Occupancy) {
+	waiting := make(map[point][]*Vehicle)
+	var try func(v *Vehicle)
+	try = func(v *Vehicle) {
+		next, _ := v.nextStep()
+		if !occ.hasRoom(next) && !v.Type.Emergency() {
+			waiting[next] = append(waiting[next], v)
+			return
+		}
+		from := point{v.X, v.Y}
+		occ.enter(v)
+		if q := waiting[from]; len(q) > 0 {
+			waiting[from] = q[1:]
+			try(q[0])
+		}
 	}
+	for _, v := range ready { try(v) }
 }
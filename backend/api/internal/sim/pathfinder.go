@@ -3,19 +3,262 @@ package sim
 import (
 	"container/heap"
 	"math"
+
+	grid "routeiq/internal/grid"
 )
 
 type point struct{ X, Y int }
 
+// PathFinder plans routes over the grid with A*. Entering a cell costs
+// base cost x congestion multiplier x incident penalty; cells without an
+// explicit base cost cost 1. Moves between neighbouring cells are allowed in
+// both directions unless the edge is closed, which models one-way streets,
+// and turns are allowed everywhere unless a cell has turn rules.
 type PathFinder struct{
 	Width int
 	Height int
 	Blocked map[[2]int]bool // blocked cells
+	version uint64          // bumped whenever routing topology changes
+
+	baseCost   map[[2]int]float64 // per-cell base cost; 1 if absent
+	congestion map[[2]int]float64 // per-cell congestion multiplier, >= 1
+	penalty    map[[2]int]float64 // per-cell incident penalty, >= 1
+	minBase    float64            // lowest base cost, scales the heuristic so it stays admissible
+	speedLimit map[[2]int]float64 // per-cell speed limit in cells per second, (0,1]; 1 if absent
+
+	closedEdges map[[4]int]bool          // directed moves {fromX, fromY, toX, toY} that are not allowed
+	turnRules   map[point]grid.TurnRules // movement rules at intersections
+
+	regions regionCache // connected regions, for matrices
 }
 
 func NewPathFinder(width, height int, blocked map[[2]int]bool) *PathFinder {
 	if blocked == nil { blocked = make(map[[2]int]bool) }
-	return &PathFinder{Width: width, Height: height, Blocked: blocked}
+	return &PathFinder{
+		Width:      width,
+		Height:     height,
+		Blocked:    blocked,
+		baseCost:   make(map[[2]int]float64),
+		congestion: make(map[[2]int]float64),
+		penalty:    make(map[[2]int]float64),
+		speedLimit: make(map[[2]int]float64),
+		minBase:    1,
+
+		closedEdges: make(map[[4]int]bool),
+		turnRules:   make(map[point]grid.TurnRules),
+	}
+}
+
+// SetBlocked blocks or unblocks a cell. Changing a cell invalidates planned routes.
+func (p *PathFinder) SetBlocked(x, y int, blocked bool) {
+	k := [2]int{x,y}
+	if p.Blocked[k] == blocked { return }
+	if blocked { p.Blocked[k] = true } else { delete(p.Blocked, k) }
+	p.version++
+}
+
+// Version changes whenever blocked cells, edges, turn rules or incident penalties change,
+// so callers holding planned routes can tell when they are stale.
+func (p *PathFinder) Version() uint64 { return p.version }
+
+// SetBaseCost sets the cost of entering (x,y) under free-flow conditions.
+// Non-positive costs are ignored.
+func (p *PathFinder) SetBaseCost(x, y int, cost float64) {
+	if cost <= 0 || !p.inBounds(x, y) {
+		return
+	}
+	p.baseCost[[2]int{x, y}] = cost
+	if cost < p.minBase {
+		p.minBase = cost
+	}
+}
+
+// SetCongestion sets the congestion multiplier of (x,y). Values below 1 are
+// clamped to 1 (free flow). Congestion does not invalidate planned routes.
+func (p *PathFinder) SetCongestion(x, y int, multiplier float64) {
+	setMultiplier(p.congestion, x, y, multiplier)
+}
+
+// Congestion returns the congestion multiplier of (x,y); 1 at free flow.
+func (p *PathFinder) Congestion(x, y int) float64 {
+	if c, ok := p.congestion[[2]int{x, y}]; ok {
+		return c
+	}
+	return 1
+}
+
+// SetIncidentPenalty sets the incident penalty of (x,y). Values below 1 are
+// clamped to 1 (no incident). Changing a penalty invalidates planned routes.
+func (p *PathFinder) SetIncidentPenalty(x, y int, penalty float64) {
+	k := [2]int{x, y}
+	before := p.penalty[k]
+	setMultiplier(p.penalty, x, y, penalty)
+	if p.penalty[k] != before {
+		p.version++
+	}
+}
+
+// IncidentPenalty returns the incident penalty of (x,y); 1 if there is none.
+func (p *PathFinder) IncidentPenalty(x, y int) float64 {
+	if c, ok := p.penalty[[2]int{x, y}]; ok {
+		return c
+	}
+	return 1
+}
+
+func setMultiplier(m map[[2]int]float64, x, y int, v float64) {
+	k := [2]int{x, y}
+	if v <= 1 {
+		delete(m, k)
+		return
+	}
+	m[k] = v
+}
+
+// BaseCost returns the free-flow cost of entering (x,y): its explicit base
+// cost, or otherwise the seconds to cross it at its speed limit.
+func (p *PathFinder) BaseCost(x, y int) float64 {
+	if c, ok := p.baseCost[[2]int{x, y}]; ok {
+		return c
+	}
+	return 1 / p.SpeedLimit(x, y)
+}
+
+// SetSpeedLimit sets the speed limit of (x,y) in cells per second, clamped to
+// (0,1]; non-positive limits are ignored. Cells without an explicit base cost
+// cost 1/limit, so routes and free-flow times account for slow streets.
+func (p *PathFinder) SetSpeedLimit(x, y int, limit float64) {
+	if limit <= 0 || !p.inBounds(x, y) {
+		return
+	}
+	if limit >= 1 {
+		delete(p.speedLimit, [2]int{x, y})
+		return
+	}
+	p.speedLimit[[2]int{x, y}] = limit
+}
+
+// SpeedLimit returns the speed limit of (x,y) in cells per second.
+func (p *PathFinder) SpeedLimit(x, y int) float64 {
+	if l, ok := p.speedLimit[[2]int{x, y}]; ok {
+		return l
+	}
+	return 1
+}
+
+// Multiplier returns the combined congestion and incident multiplier of (x,y).
+func (p *PathFinder) Multiplier(x, y int) float64 {
+	k := [2]int{x, y}
+	m := 1.0
+	if c, ok := p.congestion[k]; ok {
+		m *= c
+	}
+	if c, ok := p.penalty[k]; ok {
+		m *= c
+	}
+	return m
+}
+
+// CellCost returns the current cost of entering (x,y).
+func (p *PathFinder) CellCost(x, y int) float64 {
+	return p.BaseCost(x, y) * p.Multiplier(x, y)
+}
+
+// PathCost returns the cost of travelling along path, excluding the start
+// cell, including turn penalties.
+func (p *PathFinder) PathCost(path []point) float64 {
+	total := 0.0
+	heading := grid.NoDirection
+	for i := 1; i < len(path); i++ {
+		total += p.CellCost(path[i].X, path[i].Y)
+		d := dirBetween(path[i-1], path[i])
+		if rules, ok := p.turnRules[path[i-1]]; ok && heading != grid.NoDirection {
+			total += rules.Cost(grid.TurnBetween(heading, d))
+		}
+		heading = d
+	}
+	return total
+}
+
+// SetEdge allows or forbids the directed move from (fx,fy) to its neighbour
+// (tx,ty). Changing an edge invalidates planned routes.
+func (p *PathFinder) SetEdge(fx, fy, tx, ty int, allowed bool) {
+	k := [4]int{fx, fy, tx, ty}
+	if !p.closedEdges[k] == allowed {
+		return
+	}
+	if allowed {
+		delete(p.closedEdges, k)
+	} else {
+		p.closedEdges[k] = true
+	}
+	p.version++
+}
+
+// SetOneWay makes (x,y) a one-way cell carrying traffic in direction dir:
+// vehicles may not enter it or leave it while moving against dir. Turning
+// on or off the street sideways is still allowed.
+func (p *PathFinder) SetOneWay(x, y int, dir grid.Direction) {
+	dx, dy := dir.Delta()
+	if dx == 0 && dy == 0 {
+		return
+	}
+	// leaving against the flow, and entering from downstream
+	p.SetEdge(x, y, x-dx, y-dy, false)
+	p.SetEdge(x+dx, y+dy, x, y, false)
+}
+
+// CanMove reports whether a vehicle may step from (fx,fy) to the neighbouring
+// cell (tx,ty): both cells in bounds, the target unblocked and the edge open.
+func (p *PathFinder) CanMove(fx, fy, tx, ty int) bool {
+	if grid.DirectionOf(tx-fx, ty-fy) == grid.NoDirection {
+		return false
+	}
+	return p.inBounds(fx, fy) && p.inBounds(tx, ty) && !p.isBlocked(tx, ty) &&
+		!p.closedEdges[[4]int{fx, fy, tx, ty}]
+}
+
+// TrappedCells returns open cells that can be entered from a neighbour but
+// have no allowed move out, in row-major order. A valid road network has none.
+func (p *PathFinder) TrappedCells() [][2]int {
+	var out [][2]int
+	for y := 0; y < p.Height; y++ {
+		for x := 0; x < p.Width; x++ {
+			if p.isBlocked(x, y) {
+				continue
+			}
+			in, exit := false, false
+			for _, d := range grid.Directions {
+				dx, dy := d.Delta()
+				in = in || p.CanMove(x+dx, y+dy, x, y)
+				exit = exit || p.CanMove(x, y, x+dx, y+dy)
+			}
+			if in && !exit {
+				out = append(out, [2]int{x, y})
+			}
+		}
+	}
+	return out
+}
+
+// SetTurnRules sets the movement rules for vehicles passing through (x,y).
+// Empty rules remove any restriction. Changing rules invalidates planned routes.
+// Rules with a negative or non-finite penalty are refused.
+func (p *PathFinder) SetTurnRules(x, y int, rules grid.TurnRules) error {
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	k := point{x, y}
+	if rules.Empty() {
+		if _, ok := p.turnRules[k]; !ok {
+			return nil
+		}
+		delete(p.turnRules, k)
+	} else {
+		p.turnRules[k] = rules
+	}
+	p.version++
+	return nil
 }
 
 func (p *PathFinder) inBounds(x,y int) bool {
@@ -30,82 +273,293 @@ func manhattan(a, b point) int {
 	return int(math.Abs(float64(a.X-b.X)) + math.Abs(float64(a.Y-b.Y)))
 }
 
+// heuristic is the Manhattan distance scaled by the cheapest possible step,
+// which never overestimates because multipliers are at least 1.
+func (p *PathFinder) heuristic(a, b point) float64 {
+	return float64(manhattan(a, b)) * p.minBase
+}
+
+// dirBetween returns the heading of the unit step from a to b.
+func dirBetween(a, b point) grid.Direction {
+	return grid.DirectionOf(b.X-a.X, b.Y-a.Y)
+}
+
+// stepCost returns the cost of moving from cur, entered with heading, to the
+// neighbouring cell next, and false if edges or turn rules forbid the move.
+// A NoDirection heading (a vehicle that has not moved yet) may turn freely.
+func (p *PathFinder) stepCost(cur point, heading grid.Direction, next point) (float64, bool) {
+	c, ok := p.turnCost(cur, heading, next)
+	if !ok {
+		return 0, false
+	}
+	return c + p.CellCost(next.X, next.Y), true
+}
+
+// turnCost returns the turn penalty of the move from cur, entered with
+// heading, to next, and false if edges or turn rules forbid the move.
+func (p *PathFinder) turnCost(cur point, heading grid.Direction, next point) (float64, bool) {
+	if !p.CanMove(cur.X, cur.Y, next.X, next.Y) {
+		return 0, false
+	}
+	if rules, ok := p.turnRules[cur]; ok && heading != grid.NoDirection {
+		t := grid.TurnBetween(heading, dirBetween(cur, next))
+		if !rules.Allows(t) {
+			return 0, false
+		}
+		return rules.Cost(t), true
+	}
+	return 0, true
+}
+
+// state is a search state: a cell plus the heading it was entered with, so
+// turn rules can be applied to the move out of it.
+type state struct {
+	pt      point
+	heading grid.Direction
+}
+
 type node struct {
-	pt point
-	g  int // cost from start
-	h  int // heuristic to goal
-	f  int // g+h
-	idx int // heap idx
+	st  state
+	g   float64 // cost from start
+	h   float64 // heuristic to goal
+	f   float64 // g+h
+	idx int     // heap idx
 }
 
 type nodePQ []*node
 
 func (pq nodePQ) Len() int { return len(pq) }
-func (pq nodePQ) Less(i, j int) bool { return pq[i].f < pq[j].f }
+func (pq nodePQ) Less(i, j int) bool {
+	if pq[i].f == pq[j].f {
+		return pq[i].h < pq[j].h
+	}
+	return pq[i].f < pq[j].f
+}
 func (pq nodePQ) Swap(i, j int) { pq[i], pq[j] = pq[j], pq[i]; pq[i].idx=i; pq[j].idx=j }
 func (pq *nodePQ) Push(x any) { n := x.(*node); n.idx = len(*pq); *pq = append(*pq, n) }
 func (pq *nodePQ) Pop() any { old := *pq; n := len(old); x := old[n-1]; *pq = old[:n-1]; return x }
 
-// Path returns a sequence of points from start to goal, inclusive. Empty if no path.
+// Path returns the cheapest sequence of points from start to goal, inclusive. Empty if no path.
 func (p *PathFinder) Path(sx, sy, gx, gy int) []point {
-	start := point{sx,sy}
+	return p.PathFrom(sx, sy, grid.NoDirection, gx, gy)
+}
+
+// PathFrom is Path for a vehicle that entered (sx,sy) travelling in heading,
+// so turn rules at the start cell apply to its first move. The search runs
+// over (cell, heading) states so turn restrictions and penalties are exact.
+func (p *PathFinder) PathFrom(sx, sy int, heading grid.Direction, gx, gy int) []point {
+	path, _ := p.search(sx, sy, gx, gy, query{heading: heading})
+	return path
+}
+
+// FreeFlowCost returns the cost of the cheapest route from (sx,sy) to (gx,gy)
+// with no congestion or incident penalties, which at one cell per second of
+// base cost is the free-flow travel time in seconds. Blocked cells, one-way
+// edges and turn rules still apply. ok is false if there is no route.
+func (p *PathFinder) FreeFlowCost(sx, sy int, heading grid.Direction, gx, gy int) (cost float64, ok bool) {
+	path, cost := p.search(sx, sy, gx, gy, query{heading: heading, freeFlow: true})
+	return cost, path != nil
+}
+
+// query parameterises a search.
+type query struct {
+	heading  grid.Direction            // heading the start cell was entered with
+	freeFlow bool                      // use base costs only
+	avoid    func(point) bool          // cells treated as blocked; none if nil
+	forbid   func(from, to point) bool // moves treated as closed; none if nil
+	weight   float64                   // heuristic weight; 1 (optimal) if below 1
+	// expansions, if set, is how many more states searches sharing it may
+	// expand; a search that runs out finds no path.
+	expansions *int
+
+	// wait returns the wait for green before entering a cell to make movement
+	// m through it, for a vehicle ready to enter t seconds from now, and
+	// false if m never gets green; no waits if nil. Waits are monotone in t
+	// (arriving later never gets through earlier), so searching on arrival
+	// times stays exact.
+	wait func(at point, m Movement, t float64) (float64, bool)
+	// congestion returns the multiplier of a cell for a vehicle entering it
+	// t seconds from now; the current one if nil. It must not drop below 1.
+	congestion func(pt point, t float64) float64
+	// at is when, in seconds from now, the vehicle leaves the start cell.
+	// midway means the start cell was entered on the way there, ready to be
+	// entered at ready seconds from now, so its signal still applies.
+	at     float64
+	midway bool
+	ready  float64
+}
+
+// crossCost is the time to cross pt for a vehicle entering it t seconds from now.
+func (p *PathFinder) crossCost(pt point, t float64, q query) float64 {
+	switch {
+	case q.freeFlow:
+		return p.BaseCost(pt.X, pt.Y)
+	case q.congestion != nil:
+		return p.BaseCost(pt.X, pt.Y) * q.congestion(pt, t)
+	}
+	return p.CellCost(pt.X, pt.Y)
+}
+
+// advance drives from cur, entered with heading, to next under q. The
+// vehicle was ready to enter cur at ready and has crossed it at g, in
+// seconds from now; advance returns the same two times for next, the wait at
+// cur's signal, and false if the move is not allowed or waits forever for
+// green. The movement through a cell is only known on leaving it, so its
+// signal's wait is charged then, timed from when the cell was ready to be
+// entered; signal is false where none applies, at the start of a route.
+func (p *PathFinder) advance(cur point, heading grid.Direction, ready, g float64, signal bool, next point, q query) (float64, float64, float64, bool) {
+	turn, ok := p.turnCost(cur, heading, next)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	w := 0.0
+	if signal && q.wait != nil {
+		m := Movement{Approach: heading, Turn: grid.TurnBetween(heading, dirBetween(cur, next))}
+		if w, ok = q.wait(cur, m, ready); !ok {
+			return 0, 0, 0, false
+		}
+	}
+	t := g + turn + w
+	return t, t + p.crossCost(next, t, q), w, true
+}
+
+// finalWait is the wait for green before entering pt, the end of a route,
+// with heading, for a vehicle ready to enter it at t seconds from now.
+func (p *PathFinder) finalWait(pt point, heading grid.Direction, t float64, q query) (float64, bool) {
+	if q.wait == nil {
+		return 0, true
+	}
+	return q.wait(pt, Movement{Approach: heading, Turn: grid.Straight}, t)
+}
+
+// timing is a path driven under a query.
+type timing struct {
+	ready []float64 // when each cell is ready to be entered, in seconds from now, before any wait at its signal
+	reach []float64 // when each cell has been crossed, its signal's wait only included for the last
+	waits []float64 // wait for green before entering each cell after the first
+	total float64   // cost of the whole path, as search reports it
+}
+
+// timePath drives path the way search does, and false if a move along it
+// is not allowed or waits forever for green.
+func (p *PathFinder) timePath(path []point, q query) (timing, bool) {
+	n := len(path)
+	tm := timing{ready: make([]float64, n), reach: make([]float64, n), waits: make([]float64, max(n-1, 0))}
+	if n == 0 {
+		return tm, true
+	}
+	tm.ready[0], tm.reach[0] = q.ready, q.at
+	heading := q.heading
+	for i := 1; i < n; i++ {
+		r, g, w, ok := p.advance(path[i-1], heading, tm.ready[i-1], tm.reach[i-1], i > 1 || q.midway, path[i], q)
+		if !ok {
+			return timing{}, false
+		}
+		if i > 1 {
+			tm.waits[i-2] = w
+		}
+		tm.ready[i], tm.reach[i] = r, g
+		heading = dirBetween(path[i-1], path[i])
+	}
+	if n > 1 {
+		w, ok := p.finalWait(path[n-1], heading, tm.ready[n-1], q)
+		if !ok {
+			return timing{}, false
+		}
+		tm.waits[n-2] = w
+		tm.reach[n-1] += w
+	}
+	tm.total = tm.reach[n-1] - q.at
+	return tm, true
+}
+
+// search runs A* and returns the path and its cost. With a weight above 1
+// the heuristic is inflated (weighted A*): fewer cells are expanded and the
+// path costs at most weight times the cheapest.
+func (p *PathFinder) search(sx, sy, gx, gy int, q query) ([]point, float64) {
+	start := state{point{sx, sy}, q.heading}
 	goal := point{gx,gy}
-	if !p.inBounds(sx,sy) || !p.inBounds(gx,gy) || p.isBlocked(gx,gy) { return nil }
-	if sx==gx && sy==gy { return []point{start} }
+	if !p.inBounds(sx, sy) || !p.inBounds(gx, gy) || p.isBlocked(gx, gy) {
+		return nil, 0
+	}
+	if sx == gx && sy == gy {
+		return []point{start.pt}, 0
+	}
+	weight := max(q.weight, 1)
+	heuristic := func(pt point) float64 { return weight * p.heuristic(pt, goal) }
 
-	came := make(map[point]point)
-	gscore := make(map[point]int)
-	gscore[start] = 0
+	// g and ready are in seconds from now, so searches starting later (q.at)
+	// see the signals and congestion of that time
+	came := make(map[state]state)
+	gscore := make(map[state]float64)
+	gscore[start] = q.at
+	ready := map[state]float64{start: q.ready}
 
 	open := &nodePQ{}
 	heap.Init(open)
-	heap.Push(open, &node{pt:start, g:0, h:manhattan(start,goal), f:manhattan(start,goal)})
-	inOpen := map[point]*node{start: (*open)[0]}
-	closed := make(map[point]bool)
-
-	neighbors := func(c point) []point {
-		cand := []point{{c.X+1,c.Y},{c.X-1,c.Y},{c.X,c.Y+1},{c.X,c.Y-1}}
-		out := make([]point,0,4)
-		for _, n := range cand {
-			if p.inBounds(n.X,n.Y) && !p.isBlocked(n.X,n.Y) { out = append(out, n) }
-		}
-		return out
-	}
+	h0 := heuristic(start.pt)
+	heap.Push(open, &node{st: start, g: q.at, h: h0, f: q.at + h0})
+	inOpen := map[state]*node{start: (*open)[0]}
+	closed := make(map[state]bool)
 
 	for open.Len()>0 {
 		cur := heap.Pop(open).(*node)
-		delete(inOpen, cur.pt)
-		if cur.pt == goal { // reconstruct
+		delete(inOpen, cur.st)
+		if q.expansions != nil {
+			if *q.expansions <= 0 {
+				return nil, 0
+			}
+			*q.expansions--
+		}
+		if cur.st.pt == goal { // reconstruct
 			var path []point
-			u := goal
+			u := cur.st
 			for u != start {
-				path = append(path, u)
+				path = append(path, u.pt)
 				u = came[u]
 			}
-			path = append(path, start)
+			path = append(path, start.pt)
 			// reverse
 			for i,j := 0, len(path)-1; i<j; i,j = i+1, j-1 { path[i],path[j] = path[j],path[i] }
-			return path
+			return path, cur.g - q.at
 		}
-		closed[cur.pt] = true
+		closed[cur.st] = true
 
-		for _, nb := range neighbors(cur.pt) {
-			if closed[nb] { continue }
-			tentative := cur.g + 1
+		for _, d := range grid.Directions {
+			dx, dy := d.Delta()
+			nb := state{point{cur.st.pt.X + dx, cur.st.pt.Y + dy}, d}
+			if closed[nb] || (q.avoid != nil && q.avoid(nb.pt)) {
+				continue
+			}
+			if q.forbid != nil && q.forbid(cur.st.pt, nb.pt) {
+				continue
+			}
+			r, tentative, _, ok := p.advance(cur.st.pt, cur.st.heading, ready[cur.st], cur.g, cur.st != start || q.midway, nb.pt, q)
+			if !ok {
+				continue
+			}
+			if nb.pt == goal {
+				w, ok := p.finalWait(goal, d, r, q)
+				if !ok {
+					continue
+				}
+				tentative += w
+			}
 			if g, ok := gscore[nb]; !ok || tentative < g {
-				came[nb] = cur.pt
+				came[nb] = cur.st
 				gscore[nb] = tentative
-				h := manhattan(nb, goal)
+				ready[nb] = r
+				h := heuristic(nb.pt)
 				if on, ok := inOpen[nb]; ok {
 					on.g = tentative; on.h=h; on.f = tentative + h
 					heap.Fix(open, on.idx)
 				} else {
-					n := &node{pt: nb, g: tentative, h: h, f: tentative + h}
+					n := &node{st: nb, g: tentative, h: h, f: tentative + h}
 					heap.Push(open, n)
 					inOpen[nb] = n
 				}
 			}
 		}
 	}
-	return nil
+	return nil, 0
 }
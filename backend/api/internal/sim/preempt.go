@@ -0,0 +1,181 @@
+package sim
+
+import (
+	grid "routeiq/internal/grid"
+)
+
+// Clearance times used when a signal is preempted, in seconds.
+const (
+	preemptYellow = 3
+	preemptAllRed = 2
+)
+
+type preemptStage int
+
+const (
+	preemptIdle preemptStage = iota
+	preemptEnterYellow
+	preemptEnterAllRed
+	preemptGreen
+	preemptExitYellow
+	preemptExitAllRed
+)
+
+// allMovements lists every movement through a four-way intersection.
+var allMovements = func() []Movement {
+	out := make([]Movement, 0, 16)
+	for _, d := range grid.Directions {
+		for _, t := range []grid.Turn{grid.Straight, grid.Right, grid.Left, grid.UTurn} {
+			out = append(out, Movement{Approach: d, Turn: t})
+		}
+	}
+	return out
+}()
+
+// preemptible wraps a controller so an approaching emergency vehicle can
+// take over the signal: conflicting movements are cleared through yellow and
+// all-red, the phase serving the emergency movement is held green while
+// requests keep arriving, then control returns to the wrapped controller
+// after another clearance. The wrapped controller keeps running throughout
+// so fixed-time coordination resumes in step.
+type preemptible struct {
+	Controller
+	stage   preemptStage
+	elapsed int
+	target  map[Movement]bool // movements of the phase held green
+	was     map[Movement]bool // movements showing green or yellow when clearance began
+	request *Movement         // movement requested since the last Step
+}
+
+func newPreemptible(c Controller) *preemptible {
+	if p, ok := c.(*preemptible); ok {
+		return p
+	}
+	return &preemptible{Controller: c}
+}
+
+// Request asks for green for movement m before the next Step. Requests must
+// be repeated every tick to hold the preemption.
+func (p *preemptible) Request(m Movement) {
+	if p.request == nil {
+		p.request = &m
+	}
+}
+
+// Preempted reports whether the signal is currently overridden.
+func (p *preemptible) Preempted() bool { return p.stage != preemptIdle }
+
+// phaseServing returns the green movements of the first phase serving m,
+// falling back to the phase serving m's approach straight through.
+func (p *preemptible) phaseServing(m Movement) map[Movement]bool {
+	phases := p.Plan().Phases
+	for _, want := range []Movement{m, {Approach: m.Approach, Turn: grid.Straight}} {
+		for _, ph := range phases {
+			for _, g := range ph.Green {
+				if g == want {
+					set := make(map[Movement]bool, len(ph.Green))
+					for _, x := range ph.Green {
+						set[x] = true
+					}
+					return set
+				}
+			}
+		}
+	}
+	return map[Movement]bool{m: true}
+}
+
+// greenWait forecasts the wrapped controller, ignoring any preemption in
+// progress; controllers that cannot forecast never hold anyone up.
+func (p *preemptible) greenWait(after int, m Movement) (int, bool) {
+	if f, ok := p.Controller.(forecaster); ok {
+		return f.greenWait(after, m)
+	}
+	return 0, true
+}
+
+func (p *preemptible) Step(q Queues) {
+	p.Controller.Step(q)
+	req := p.request
+	p.request = nil
+	p.elapsed++
+	switch p.stage {
+	case preemptIdle:
+		if req == nil {
+			return
+		}
+		p.target = p.phaseServing(*req)
+		p.was = make(map[Movement]bool)
+		conflict := false
+		for _, m := range allMovements {
+			if p.Controller.StateFor(m) != "red" {
+				p.was[m] = true
+				conflict = conflict || !p.target[m]
+			}
+		}
+		p.elapsed = 0
+		if conflict {
+			p.stage = preemptEnterYellow
+		} else {
+			p.stage = preemptGreen
+		}
+	case preemptEnterYellow:
+		if p.elapsed >= preemptYellow {
+			p.stage, p.elapsed = preemptEnterAllRed, 0
+		}
+	case preemptEnterAllRed:
+		if p.elapsed >= preemptAllRed {
+			p.stage, p.elapsed = preemptGreen, 0
+		}
+	case preemptGreen:
+		if req == nil {
+			p.stage, p.elapsed = preemptExitYellow, 0
+		}
+	case preemptExitYellow:
+		if p.elapsed >= preemptYellow {
+			p.stage, p.elapsed = preemptExitAllRed, 0
+		}
+	case preemptExitAllRed:
+		if p.elapsed >= preemptAllRed {
+			p.stage, p.elapsed = preemptIdle, 0
+		}
+	}
+}
+
+func (p *preemptible) StateFor(m Movement) string {
+	inner := p.Controller.StateFor(m)
+	switch p.stage {
+	case preemptEnterYellow:
+		if p.was[m] && !p.target[m] {
+			return "yellow"
+		}
+		if p.was[m] && p.target[m] {
+			return inner
+		}
+		return "red"
+	case preemptEnterAllRed:
+		if p.was[m] && p.target[m] {
+			return inner
+		}
+		return "red"
+	case preemptGreen:
+		if p.target[m] {
+			return "green"
+		}
+		return "red"
+	case preemptExitYellow:
+		if p.target[m] && inner != "green" {
+			return "yellow"
+		}
+		if p.target[m] {
+			return "green"
+		}
+		return "red"
+	case preemptExitAllRed:
+		if p.target[m] && inner == "green" {
+			return "green"
+		}
+		return "red"
+	}
+	return inner
+}
@@ -0,0 +1,108 @@
+package sim
+
+import (
+	"time"
+)
+
+// profileMinTicks is how many ticks of an hour the engine must have seen
+// before the congestion it learned for that hour replaces the configured
+// prediction: five simulated minutes.
+const profileMinTicks = 300
+
+// profileBlendSeconds is how far ahead timed route queries move from live
+// congestion to the profiles: conditions now say little about an hour from
+// now, but a lot about the next few minutes.
+const profileBlendSeconds = 900
+
+// congestionProfiles predicts each cell's congestion multiplier by time of
+// day. The simulation teaches it as it runs: every tick adds each cell's
+// congestion to the hour nearest the time of day, so an hour's prediction is
+// the mean multiplier seen around that hour, and Profile.At interpolates
+// between hours. Until an hour has profileMinTicks of history the configured
+// prediction, or free flow, stands in.
+type congestionProfiles struct {
+	prior  map[[2]int]Profile
+	excess map[[2]int]*[24]float64 // multiplier above 1, summed per hour
+	ticks  [24]int64               // ticks seen per hour
+}
+
+func newCongestionProfiles(prior map[[2]int]Profile) *congestionProfiles {
+	c := &congestionProfiles{prior: make(map[[2]int]Profile, len(prior)), excess: make(map[[2]int]*[24]float64)}
+	for k, p := range prior {
+		for h := range p {
+			p[h] = max(p[h], 1) // congestion never speeds traffic up
+		}
+		c.prior[k] = p
+	}
+	return c
+}
+
+// nearestHour returns the hour of the day whose start is closest to tod.
+func nearestHour(tod time.Duration) int {
+	return int((tod+30*time.Minute)/time.Hour) % 24
+}
+
+// observe records one tick at time of day tod. congestion holds the
+// multiplier of every congested cell; the rest are at 1.
+func (c *congestionProfiles) observe(tod time.Duration, congestion map[[2]int]float64) {
+	h := nearestHour(tod)
+	c.ticks[h]++
+	for k, m := range congestion {
+		if m <= 1 {
+			continue
+		}
+		ex := c.excess[k]
+		if ex == nil {
+			ex = new([24]float64)
+			c.excess[k] = ex
+		}
+		ex[h] += m - 1
+	}
+}
+
+// hour returns the predicted multiplier of cell k for hour h.
+func (c *congestionProfiles) hour(k [2]int, h int) float64 {
+	if c.ticks[h] >= profileMinTicks {
+		if ex := c.excess[k]; ex != nil {
+			return 1 + ex[h]/float64(c.ticks[h])
+		}
+		return 1
+	}
+	if p, ok := c.prior[k]; ok {
+		return p[h]
+	}
+	return 1
+}
+
+// profile returns the predicted multipliers of cell k and the ticks of
+// history behind each hour; hours without enough history fall back to the
+// configured prediction, or 1.
+func (c *congestionProfiles) profile(k [2]int) (Profile, [24]int64) {
+	var p Profile
+	for h := range p {
+		p[h] = c.hour(k, h)
+	}
+	return p, c.ticks
+}
+
+// at returns the predicted multiplier of cell k at time of day tod,
+// interpolated between hours as Profile.At does.
+func (c *congestionProfiles) at(k [2]int, tod time.Duration) float64 {
+	h := float64(tod%(24*time.Hour)) / float64(time.Hour)
+	i := int(h)
+	frac := h - float64(i)
+	return c.hour(k, i)*(1-frac) + c.hour(k, (i+1)%24)*frac
+}
+
+// CongestionProfile returns the predicted congestion multiplier of (x,y) for
+// each hour of the day, and how many ticks of the simulation each hour's
+// prediction has been learned from so far. ok is false outside the grid.
+func (e *Engine) CongestionProfile(x, y int) (p Profile, ticks [24]int64, ok bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if !e.grid.IsValid(x, y) {
+		return Profile{}, ticks, false
+	}
+	p, ticks = e.profiles.profile([2]int{x, y})
+	return p, ticks, true
+}
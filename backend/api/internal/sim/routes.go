@@ -0,0 +1,283 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// MaxRouteWeight bounds RouteOptions.Weight.
+const MaxRouteWeight = 10
+
+var (
+	// ErrInvalidPoint is wrapped by a PointError.
+	ErrInvalidPoint = errors.New("invalid route point")
+	// ErrNoRoute means no road leads from the origin to the destination.
+	ErrNoRoute = errors.New("no route")
+	// ErrTooLate means an arrive-by route cannot make it even leaving now.
+	ErrTooLate = errors.New("cannot arrive in time")
+)
+
+// PointError describes a route origin or destination that cannot be used.
+type PointError struct {
+	Field   string // "origin" or "destination", or "origins[i]" or "destinations[i]" in a matrix
+	X, Y    int
+	Outside bool // outside the grid; otherwise the cell is blocked
+	Reason  string
+}
+
+func (e *PointError) Error() string {
+	return fmt.Sprintf("%s (%d,%d) %s", e.Field, e.X, e.Y, e.Reason)
+}
+
+func (e *PointError) Unwrap() error { return ErrInvalidPoint }
+
+// RouteOptions tune a route query.
+type RouteOptions struct {
+	// AvoidIncidents routes around cells with an active incident altogether
+	// instead of only pricing in their delay. The origin and destination
+	// may still hold one.
+	AvoidIncidents bool
+	// Weight inflates the A* heuristic: 1 (or 0) finds the cheapest route,
+	// larger values up to MaxRouteWeight search fewer cells and return a
+	// route costing at most Weight times the cheapest.
+	Weight float64
+	// IgnoreSignals plans as if every light were green, as routes did
+	// before signal waits were predicted.
+	IgnoreSignals bool
+	// DepartAt plans for leaving at this time of day instead of now, and
+	// ArriveBy for arriving by it, leaving as late as possible; at most one
+	// may be set. Either means the next time the simulated clock shows it,
+	// so up to a day ahead.
+	DepartAt *time.Duration
+	ArriveBy *time.Duration
+}
+
+// Validate checks the options.
+func (o RouteOptions) Validate() error {
+	if o.Weight != 0 && (o.Weight < 1 || o.Weight > MaxRouteWeight) {
+		return fmt.Errorf("weight must be between 1 and %d, got %g", MaxRouteWeight, o.Weight)
+	}
+	if o.DepartAt != nil && o.ArriveBy != nil {
+		return errors.New("set a departure or an arrival time, not both")
+	}
+	for _, t := range []*time.Duration{o.DepartAt, o.ArriveBy} {
+		if t != nil && (*t < 0 || *t >= 24*time.Hour) {
+			return fmt.Errorf("time of day %v outside 00:00-24:00", *t)
+		}
+	}
+	return nil
+}
+
+// Route is a planned journey over the grid.
+type Route struct {
+	Path        [][2]int      // cells from origin to destination, inclusive
+	Distance    int           // cells moved
+	ETASeconds  float64       // time to drive the path under current conditions, waits included
+	Multipliers []float64     // congestion and incident multiplier of each cell entered
+	SignalWaits []float64     // predicted wait for green before entering each cell
+	WaitSeconds float64       // sum of SignalWaits
+	Depart      time.Duration // time of day the vehicle leaves
+	Arrive      time.Duration // time of day it arrives
+}
+
+// Route plans the cheapest route from (sx,sy) to (gx,gy) under the current
+// congestion, incidents, closures, one-way streets and turn rules, as a
+// vehicle starting there now would. The ETA is the sum of each entered
+// cell's crossing time at its speed limit times its multiplier, plus the
+// predicted wait at each signal along the way.
+//
+// The search is time-dependent: it tracks when the vehicle reaches each
+// cell and asks the signal there how long the movement it makes will wait
+// for green at that moment, so a route through better-timed lights can beat
+// a shorter one. Fixed-time signals are forecast exactly from their plan and
+// cycle time; adaptive controllers are forecast from their current phase
+// as if they ran their nominal plan from there.
+//
+// Leaving now, congestion is taken as it is. For a later departure
+// (RouteOptions.DepartAt) each cell's congestion is predicted from its
+// time-of-day profile (see CongestionProfile) for when the vehicle gets
+// there, moving from the live value to the profile over the first
+// profileBlendSeconds. Incidents and closures are assumed to last.
+//
+// An arrive-by route (RouteOptions.ArriveBy) works back from the deadline to
+// the latest departure that still makes it, and returns the route for that
+// departure, or ErrTooLate if leaving now is already too late.
+func (e *Engine) Route(sx, sy, gx, gy int, opts RouteOptions) (Route, error) {
+	if err := opts.Validate(); err != nil {
+		return Route{}, err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	q, path, err := e.planLocked(sx, sy, gx, gy, opts)
+	if err != nil {
+		return Route{}, err
+	}
+	return e.routeAlongLocked(path, q), nil
+}
+
+// planLocked sets up the search for a route query and finds its best path.
+func (e *Engine) planLocked(sx, sy, gx, gy int, opts RouteOptions) (query, []point, error) {
+	q, err := e.routeQueryLocked(sx, sy, gx, gy, opts)
+	if err != nil {
+		return query{}, nil, err
+	}
+	if opts.ArriveBy != nil {
+		return e.latestDepartureLocked(sx, sy, gx, gy, q, e.secondsUntilLocked(*opts.ArriveBy))
+	}
+	if opts.DepartAt != nil {
+		e.departLocked(&q, e.secondsUntilLocked(*opts.DepartAt))
+	}
+	path, _ := e.pf.search(sx, sy, gx, gy, q)
+	if path == nil {
+		return query{}, nil, fmt.Errorf("%w from (%d,%d) to (%d,%d)", ErrNoRoute, sx, sy, gx, gy)
+	}
+	return q, path, nil
+}
+
+// secondsUntilLocked returns the whole seconds until the simulated clock next
+// shows time of day tod; zero if it shows it now.
+func (e *Engine) secondsUntilLocked(tod time.Duration) float64 {
+	day := 24 * time.Hour
+	d := (tod.Truncate(time.Second) - e.timeOfDayLocked()) % day
+	if d < 0 {
+		d += day
+	}
+	return d.Seconds()
+}
+
+// departLocked sets q up for leaving lead seconds from now, with congestion
+// predicted for the time each cell is reached.
+func (e *Engine) departLocked(q *query, lead float64) {
+	q.at, q.ready = lead, lead
+	now := e.timeOfDayLocked()
+	q.congestion = func(pt point, t float64) float64 {
+		k := [2]int{pt.X, pt.Y}
+		m := e.profiles.at(k, now+time.Duration(t*float64(time.Second)))
+		if t < profileBlendSeconds {
+			live := e.pf.Congestion(pt.X, pt.Y)
+			m = live + (m-live)*t/profileBlendSeconds
+		}
+		return m * e.pf.IncidentPenalty(pt.X, pt.Y)
+	}
+}
+
+// latestDepartureLocked finds the latest departure, in whole seconds from
+// now, whose route arrives within deadline seconds, and returns its query
+// and path. Leaving later is assumed never to arrive earlier, which holds
+// for signal waits and for congestion that changes over minutes rather than
+// seconds, so the departure is found by bisection: first the departure that
+// would make it if the trip took as long as it does now, then halving the
+// interval between the latest departure known to make it and the deadline
+// less the free-flow time, which no departure can beat.
+func (e *Engine) latestDepartureLocked(sx, sy, gx, gy int, base query, deadline float64) (query, []point, error) {
+	probe := func(lead float64) (query, []point, float64) {
+		q := base
+		e.departLocked(&q, lead)
+		path, cost := e.pf.search(sx, sy, gx, gy, q)
+		return q, path, lead + cost
+	}
+	q, path, arrive := probe(0)
+	if path == nil {
+		return query{}, nil, fmt.Errorf("%w from (%d,%d) to (%d,%d)", ErrNoRoute, sx, sy, gx, gy)
+	}
+	if arrive > deadline {
+		return query{}, nil, fmt.Errorf("%w: leaving now arrives %.0f s after the deadline", ErrTooLate, arrive-deadline)
+	}
+	lo, hi := 0.0, deadline
+	if ff, ok := e.pf.FreeFlowCost(sx, sy, base.heading, gx, gy); ok {
+		hi = math.Floor(deadline - ff)
+	}
+	try := func(lead float64) {
+		if lq, lp, la := probe(lead); lp != nil && la <= deadline {
+			lo, q, path = lead, lq, lp
+		} else {
+			hi = lead - 1
+		}
+	}
+	if guess := math.Floor(deadline - arrive); guess > lo && guess <= hi {
+		try(guess)
+	}
+	for lo < hi {
+		try(math.Ceil((lo + hi) / 2))
+	}
+	return q, path, nil
+}
+
+// routeQueryLocked checks the ends of a route and sets up its search.
+func (e *Engine) routeQueryLocked(sx, sy, gx, gy int, opts RouteOptions) (query, error) {
+	if err := e.checkPointLocked("origin", sx, sy); err != nil {
+		return query{}, err
+	}
+	if err := e.checkPointLocked("destination", gx, gy); err != nil {
+		return query{}, err
+	}
+	q := query{weight: opts.Weight}
+	if !opts.IgnoreSignals {
+		q.wait = e.signalWaitLocked
+	}
+	if opts.AvoidIncidents {
+		start, goal := point{sx, sy}, point{gx, gy}
+		q.avoid = func(pt point) bool {
+			return pt != start && pt != goal && e.pf.IncidentPenalty(pt.X, pt.Y) > 1
+		}
+	}
+	return q, nil
+}
+
+// checkPointLocked returns a PointError if (x,y) cannot start or end a route.
+func (e *Engine) checkPointLocked(field string, x, y int) error {
+	switch {
+	case !e.grid.IsValid(x, y):
+		return &PointError{Field: field, X: x, Y: y, Outside: true,
+			Reason: fmt.Sprintf("is outside the %dx%d grid", e.grid.Width, e.grid.Height)}
+	case e.pf.isBlocked(x, y):
+		return &PointError{Field: field, X: x, Y: y, Reason: "is blocked"}
+	}
+	return nil
+}
+
+// signalWaitLocked is a query's wait: the seconds the signal at pt, if any,
+// holds movement m for a vehicle ready to enter t seconds from now. The
+// vehicle enters in the first tick after that, so the signal is forecast
+// for it.
+func (e *Engine) signalWaitLocked(pt point, m Movement, t float64) (float64, bool) {
+	c, ok := e.signals[[2]int{pt.X, pt.Y}]
+	if !ok {
+		return 0, true
+	}
+	w, ok := c.greenWait(int(t)+1, m)
+	return float64(w), ok
+}
+
+// routeAlongLocked measures a path found by q under the conditions q
+// expects along it.
+func (e *Engine) routeAlongLocked(path []point, q query) Route {
+	r := Route{
+		Path:        make([][2]int, len(path)),
+		Distance:    len(path) - 1,
+		Multipliers: make([]float64, 0, len(path)-1),
+		SignalWaits: make([]float64, len(path)-1),
+	}
+	tm, ok := e.pf.timePath(path, q)
+	if ok {
+		r.SignalWaits = tm.waits
+	}
+	for i, pt := range path {
+		r.Path[i] = [2]int{pt.X, pt.Y}
+		if i > 0 {
+			m := e.pf.Multiplier(pt.X, pt.Y)
+			if q.congestion != nil && ok {
+				m = q.congestion(pt, tm.ready[i])
+			}
+			r.Multipliers = append(r.Multipliers, m)
+			r.WaitSeconds += r.SignalWaits[i-1]
+			r.ETASeconds += e.pf.BaseCost(pt.X, pt.Y)*m + r.SignalWaits[i-1]
+		}
+	}
+	day := 24 * time.Hour
+	r.Depart = (e.timeOfDayLocked() + time.Duration(q.at)*time.Second) % day
+	r.Arrive = (r.Depart + time.Duration(math.Round(r.ETASeconds))*time.Second) % day
+	return r
+}
@@ -0,0 +1,208 @@
+package sim
+
+import (
+	grid "routeiq/internal/grid"
+)
+
+// Movement is a path through an intersection: the heading a vehicle travels
+// as it enters (its approach, e.g. North for northbound traffic) and the turn
+// it makes there.
+type Movement struct {
+	Approach grid.Direction
+	Turn     grid.Turn
+}
+
+// Phase gives right of way to a set of movements for GreenSec seconds,
+// followed by YellowSec of yellow and AllRedSec of all-red clearance.
+type Phase struct {
+	Name      string
+	Green     []Movement
+	GreenSec  int
+	YellowSec int
+	AllRedSec int
+}
+
+// Duration returns the phase length including yellow and all-red.
+func (p Phase) Duration() int { return p.GreenSec + p.YellowSec + p.AllRedSec }
+
+// SignalPlan is a fixed-time signal plan: phases run in order and repeat.
+// Offset shifts the cycle so that at time zero the plan is Offset seconds in,
+// which is how neighbouring signals are coordinated.
+type SignalPlan struct {
+	Phases []Phase
+	Offset int
+}
+
+// CycleLength returns the total length of one cycle in seconds.
+func (p SignalPlan) CycleLength() int {
+	total := 0
+	for _, ph := range p.Phases {
+		total += ph.Duration()
+	}
+	return total
+}
+
+// movementsFor lists through, right and optionally left/U-turn movements for
+// the given approaches.
+func movementsFor(turns []grid.Turn, approaches ...grid.Direction) []Movement {
+	out := make([]Movement, 0, len(turns)*len(approaches))
+	for _, a := range approaches {
+		for _, t := range turns {
+			out = append(out, Movement{Approach: a, Turn: t})
+		}
+	}
+	return out
+}
+
+// DefaultSignalPlan returns a 60 second four-phase plan: north-south
+// through and right, north-south protected left, then the same for east-west.
+func DefaultSignalPlan() SignalPlan {
+	through := []grid.Turn{grid.Straight, grid.Right}
+	left := []grid.Turn{grid.Left, grid.UTurn}
+	return SignalPlan{Phases: []Phase{
+		{Name: "ns-through", Green: movementsFor(through, grid.North, grid.South), GreenSec: 19, YellowSec: 3, AllRedSec: 2},
+		{Name: "ns-left", Green: movementsFor(left, grid.North, grid.South), GreenSec: 4, YellowSec: 1, AllRedSec: 1},
+		{Name: "ew-through", Green: movementsFor(through, grid.East, grid.West), GreenSec: 19, YellowSec: 3, AllRedSec: 2},
+		{Name: "ew-left", Green: movementsFor(left, grid.East, grid.West), GreenSec: 4, YellowSec: 1, AllRedSec: 1},
+	}}
+}
+
+// Stage names within a phase.
+const (
+	StageGreen  = "green"
+	StageYellow = "yellow"
+	StageAllRed = "all-red"
+)
+
+// Signal runs a fixed-time SignalPlan at one intersection.
+type Signal struct {
+	plan   SignalPlan
+	greens []map[Movement]bool // per phase
+	cycle  int
+	t      int // seconds into the cycle
+}
+
+// NewSignal starts plan at its offset. A plan without phases falls back to
+// DefaultSignalPlan, and phases get at least one second of green.
+func NewSignal(plan SignalPlan) *Signal {
+	if len(plan.Phases) == 0 {
+		plan.Phases = DefaultSignalPlan().Phases
+	}
+	phases := make([]Phase, len(plan.Phases))
+	copy(phases, plan.Phases)
+	plan.Phases = phases
+	s := &Signal{plan: plan}
+	for i := range plan.Phases {
+		ph := &plan.Phases[i]
+		ph.GreenSec = max(ph.GreenSec, 1)
+		ph.YellowSec = max(ph.YellowSec, 0)
+		ph.AllRedSec = max(ph.AllRedSec, 0)
+		set := make(map[Movement]bool, len(ph.Green))
+		for _, m := range ph.Green {
+			set[m] = true
+		}
+		s.greens = append(s.greens, set)
+	}
+	s.cycle = plan.CycleLength()
+	s.t = mod(plan.Offset, s.cycle)
+	return s
+}
+
+func mod(a, b int) int { return ((a % b) + b) % b }
+
+// Tick advances the signal by one second.
+func (s *Signal) Tick() { s.t = (s.t + 1) % s.cycle }
+
+// Plan returns the plan the signal is running.
+func (s *Signal) Plan() SignalPlan { return s.plan }
+
+// CycleTime returns the number of seconds into the current cycle.
+func (s *Signal) CycleTime() int { return s.t }
+
+// position resolves a cycle time into phase index, stage and seconds elapsed in that stage.
+func (s *Signal) position(t int) (int, string, int) {
+	for i, ph := range s.plan.Phases {
+		switch {
+		case t < ph.GreenSec:
+			return i, StageGreen, t
+		case t < ph.GreenSec+ph.YellowSec:
+			return i, StageYellow, t - ph.GreenSec
+		case t < ph.Duration():
+			return i, StageAllRed, t - ph.GreenSec - ph.YellowSec
+		}
+		t -= ph.Duration()
+	}
+	return 0, StageGreen, 0 // unreachable: t < cycle
+}
+
+// Phase returns the current phase.
+func (s *Signal) Phase() Phase {
+	i, _, _ := s.position(s.t)
+	return s.plan.Phases[i]
+}
+
+// Stage returns the stage within the current phase and the seconds spent in it.
+func (s *Signal) Stage() (string, int) {
+	_, st, el := s.position(s.t)
+	return st, el
+}
+
+// StateFor returns the light ("green", "yellow" or "red") shown to movement m.
+func (s *Signal) StateFor(m Movement) string {
+	return s.stateAt(s.t, m)
+}
+
+func (s *Signal) stateAt(t int, m Movement) string {
+	i, st, _ := s.position(t)
+	if !s.greens[i][m] {
+		return "red"
+	}
+	if st == StageAllRed {
+		return "red"
+	}
+	return st
+}
+
+// greenWait returns how many seconds a vehicle reaching the signal in the
+// tick after seconds from now waits for green for movement m, and false if
+// no phase ever gives m green. Yellow counts as a wait: vehicles stop for it.
+func (s *Signal) greenWait(after int, m Movement) (int, bool) {
+	t := mod(s.t+after, s.cycle)
+	for w := 0; w < s.cycle; w++ {
+		if s.stateAt((t+w)%s.cycle, m) == StageGreen {
+			return w, true
+		}
+	}
+	return 0, false
+}
+
+// forecaster is implemented by controllers that can predict their lights.
+type forecaster interface {
+	greenWait(after int, m Movement) (int, bool)
+}
+
+// Signals reports the light a vehicle sees when making movement m through the
+// intersection at (x,y). ok is false where there is no signal.
+type Signals interface {
+	SignalFor(x, y int, m Movement) (state string, ok bool)
+}
+
+// UniformLights shows one light state to every movement at each intersection.
+type UniformLights map[[2]int]string
+
+func (u UniformLights) SignalFor(x, y int, _ Movement) (string, bool) {
+	st, ok := u[[2]int{x, y}]
+	return st, ok
+}
+
+// controllerSet maps intersection coordinates to their signal controllers,
+// each wrapped so emergency vehicles can preempt it.
+type controllerSet map[[2]int]*preemptible
+
+func (s controllerSet) SignalFor(x, y int, m Movement) (string, bool) {
+	c, ok := s[[2]int{x, y}]
+	if !ok {
+		return "", false
+	}
+	return c.StateFor(m), true
+}
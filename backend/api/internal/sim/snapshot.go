@@ -0,0 +1,834 @@
+package sim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	grid "routeiq/internal/grid"
+)
+
+// SnapshotVersion is the snapshot format written by Engine.Snapshot. Snapshots
+// of any other version are rejected, so it goes up with every change to the
+// format. Version 2 added the scenario's run length and incident schedule,
+// and the congestion profiles.
+const SnapshotVersion = 2
+
+// ErrSnapshotVersion is returned for snapshots written in another format version.
+var ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+// Snapshot is the complete state of an engine between two ticks: its layout
+// and configuration, every signal, vehicle and incident, the statistics so far
+// and the position of each random stream. An engine restored from a snapshot
+// steps exactly as the engine it was taken from would have. Cells are [x, y]
+// pairs; WriteSnapshot and ReadSnapshot encode it as JSON.
+type Snapshot struct {
+	Version      int
+	Seed         uint64
+	Tick         int64
+	StartTime    time.Duration
+	TickInterval time.Duration
+	Duration     time.Duration
+
+	Width, Height int
+	Intersections []grid.Intersection
+	Blocked       [][2]int // blocked by configuration rather than by incidents
+	Routing       RoutingSnapshot
+	Occupancy     OccupancySnapshot
+	Kinematics    Kinematics
+
+	Signals      []SignalSnapshot
+	Coordination *CoordinationPlan
+	Vehicles     []VehicleSnapshot // in insertion order
+	Incidents    []Incident        // in report order, resolved ones included
+	Schedule     []ScheduledIncident
+	Scheduled    int              // schedule entries already raised
+	ScheduleEnds map[string]int64 // raised scheduled incident ID -> tick it resolves
+
+	Stats      Stats
+	Trips      []Trip
+	TripTotals TripTotalsSnapshot
+	Responses  []EmergencyResponse
+	Stalled    []CellCount
+	Congested  [][2]int
+	Profiles   ProfilesSnapshot
+	Flows      []FlowCount
+	FlowSince  int64
+
+	VehicleRNG []byte // ChaCha8 state of the vehicle stream
+	Generator  *GeneratorSnapshot
+	Demand     *DemandSnapshot
+}
+
+// CellValue is a per-cell number: a cost, multiplier or speed limit.
+type CellValue struct {
+	X, Y  int
+	Value float64
+}
+
+// CellCount is a per-cell count.
+type CellCount struct {
+	X, Y  int
+	Count int
+}
+
+// RoutingSnapshot is the PathFinder's state. Blocked includes cells blocked by incidents.
+type RoutingSnapshot struct {
+	Blocked     [][2]int
+	ClosedEdges [][4]int
+	BaseCost    []CellValue
+	MinBaseCost float64
+	SpeedLimit  []CellValue
+	Congestion  []CellValue
+	Penalty     []CellValue
+	Version     uint64
+}
+
+// OccupancySnapshot is the cell capacities and the arrival counter that orders queues.
+type OccupancySnapshot struct {
+	Default    CellCapacity
+	Capacity   []CellCapacityAt
+	StuckAfter int
+	Seq        uint64
+}
+
+// CellCapacityAt is the capacity of one cell.
+type CellCapacityAt struct {
+	X, Y int
+	CellCapacity
+}
+
+// SignalSnapshot is the state of the controller at one intersection. Fixed-time
+// signals only use CycleTime; adaptive ones use Phase, Next, Stage, Elapsed and
+// their own config.
+type SignalSnapshot struct {
+	X, Y      int
+	Control   string // ControlFixed, ControlActuated or ControlMaxPressure
+	Plan      SignalPlan
+	CycleTime int
+	Phase     int
+	Next      int
+	Stage     string
+	Elapsed   int
+	Gap       int // actuated: seconds of extension left
+
+	Actuated    *ActuatedConfig    `json:",omitempty"`
+	MaxPressure *MaxPressureConfig `json:",omitempty"`
+	Preemption  PreemptionSnapshot
+}
+
+// PreemptionSnapshot is the emergency preemption state of a signal.
+type PreemptionSnapshot struct {
+	Stage   int
+	Elapsed int
+	Target  []Movement
+	Was     []Movement
+}
+
+// VehicleSnapshot is a vehicle with its planned route and journey so far.
+type VehicleSnapshot struct {
+	Vehicle
+	Route        [][2]int
+	RouteIdx     int
+	RouteDest    [2]int
+	RouteVersion uint64
+	Planned      bool
+	Dwell        int
+	Blocked      int
+	Arrival      uint64
+	Progress     float64
+	Trip         TripProgress
+}
+
+// TripProgress is the journey of a vehicle still on the road.
+type TripProgress struct {
+	Started  bool
+	Origin   [2]int
+	FreeFlow float64
+	Distance int
+	Stops    int
+	Moving   bool
+}
+
+// TripTotalsSnapshot accumulates every completed trip, beyond the recent ones kept.
+type TripTotalsSnapshot struct {
+	Completed int64
+	Travel    int64
+	FreeFlow  float64
+	Delay     float64
+	Stops     int64
+	Distance  int64
+}
+
+// FlowCount is the vehicles counted through a signal by movement.
+type FlowCount struct {
+	X, Y     int
+	Movement Movement
+	Count    int
+}
+
+// ProfilesSnapshot is the congestion profiles: the configured predictions,
+// the congestion above 1 summed per hour for each cell seen congested, and
+// the ticks seen per hour.
+type ProfilesSnapshot struct {
+	Prior  []CellProfile
+	Excess []CellProfile
+	Ticks  [24]int64
+}
+
+// CellProfile is one cell's values by hour.
+type CellProfile struct {
+	X, Y    int
+	Profile Profile
+}
+
+// GeneratorSnapshot is the state of the random incident generator.
+type GeneratorSnapshot struct {
+	Config   IncidentGeneratorConfig // without Hotspots, which are listed separately
+	Hotspots []CellValue
+	RNG      []byte
+	Expires  map[string]int64
+}
+
+// DemandSnapshot is the state of the demand model.
+type DemandSnapshot struct {
+	Model DemandModel
+	RNG   []byte
+}
+
+// WriteSnapshot encodes s as indented JSON.
+func WriteSnapshot(w io.Writer, s *Snapshot) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// ReadSnapshot decodes a snapshot written by WriteSnapshot. It returns an
+// error wrapping ErrSnapshotVersion if the snapshot has another version.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+	if s.Version != SnapshotVersion {
+		return nil, fmt.Errorf("%w %d, want %d", ErrSnapshotVersion, s.Version, SnapshotVersion)
+	}
+	return &s, nil
+}
+
+// Snapshot captures the engine's state between ticks. It fails if a signal
+// runs a controller installed with SetController that is not one of the
+// package's own.
+func (e *Engine) Snapshot() (*Snapshot, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	s := &Snapshot{
+		Version:       SnapshotVersion,
+		Seed:          e.Seed(),
+		Tick:          e.ticks,
+		StartTime:     e.start,
+		TickInterval:  e.interval,
+		Duration:      e.duration,
+		Width:         e.grid.Width,
+		Height:        e.grid.Height,
+		Intersections: e.grid.Intersections(),
+		Blocked:       sortedCells(e.baseBlocked),
+		Routing:       e.pf.snapshot(),
+		Kinematics:    e.kinematics,
+		Incidents:     e.incidents.List(true),
+		Schedule:      append([]ScheduledIncident(nil), e.schedule...),
+		Scheduled:     e.scheduled,
+		Stats:         e.stats,
+		Trips:         append([]Trip(nil), e.trips...),
+		Responses:     append([]EmergencyResponse(nil), e.responses...),
+		Congested:     sortedCells(e.congested),
+		FlowSince:     e.flowSince,
+		TripTotals: TripTotalsSnapshot{
+			Completed: e.tripTotals.completed, Travel: e.tripTotals.travel, FreeFlow: e.tripTotals.freeFlow,
+			Delay: e.tripTotals.delay, Stops: e.tripTotals.stops, Distance: e.tripTotals.distance,
+		},
+		Occupancy: OccupancySnapshot{Default: e.occ.Default, StuckAfter: e.occ.StuckAfter, Seq: e.occ.seq},
+	}
+	if e.coordination != nil {
+		cp := *e.coordination
+		s.Coordination = &cp
+	}
+	if len(e.scheduleEnds) > 0 {
+		s.ScheduleEnds = make(map[string]int64, len(e.scheduleEnds))
+		for id, t := range e.scheduleEnds {
+			s.ScheduleEnds[id] = t
+		}
+	}
+	for k, c := range e.occ.caps {
+		s.Occupancy.Capacity = append(s.Occupancy.Capacity, CellCapacityAt{X: k[0], Y: k[1], CellCapacity: c})
+	}
+	sort.Slice(s.Occupancy.Capacity, func(i, j int) bool {
+		a, b := s.Occupancy.Capacity[i], s.Occupancy.Capacity[j]
+		return a.Y < b.Y || a.Y == b.Y && a.X < b.X
+	})
+	for k, n := range e.stalled {
+		s.Stalled = append(s.Stalled, CellCount{X: k[0], Y: k[1], Count: n})
+	}
+	sort.Slice(s.Stalled, func(i, j int) bool {
+		a, b := s.Stalled[i], s.Stalled[j]
+		return a.Y < b.Y || a.Y == b.Y && a.X < b.X
+	})
+	for k, byMove := range e.flows {
+		for m, n := range byMove {
+			s.Flows = append(s.Flows, FlowCount{X: k[0], Y: k[1], Movement: m, Count: n})
+		}
+	}
+	sort.Slice(s.Flows, func(i, j int) bool {
+		a, b := s.Flows[i], s.Flows[j]
+		if a.X != b.X || a.Y != b.Y {
+			return a.Y < b.Y || a.Y == b.Y && a.X < b.X
+		}
+		return movementLess(a.Movement, b.Movement)
+	})
+
+	for k, c := range e.signals {
+		ss, err := snapshotSignal(c)
+		if err != nil {
+			return nil, fmt.Errorf("signal at (%d,%d): %w", k[0], k[1], err)
+		}
+		ss.X, ss.Y = k[0], k[1]
+		s.Signals = append(s.Signals, ss)
+	}
+	sort.Slice(s.Signals, func(i, j int) bool {
+		a, b := s.Signals[i], s.Signals[j]
+		return a.Y < b.Y || a.Y == b.Y && a.X < b.X
+	})
+
+	rng, vehicles := e.vehicles.snapshot()
+	s.VehicleRNG = rng
+	for _, v := range vehicles {
+		s.Vehicles = append(s.Vehicles, snapshotVehicle(v))
+	}
+	if g := e.generator; g != nil {
+		cfg := g.cfg
+		cfg.Hotspots = nil
+		gs := &GeneratorSnapshot{Config: cfg, RNG: marshalRNG(g.src), Expires: make(map[string]int64, len(g.expires))}
+		for id, t := range g.expires {
+			gs.Expires[id] = t
+		}
+		gs.Hotspots = cellValues(g.cfg.Hotspots)
+		s.Generator = gs
+	}
+	if d := e.demand; d != nil {
+		s.Demand = &DemandSnapshot{Model: d.model, RNG: marshalRNG(d.src)}
+	}
+	s.Profiles.Ticks = e.profiles.ticks
+	for k, p := range e.profiles.prior {
+		s.Profiles.Prior = append(s.Profiles.Prior, CellProfile{X: k[0], Y: k[1], Profile: p})
+	}
+	for k, ex := range e.profiles.excess {
+		s.Profiles.Excess = append(s.Profiles.Excess, CellProfile{X: k[0], Y: k[1], Profile: *ex})
+	}
+	sortCellProfiles(s.Profiles.Prior)
+	sortCellProfiles(s.Profiles.Excess)
+	return s, nil
+}
+
+// NewEngineFromSnapshot builds an idle engine in the state s was taken in.
+func NewEngineFromSnapshot(s *Snapshot) (*Engine, error) {
+	if s.Version != SnapshotVersion {
+		return nil, fmt.Errorf("%w %d, want %d", ErrSnapshotVersion, s.Version, SnapshotVersion)
+	}
+	if s.Width <= 0 || s.Height <= 0 {
+		return nil, fmt.Errorf("invalid grid size %dx%d", s.Width, s.Height)
+	}
+	if s.Seed == 0 {
+		return nil, errors.New("snapshot has no seed")
+	}
+	blocked := make(map[[2]int]bool, len(s.Blocked))
+	for _, k := range s.Blocked {
+		blocked[k] = true
+	}
+	intersections := make([]IntersectionConfig, 0, len(s.Intersections))
+	for _, it := range s.Intersections {
+		intersections = append(intersections, IntersectionConfig{X: it.X, Y: it.Y, Turns: it.Turns})
+	}
+	e := NewEngine(EngineConfig{
+		Width: s.Width, Height: s.Height, Seed: s.Seed, TickInterval: s.TickInterval,
+		StartTime: s.StartTime, Duration: s.Duration, Blocked: blocked, Intersections: intersections,
+	})
+	inBounds := func(p [2]int) bool { return e.grid.IsValid(p[0], p[1]) }
+	for _, k := range s.Blocked {
+		if !inBounds(k) {
+			return nil, fmt.Errorf("blocked cell (%d,%d) outside the grid", k[0], k[1])
+		}
+	}
+
+	if len(s.Intersections) != len(e.grid.Intersections()) {
+		return nil, errors.New("intersections outside the grid or repeated")
+	}
+	pf, err := restoreRouting(s.Width, s.Height, s.Routing, s.Intersections)
+	if err != nil {
+		return nil, err
+	}
+	e.pf = pf
+
+	if len(s.Signals) != len(e.signals) {
+		return nil, fmt.Errorf("snapshot has %d signals, the grid %d intersections", len(s.Signals), len(e.signals))
+	}
+	for _, ss := range s.Signals {
+		k := [2]int{ss.X, ss.Y}
+		if _, ok := e.signals[k]; !ok {
+			return nil, fmt.Errorf("signal at (%d,%d) is not at an intersection", ss.X, ss.Y)
+		}
+		c, err := restoreSignal(ss)
+		if err != nil {
+			return nil, fmt.Errorf("signal at (%d,%d): %w", ss.X, ss.Y, err)
+		}
+		e.signals[k] = c
+	}
+	if s.Coordination != nil {
+		cp := *s.Coordination
+		e.coordination = &cp
+	}
+
+	e.occ.Default, e.occ.StuckAfter, e.occ.seq = s.Occupancy.Default, s.Occupancy.StuckAfter, s.Occupancy.Seq
+	for _, c := range s.Occupancy.Capacity {
+		e.occ.SetCapacity(c.X, c.Y, c.CellCapacity)
+	}
+	if err := s.Kinematics.Validate(); err != nil {
+		return nil, err
+	}
+	e.kinematics = s.Kinematics
+
+	m := NewSeededVehicleManager(s.Seed)
+	if err := m.src.UnmarshalBinary(s.VehicleRNG); err != nil {
+		return nil, fmt.Errorf("vehicle random state: %w", err)
+	}
+	for _, vs := range s.Vehicles {
+		v, err := restoreVehicle(vs, inBounds)
+		if err != nil {
+			return nil, err
+		}
+		if !m.Add(v) {
+			return nil, fmt.Errorf("duplicate vehicle ID %q", v.ID)
+		}
+	}
+	e.vehicles = m
+
+	for _, inc := range s.Incidents {
+		if inc.ID == "" || !e.grid.IsValid(inc.X, inc.Y) {
+			return nil, fmt.Errorf("invalid incident %q at (%d,%d)", inc.ID, inc.X, inc.Y)
+		}
+		e.incidents.Upsert(inc)
+	}
+	if s.Scheduled < 0 || s.Scheduled > len(s.Schedule) {
+		return nil, fmt.Errorf("%d of %d scheduled incidents raised", s.Scheduled, len(s.Schedule))
+	}
+	e.schedule, e.scheduled = append([]ScheduledIncident(nil), s.Schedule...), s.Scheduled
+	e.scheduleEnds = make(map[string]int64, len(s.ScheduleEnds))
+	for id, t := range s.ScheduleEnds {
+		e.scheduleEnds[id] = t
+	}
+	if gs := s.Generator; gs != nil {
+		cfg := gs.Config
+		if len(gs.Hotspots) > 0 {
+			cfg.Hotspots = make(map[[2]int]float64, len(gs.Hotspots))
+			for _, h := range gs.Hotspots {
+				cfg.Hotspots[[2]int{h.X, h.Y}] = h.Value
+			}
+		}
+		g := newIncidentGenerator(cfg, s.Seed)
+		if err := g.src.UnmarshalBinary(gs.RNG); err != nil {
+			return nil, fmt.Errorf("incident random state: %w", err)
+		}
+		for id, t := range gs.Expires {
+			g.expires[id] = t
+		}
+		e.generator = g
+	}
+	if ds := s.Demand; ds != nil {
+		if err := ds.Model.Validate(s.Width, s.Height); err != nil {
+			return nil, fmt.Errorf("demand: %w", err)
+		}
+		d := newDemandGenerator(ds.Model, s.Seed)
+		if err := d.src.UnmarshalBinary(ds.RNG); err != nil {
+			return nil, fmt.Errorf("demand random state: %w", err)
+		}
+		e.demand = d
+	}
+
+	e.ticks = s.Tick
+	e.stats = s.Stats
+	e.trips = append([]Trip(nil), s.Trips...)
+	e.responses = append([]EmergencyResponse(nil), s.Responses...)
+	t := s.TripTotals
+	e.tripTotals = tripTotals{
+		completed: t.Completed, travel: t.Travel, freeFlow: t.FreeFlow,
+		delay: t.Delay, stops: t.Stops, distance: t.Distance,
+	}
+	for _, k := range s.Congested {
+		e.congested[k] = true
+	}
+	e.stalled = make(map[[2]int]int, len(s.Stalled))
+	for _, c := range s.Stalled {
+		e.stalled[[2]int{c.X, c.Y}] = c.Count
+	}
+	for _, f := range s.Flows {
+		k := [2]int{f.X, f.Y}
+		if e.flows[k] == nil {
+			e.flows[k] = make(map[Movement]int)
+		}
+		e.flows[k][f.Movement] = f.Count
+	}
+	e.flowSince = s.FlowSince
+	// predictions are multipliers of at least 1, and the learned sums of the
+	// excess over 1 are never negative
+	for _, cp := range s.Profiles.Prior {
+		if err := checkCellProfile(e, cp, 1); err != nil {
+			return nil, err
+		}
+		e.profiles.prior[[2]int{cp.X, cp.Y}] = cp.Profile
+	}
+	for _, cp := range s.Profiles.Excess {
+		if err := checkCellProfile(e, cp, 0); err != nil {
+			return nil, err
+		}
+		ex := [24]float64(cp.Profile)
+		e.profiles.excess[[2]int{cp.X, cp.Y}] = &ex
+	}
+	for h, n := range s.Profiles.Ticks {
+		if n < 0 {
+			return nil, fmt.Errorf("congestion profiles: %d ticks seen at hour %d", n, h)
+		}
+	}
+	e.profiles.ticks = s.Profiles.Ticks
+	return e, nil
+}
+
+// Restore replaces the engine's state with s. Subscribers stay subscribed, and
+// a running engine carries on from the restored tick at its own tick interval.
+func (e *Engine) Restore(s *Snapshot) error {
+	n, err := NewEngineFromSnapshot(s)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.grid, e.pf, e.signals, e.vehicles, e.occ = n.grid, n.pf, n.signals, n.vehicles, n.occ
+	e.congested, e.stalled, e.ticks, e.stats = n.congested, n.stalled, n.ticks, n.stats
+	e.profiles = n.profiles
+	e.flows, e.flowSince, e.coordination = n.flows, n.flowSince, n.coordination
+	e.responses, e.trips, e.tripTotals = n.responses, n.trips, n.tripTotals
+	e.incidents, e.baseBlocked, e.generator = n.incidents, n.baseBlocked, n.generator
+	e.start, e.demand, e.kinematics, e.duration = n.start, n.demand, n.kinematics, n.duration
+	e.schedule, e.scheduled, e.scheduleEnds = n.schedule, n.scheduled, n.scheduleEnds
+	return nil
+}
+
+// snapshot returns the random state and the vehicles in insertion order.
+func (m *VehicleManager) snapshot() ([]byte, []*Vehicle) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]*Vehicle, 0, len(m.order))
+	for _, id := range m.order {
+		out = append(out, m.vehicles[id])
+	}
+	return marshalRNG(m.src), out
+}
+
+func marshalRNG(src interface{ MarshalBinary() ([]byte, error) }) []byte {
+	b, err := src.MarshalBinary()
+	if err != nil { // ChaCha8 marshalling never fails
+		panic(err)
+	}
+	return b
+}
+
+func (p *PathFinder) snapshot() RoutingSnapshot {
+	r := RoutingSnapshot{
+		Blocked:     sortedCells(p.Blocked),
+		BaseCost:    cellValues(p.baseCost),
+		MinBaseCost: p.minBase,
+		SpeedLimit:  cellValues(p.speedLimit),
+		Congestion:  cellValues(p.congestion),
+		Penalty:     cellValues(p.penalty),
+		Version:     p.version,
+	}
+	for k, closed := range p.closedEdges {
+		if closed {
+			r.ClosedEdges = append(r.ClosedEdges, k)
+		}
+	}
+	sort.Slice(r.ClosedEdges, func(i, j int) bool {
+		a, b := r.ClosedEdges[i], r.ClosedEdges[j]
+		for n := range a {
+			if a[n] != b[n] {
+				return a[n] < b[n]
+			}
+		}
+		return false
+	})
+	return r
+}
+
+func restoreRouting(width, height int, r RoutingSnapshot, intersections []grid.Intersection) (*PathFinder, error) {
+	p := NewPathFinder(width, height, nil)
+	for _, k := range r.Blocked {
+		if !p.inBounds(k[0], k[1]) {
+			return nil, fmt.Errorf("blocked cell (%d,%d) outside the grid", k[0], k[1])
+		}
+		p.Blocked[k] = true
+	}
+	for _, e := range r.ClosedEdges {
+		p.closedEdges[e] = true
+	}
+	for _, set := range []struct {
+		name string
+		m    map[[2]int]float64
+		vals []CellValue
+	}{
+		{"base cost", p.baseCost, r.BaseCost},
+		{"speed limit", p.speedLimit, r.SpeedLimit},
+		{"congestion", p.congestion, r.Congestion},
+		{"incident penalty", p.penalty, r.Penalty},
+	} {
+		for _, c := range set.vals {
+			if !p.inBounds(c.X, c.Y) || c.Value <= 0 {
+				return nil, fmt.Errorf("invalid %s %v at (%d,%d)", set.name, c.Value, c.X, c.Y)
+			}
+			set.m[[2]int{c.X, c.Y}] = c.Value
+		}
+	}
+	for _, it := range intersections {
+		if err := p.SetTurnRules(it.X, it.Y, it.Turns); err != nil {
+			return nil, fmt.Errorf("intersection (%d,%d): %w", it.X, it.Y, err)
+		}
+	}
+	if r.MinBaseCost > 0 {
+		p.minBase = r.MinBaseCost
+	}
+	p.version = r.Version
+	return p, nil
+}
+
+func snapshotSignal(p *preemptible) (SignalSnapshot, error) {
+	ss := SignalSnapshot{
+		Plan: p.Plan(),
+		Preemption: PreemptionSnapshot{
+			Stage: int(p.stage), Elapsed: p.elapsed,
+			Target: sortedMovements(p.target), Was: sortedMovements(p.was),
+		},
+	}
+	runner := func(r *phaseRunner) {
+		ss.Phase, ss.Next, ss.Stage, ss.Elapsed = r.phase, r.next, r.stage, r.elapsed
+	}
+	switch c := p.Controller.(type) {
+	case *Signal:
+		ss.Control, ss.CycleTime = ControlFixed, c.t
+	case *ActuatedController:
+		cfg := c.cfg
+		ss.Control, ss.Actuated, ss.Gap = ControlActuated, &cfg, c.gap
+		runner(&c.phaseRunner)
+	case *MaxPressureController:
+		cfg := c.cfg
+		ss.Control, ss.MaxPressure = ControlMaxPressure, &cfg
+		runner(&c.phaseRunner)
+	default:
+		return SignalSnapshot{}, fmt.Errorf("controller %T cannot be saved", c)
+	}
+	return ss, nil
+}
+
+func restoreSignal(ss SignalSnapshot) (*preemptible, error) {
+	if len(ss.Plan.Phases) == 0 {
+		return nil, errors.New("plan has no phases")
+	}
+	runner := func(r *phaseRunner) error {
+		if ss.Phase < 0 || ss.Phase >= len(r.phases) || ss.Next < 0 || ss.Next >= len(r.phases) {
+			return fmt.Errorf("phase %d or %d out of range", ss.Phase, ss.Next)
+		}
+		switch ss.Stage {
+		case StageGreen, StageYellow, StageAllRed:
+		default:
+			return fmt.Errorf("unknown stage %q", ss.Stage)
+		}
+		r.phase, r.next, r.stage, r.elapsed = ss.Phase, ss.Next, ss.Stage, ss.Elapsed
+		return nil
+	}
+	var c Controller
+	switch ss.Control {
+	case ControlFixed:
+		s := NewSignal(ss.Plan)
+		if ss.CycleTime < 0 || ss.CycleTime >= s.cycle {
+			return nil, fmt.Errorf("cycle time %d outside the %ds cycle", ss.CycleTime, s.cycle)
+		}
+		s.t = ss.CycleTime
+		c = s
+	case ControlActuated:
+		if ss.Actuated == nil {
+			return nil, errors.New("actuated signal without config")
+		}
+		a := NewActuatedController(ss.Plan, *ss.Actuated)
+		if err := runner(&a.phaseRunner); err != nil {
+			return nil, err
+		}
+		a.gap = ss.Gap
+		c = a
+	case ControlMaxPressure:
+		if ss.MaxPressure == nil {
+			return nil, errors.New("max-pressure signal without config")
+		}
+		mp := NewMaxPressureController(ss.Plan, *ss.MaxPressure)
+		if err := runner(&mp.phaseRunner); err != nil {
+			return nil, err
+		}
+		c = mp
+	default:
+		return nil, fmt.Errorf("unknown control %q", ss.Control)
+	}
+	pr := ss.Preemption
+	if pr.Stage < int(preemptIdle) || pr.Stage > int(preemptExitAllRed) {
+		return nil, fmt.Errorf("unknown preemption stage %d", pr.Stage)
+	}
+	p := newPreemptible(c)
+	p.stage, p.elapsed = preemptStage(pr.Stage), pr.Elapsed
+	p.target, p.was = movementSet(pr.Target), movementSet(pr.Was)
+	return p, nil
+}
+
+func snapshotVehicle(v *Vehicle) VehicleSnapshot {
+	vs := VehicleSnapshot{
+		Vehicle:      *v,
+		RouteIdx:     v.routeIdx,
+		RouteDest:    [2]int{v.routeDest.X, v.routeDest.Y},
+		RouteVersion: v.routeVersion,
+		Planned:      v.planned && len(v.route) > 0, // a failed search is repeated after a restore
+		Dwell:        v.dwell,
+		Blocked:      v.blocked,
+		Arrival:      v.arrival,
+		Progress:     v.progress,
+		Trip: TripProgress{
+			Started: v.trip.started, Origin: [2]int{v.trip.origin.X, v.trip.origin.Y}, FreeFlow: v.trip.freeFlow,
+			Distance: v.trip.distance, Stops: v.trip.stops, Moving: v.trip.moving,
+		},
+	}
+	for _, p := range v.route {
+		vs.Route = append(vs.Route, [2]int{p.X, p.Y})
+	}
+	return vs
+}
+
+func restoreVehicle(vs VehicleSnapshot, inBounds func([2]int) bool) (*Vehicle, error) {
+	v := vs.Vehicle
+	if v.ID == "" {
+		return nil, errors.New("vehicle without ID")
+	}
+	if !inBounds([2]int{v.X, v.Y}) || !inBounds([2]int{v.DestX, v.DestY}) {
+		return nil, fmt.Errorf("vehicle %s at (%d,%d) heading to (%d,%d) outside the grid", v.ID, v.X, v.Y, v.DestX, v.DestY)
+	}
+	v.route = nil // an in-memory snapshot still carries the original's
+	for _, p := range vs.Route {
+		if !inBounds(p) {
+			return nil, fmt.Errorf("vehicle %s route leaves the grid at (%d,%d)", v.ID, p[0], p[1])
+		}
+		v.route = append(v.route, point{p[0], p[1]})
+	}
+	switch {
+	case vs.Planned && len(v.route) == 0:
+		return nil, fmt.Errorf("vehicle %s planned without a route", v.ID)
+	case len(v.route) > 0 && (vs.RouteIdx < 0 || vs.RouteIdx >= len(v.route)),
+		len(v.route) == 0 && vs.RouteIdx != 0:
+		return nil, fmt.Errorf("vehicle %s route index %d out of range", v.ID, vs.RouteIdx)
+	case vs.Dwell < 0 || vs.Blocked < 0:
+		return nil, fmt.Errorf("vehicle %s has negative dwell or blocked ticks", v.ID)
+	case !(vs.Progress >= 0 && vs.Progress <= 1):
+		return nil, fmt.Errorf("vehicle %s progress %g outside [0,1]", v.ID, vs.Progress)
+	}
+	if err := v.Dynamics.Validate(); err != nil {
+		return nil, fmt.Errorf("vehicle %s: %w", v.ID, err)
+	}
+	if v.Type == "" {
+		v.Type = VehicleCar
+	}
+	v.routeIdx, v.routeVersion, v.planned = vs.RouteIdx, vs.RouteVersion, vs.Planned
+	v.routeDest = point{vs.RouteDest[0], vs.RouteDest[1]}
+	v.dwell, v.blocked, v.arrival, v.progress = vs.Dwell, vs.Blocked, vs.Arrival, vs.Progress
+	t := vs.Trip
+	v.trip = tripState{
+		started: t.Started, origin: point{t.Origin[0], t.Origin[1]}, freeFlow: t.FreeFlow,
+		distance: t.Distance, stops: t.Stops, moving: t.Moving,
+	}
+	return &v, nil
+}
+
+// sortedCells lists the set cells of m in row-major order.
+func sortedCells(m map[[2]int]bool) [][2]int {
+	var out [][2]int
+	for k, ok := range m {
+		if ok {
+			out = append(out, k)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i][1] < out[j][1] || out[i][1] == out[j][1] && out[i][0] < out[j][0]
+	})
+	return out
+}
+
+// cellValues lists m in row-major order.
+func cellValues(m map[[2]int]float64) []CellValue {
+	var out []CellValue
+	for k, v := range m {
+		out = append(out, CellValue{X: k[0], Y: k[1], Value: v})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Y < out[j].Y || out[i].Y == out[j].Y && out[i].X < out[j].X
+	})
+	return out
+}
+
+// checkCellProfile checks a profile is of a cell of e and its values are
+// finite and no lower than least.
+func checkCellProfile(e *Engine, cp CellProfile, least float64) error {
+	if !e.grid.IsValid(cp.X, cp.Y) {
+		return fmt.Errorf("congestion profile of (%d,%d) outside the grid", cp.X, cp.Y)
+	}
+	for h, v := range cp.Profile {
+		if !(v >= least) || math.IsInf(v, 1) {
+			return fmt.Errorf("congestion profile of (%d,%d): %g at hour %d, want a finite value of at least %g", cp.X, cp.Y, v, h, least)
+		}
+	}
+	return nil
+}
+
+func sortCellProfiles(ps []CellProfile) {
+	sort.Slice(ps, func(i, j int) bool {
+		return ps[i].Y < ps[j].Y || ps[i].Y == ps[j].Y && ps[i].X < ps[j].X
+	})
+}
+
+func movementLess(a, b Movement) bool {
+	return a.Approach < b.Approach || a.Approach == b.Approach && a.Turn < b.Turn
+}
+
+func sortedMovements(set map[Movement]bool) []Movement {
+	var out []Movement
+	for m, ok := range set {
+		if ok {
+			out = append(out, m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return movementLess(out[i], out[j]) })
+	return out
+}
+
+func movementSet(ms []Movement) map[Movement]bool {
+	if ms == nil {
+		return nil
+	}
+	set := make(map[Movement]bool, len(ms))
+	for _, m := range ms {
+		set[m] = true
+	}
+	return set
+}
@@ -0,0 +1,115 @@
+package sim_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	sim "routeiq/internal/sim"
+)
+
+func moves(path [][2]int) map[[4]int]bool {
+	out := map[[4]int]bool{}
+	for i := 1; i < len(path); i++ {
+		out[[4]int{path[i-1][0], path[i-1][1], path[i][0], path[i][1]}] = true
+	}
+	return out
+}
+
+func shared(a, b [][2]int) float64 {
+	ma, mb := moves(a), moves(b)
+	n := 0
+	for m := range ma {
+		if mb[m] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(ma))
+}
+
+func TestEngineAlternatives_DiverseAndRanked(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20, Seed: 1})
+	best, alts, err := e.Alternatives(0, 0, 10, 0, sim.RouteOptions{}, 3)
+	if err != nil {
+		t.Fatalf("alternatives: %v", err)
+	}
+	if best.Distance != 10 || len(alts) != 3 {
+		t.Fatalf("expected the straight route and 3 alternatives, got %d cells and %d", best.Distance, len(alts))
+	}
+	routes := [][][2]int{best.Path}
+	prev := best.ETASeconds
+	for i, a := range alts {
+		if a.ETASeconds < prev {
+			t.Fatalf("alternative %d: expected increasing ETAs, %v after %v", i, a.ETASeconds, prev)
+		}
+		prev = a.ETASeconds
+		seen := map[[2]int]bool{}
+		for _, c := range a.Path {
+			if seen[c] {
+				t.Fatalf("alternative %d: expected a loopless path, %v repeats", i, c)
+			}
+			seen[c] = true
+		}
+		for _, other := range routes {
+			if shared(a.Path, other) > 0.7 {
+				t.Fatalf("alternative %d: expected at most 70%% overlap, got %v", i, shared(a.Path, other))
+			}
+		}
+		routes = append(routes, a.Path)
+		d := a.Differences
+		if d.ExtraSeconds != a.ETASeconds-best.ETASeconds || d.ExtraDistance != a.Distance-best.Distance {
+			t.Fatalf("alternative %d: expected differences against the best route, got %+v", i, d)
+		}
+		if !strings.Contains(d.Summary, "slower") || !strings.Contains(d.Summary, "of the best route") {
+			t.Fatalf("alternative %d: expected a summary, got %q", i, d.Summary)
+		}
+	}
+}
+
+func TestEngineAlternatives_AvoidsIncidentsAndRespectsOneWays(t *testing.T) {
+	// a loop around a block: one way east along row 0, the only other way
+	// round through rows 1 and 2
+	e := sim.NewEngine(sim.EngineConfig{Width: 6, Height: 3, Seed: 1,
+		Blocked:     map[[2]int]bool{{1, 1}: true, {2, 1}: true, {3, 1}: true, {4, 1}: true},
+		ClosedEdges: [][4]int{{5, 0, 4, 0}, {4, 0, 3, 0}, {3, 0, 2, 0}, {2, 0, 1, 0}, {1, 0, 0, 0}},
+	})
+	_, alts, err := e.Alternatives(0, 0, 5, 0, sim.RouteOptions{}, 2)
+	if err != nil || len(alts) != 1 || alts[0].Path[1] != [2]int{0, 1} {
+		t.Fatalf("expected the way round the block as the one alternative, got %+v, %v", alts, err)
+	}
+	best, alts, err := e.Alternatives(5, 0, 0, 0, sim.RouteOptions{}, 2)
+	if err != nil || best.Distance != 9 || len(alts) != 0 {
+		t.Fatalf("expected only the way round against the one-way street, got %v and %d alternatives, %v", best.Path, len(alts), err)
+	}
+	e.ReportIncident(sim.Incident{Type: sim.IncidentAccident, X: 0, Y: 2, Severity: 1})
+	_, alts, err = e.Alternatives(0, 0, 5, 0, sim.RouteOptions{AvoidIncidents: true}, 2)
+	if err != nil || len(alts) != 0 {
+		t.Fatalf("expected no alternative through the incident, got %+v, %v", alts, err)
+	}
+}
+
+func TestEngineAlternatives_BoundedOnLargeGrids(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 200, Height: 200, Seed: 1})
+	started := time.Now()
+	best, alts, err := e.Alternatives(0, 0, 199, 199, sim.RouteOptions{}, sim.MaxAlternatives)
+	if err != nil || best.Distance != 398 {
+		t.Fatalf("expected the best route across the grid, got %d cells, %v", best.Distance, err)
+	}
+	if len(alts) == sim.MaxAlternatives {
+		t.Fatalf("expected the search budget to run out before %d alternatives", len(alts))
+	}
+	if d := time.Since(started); d > 10*time.Second {
+		t.Fatalf("expected the search to give up early, took %v", d)
+	}
+}
+
+func TestEngineAlternatives_Errors(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 10, Height: 10, Seed: 1})
+	if _, _, err := e.Alternatives(0, 0, 5, 5, sim.RouteOptions{}, sim.MaxAlternatives+1); err == nil {
+		t.Fatalf("expected too many alternatives to be refused")
+	}
+	if _, _, err := e.Alternatives(0, 0, 5, 50, sim.RouteOptions{}, 1); !errors.Is(err, sim.ErrInvalidPoint) {
+		t.Fatalf("expected an invalid destination, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package sim_test
+
+import (
+	"fmt"
+	"testing"
+
+	sim "routeiq/internal/sim"
+)
+
+func TestMoveOneTick_LanesLimitEntriesPerTick(t *testing.T) {
+	pf := sim.NewPathFinder(10, 10, nil)
+	occ := sim.NewOccupancy()
+	occ.SetCapacity(0, 0, sim.CellCapacity{Lanes: 2, Storage: 2})
+	occ.SetCapacity(1, 0, sim.CellCapacity{Lanes: 2, Storage: 2})
+	var vs []*sim.Vehicle
+	for i := 0; i < 3; i++ {
+		vs = append(vs, &sim.Vehicle{ID: fmt.Sprint(i), X: 0, Y: 0, DestX: 1, DestY: 0})
+	}
+	res := sim.MoveOneTick(vs, pf, nil, occ, nil)
+	if res.Moved != 2 || vs[2].X != 0 {
+		t.Fatalf("expected two of three vehicles through a two-lane cell in one tick, got %+v", res)
+	}
+	if c := occ.Capacity(5, 5); c.Lanes != 1 || c.Storage != 1 || c.Vehicles() != 1 {
+		t.Fatalf("expected single-vehicle cells by default, got %+v", c)
+	}
+}
+
+func TestMoveOneTick_QueueStoresAndSpillsBack(t *testing.T) {
+	pf := sim.NewPathFinder(10, 1, nil)
+	occ := sim.NewOccupancy()
+	occ.SetCapacity(5, 0, sim.CellCapacity{Lanes: 1, Storage: 3})
+	lights := map[[2]int]string{{6, 0}: "red"}
+	var vs []*sim.Vehicle
+	for i := 0; i < 4; i++ {
+		vs = append(vs, &sim.Vehicle{ID: fmt.Sprint(i), X: 4 - i, Y: 0, DestX: 9, DestY: 0})
+	}
+	for i := 0; i < 8; i++ {
+		sim.MoveOneTick(vs, pf, sim.UniformLights(lights), occ, nil)
+	}
+	for i, v := range vs[:3] {
+		if v.X != 5 {
+			t.Fatalf("expected vehicle %d queued in the 3-vehicle cell, at x=%d", i, v.X)
+		}
+	}
+	if vs[3].X != 4 {
+		t.Fatalf("expected the fourth vehicle to spill back to x=4, at x=%d", vs[3].X)
+	}
+	lights[[2]int{6, 0}] = "green"
+	sim.MoveOneTick(vs, pf, sim.UniformLights(lights), occ, nil)
+	if vs[0].X != 6 || vs[1].X != 5 || vs[2].X != 5 || vs[3].X != 5 {
+		t.Fatalf("expected one lane to discharge the first arrival and admit the next, got %d %d %d %d", vs[0].X, vs[1].X, vs[2].X, vs[3].X)
+	}
+}
+
+func TestEngine_QueuesReportSpillback(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20, Seed: 1,
+		Capacity: map[[2]int]sim.CellCapacity{{4, 5}: {Lanes: 1, Storage: 2}}})
+	// eastbound into (5,5), red for east-west at first
+	for x := 0; x < 4; x++ {
+		e.AddVehicle(sim.Vehicle{X: x, Y: 5, DestX: 10, DestY: 5})
+	}
+	for i := 0; i < 8; i++ {
+		e.Step()
+	}
+	var full bool
+	for _, q := range e.Queues() {
+		if q.X == 4 && q.Y == 5 {
+			full = q.Full && q.Vehicles == 2 && q.Capacity.Storage == 2
+		}
+	}
+	if !full {
+		t.Fatalf("expected a full two-vehicle queue at (4,5), got %+v", e.Queues())
+	}
+	if e.SetCellCapacity(40, 5, sim.CellCapacity{Lanes: 2}) {
+		t.Fatalf("expected capacity outside the grid to be rejected")
+	}
+}
@@ -0,0 +1,91 @@
+package sim_test
+
+import (
+	"testing"
+
+	grid "routeiq/internal/grid"
+	sim "routeiq/internal/sim"
+)
+
+func queuesFor(m sim.Movement, n int) sim.Queues {
+	return sim.Queues{In: map[sim.Movement]int{m: n}}
+}
+
+func TestActuatedController_ExtendsAndGapsOut(t *testing.T) {
+	c := sim.NewActuatedController(sim.SignalPlan{}, sim.ActuatedConfig{MinGreen: 5, MaxGreen: 20, Passage: 2})
+	// continuous north demand with east waiting: green runs to max
+	both := sim.Queues{In: map[sim.Movement]int{northThrough: 3, eastThrough: 3}}
+	for i := 0; i < 19; i++ { c.Step(both) }
+	if c.StateFor(northThrough) != "green" {
+		t.Fatalf("expected green extended while vehicles arrive")
+	}
+	c.Step(both)
+	if c.StateFor(northThrough) != "yellow" {
+		t.Fatalf("expected max-out to yellow after 20s, got %s", c.StateFor(northThrough))
+	}
+	for i := 0; i < 5; i++ { c.Step(both) } // 3s yellow + 2s all-red
+	if c.StateFor(eastThrough) != "green" {
+		t.Fatalf("expected next phase with demand (east-west) green, got phase %s", c.Phase().Name)
+	}
+	// only east demand stops arriving: gap out after min green once north waits
+	north := queuesFor(northThrough, 2)
+	for i := 0; i < 4; i++ { c.Step(north) }
+	if c.StateFor(eastThrough) != "green" {
+		t.Fatalf("expected min green to hold")
+	}
+	c.Step(north)
+	c.Step(north)
+	if c.StateFor(eastThrough) != "yellow" {
+		t.Fatalf("expected gap-out to yellow once passage time expired, got %s", c.StateFor(eastThrough))
+	}
+}
+
+func TestActuatedController_RestsInGreenWithoutConflictingDemand(t *testing.T) {
+	c := sim.NewActuatedController(sim.SignalPlan{}, sim.DefaultActuatedConfig())
+	for i := 0; i < 200; i++ { c.Step(sim.Queues{}) }
+	if c.StateFor(northThrough) != "green" {
+		t.Fatalf("expected controller to rest in green with no demand")
+	}
+}
+
+func TestMaxPressureController_ServesHighestPressure(t *testing.T) {
+	c := sim.NewMaxPressureController(sim.SignalPlan{}, sim.MaxPressureConfig{MinGreen: 3})
+	q := sim.Queues{
+		In:  map[sim.Movement]int{northThrough: 2, eastThrough: 6, northLeft: 1},
+		Out: map[grid.Direction]int{grid.East: 1},
+	}
+	for i := 0; i < 2; i++ { c.Step(q) }
+	if c.StateFor(northThrough) != "green" {
+		t.Fatalf("expected min green to hold")
+	}
+	c.Step(q)
+	if c.StateFor(northThrough) != "yellow" {
+		t.Fatalf("expected switch towards east-west after min green")
+	}
+	for i := 0; i < 5; i++ { c.Step(q) }
+	if c.Phase().Name != "ew-through" || c.StateFor(eastThrough) != "green" {
+		t.Fatalf("expected ew-through (pressure 5) to be served, got %s", c.Phase().Name)
+	}
+}
+
+func TestEngine_AdaptiveControlReducesWaiting(t *testing.T) {
+	run := func(control string) int64 {
+		e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20, Seed: 1, Control: control})
+		for i := 0; i < 300; i++ {
+			if i%2 == 0 {
+				e.AddVehicle(sim.Vehicle{X: 5, Y: 12, DestX: 5, DestY: 0})
+			}
+			e.Step()
+		}
+		return e.Stats().WaitTicks
+	}
+	fixed := run(sim.ControlFixed)
+	actuated := run(sim.ControlActuated)
+	pressure := run(sim.ControlMaxPressure)
+	if actuated >= fixed || pressure >= fixed {
+		t.Fatalf("expected adaptive control to wait less than fixed (%d): actuated %d, max-pressure %d", fixed, actuated, pressure)
+	}
+	if _, err := sim.NewController("bogus", sim.SignalPlan{}); err == nil {
+		t.Fatalf("expected error for unknown control")
+	}
+}
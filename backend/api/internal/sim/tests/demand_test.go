@@ -0,0 +1,111 @@
+package sim_test
+
+import (
+	"testing"
+	"time"
+
+	sim "routeiq/internal/sim"
+)
+
+func TestProfile_Interpolates(t *testing.T) {
+	var p sim.Profile
+	p[8], p[9] = 2, 4
+	if got := p.At(8*time.Hour + 30*time.Minute); got != 3 {
+		t.Fatalf("expected 3 halfway between 08:00 and 09:00, got %v", got)
+	}
+	if got := sim.DailyProfile.At(8 * time.Hour); got <= sim.DailyProfile.At(3*time.Hour) {
+		t.Fatalf("expected a morning peak above the night rate")
+	}
+}
+
+func TestDemandModel_Validate(t *testing.T) {
+	if err := sim.CommuterDemand(20, 20, 100).Validate(20, 20); err != nil {
+		t.Fatalf("expected commuter model valid, got %v", err)
+	}
+	bad := sim.DemandModel{Zones: []sim.Zone{{MinX: 0, MaxX: 25, MaxY: 3}}}
+	if bad.Validate(20, 20) == nil {
+		t.Fatalf("expected zone outside the grid to be rejected")
+	}
+	bad = sim.DemandModel{Zones: []sim.Zone{{MaxX: 3, MaxY: 3}}, Flows: []sim.ODFlow{{From: 0, To: 2}}}
+	if bad.Validate(20, 20) == nil {
+		t.Fatalf("expected flow to a missing zone to be rejected")
+	}
+}
+
+func departures(m sim.DemandModel, start time.Duration, ticks int) (*sim.Engine, int64) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20, Seed: 3, StartTime: start, Demand: &m})
+	for i := 0; i < ticks; i++ {
+		e.Step()
+	}
+	return e, e.Stats().Departures
+}
+
+func TestEngine_DemandPoissonArrivalsInZones(t *testing.T) {
+	home := sim.Zone{Name: "home", MinX: 0, MinY: 0, MaxX: 4, MaxY: 4}
+	work := sim.Zone{Name: "work", MinX: 15, MinY: 15, MaxX: 19, MaxY: 19}
+	flat := sim.Profile{}
+	for h := range flat {
+		flat[h] = 1
+	}
+	m := sim.DemandModel{Zones: []sim.Zone{home, work}, Flows: []sim.ODFlow{{From: 0, To: 1, TripsPerHour: 3600}}, Profile: flat}
+	e, n := departures(m, 0, 400)
+	if n < 340 || n > 460 {
+		t.Fatalf("expected about 400 arrivals at one per second, got %d", n)
+	}
+	for _, v := range e.Vehicles() {
+		if !work.Contains(v.DestX, v.DestY) {
+			t.Fatalf("expected every trip to end in the work zone, got (%d,%d)", v.DestX, v.DestY)
+		}
+	}
+	_, again := departures(m, 0, 400)
+	if again != n {
+		t.Fatalf("expected the same arrivals for one seed, got %d and %d", n, again)
+	}
+}
+
+func TestEngine_DemandFollowsTimeOfDay(t *testing.T) {
+	m := sim.CommuterDemand(20, 20, 600)
+	_, peak := departures(m, 8*time.Hour, 600)
+	_, night := departures(m, 3*time.Hour, 600)
+	if peak < 4*night || peak == 0 {
+		t.Fatalf("expected rush-hour demand well above night demand, got %d vs %d", peak, night)
+	}
+}
+
+func TestEngine_DemandOnSparseStreets(t *testing.T) {
+	// streets along every tenth row and column: 19 of the 100 cells of each zone
+	blocked := make(map[[2]int]bool)
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			if x%10 != 0 && y%10 != 0 {
+				blocked[[2]int{x, y}] = true
+			}
+		}
+	}
+	flat := sim.Profile{}
+	for h := range flat {
+		flat[h] = 1
+	}
+	home := sim.Zone{Name: "home", MaxX: 9, MaxY: 9}
+	work := sim.Zone{Name: "work", MinX: 30, MinY: 30, MaxX: 39, MaxY: 39}
+	m := sim.DemandModel{Zones: []sim.Zone{home, work}, Flows: []sim.ODFlow{{From: 0, To: 1, TripsPerHour: 3600}}, Profile: flat}
+	e := sim.NewEngine(sim.EngineConfig{Width: 40, Height: 40, Seed: 3, Blocked: blocked, Demand: &m})
+	for i := 0; i < 400; i++ {
+		e.Step()
+	}
+	st := e.Stats()
+	if st.Departures < 340 || st.Departures > 460 || st.UnservedTrips != 0 {
+		t.Fatalf("expected about 400 trips and none lost on sparse streets, got %d and %d lost", st.Departures, st.UnservedTrips)
+	}
+	for _, v := range e.Vehicles() {
+		if blocked[[2]int{v.DestX, v.DestY}] {
+			t.Fatalf("expected every trip to end on a street, got (%d,%d)", v.DestX, v.DestY)
+		}
+	}
+
+	walled := sim.DemandModel{Zones: []sim.Zone{home, {Name: "block", MinX: 31, MinY: 31, MaxX: 34, MaxY: 34}},
+		Flows: []sim.ODFlow{{From: 0, To: 1, TripsPerHour: 3600}}}
+	if err := e.SetDemand(&walled); err == nil {
+		t.Fatalf("expected a zone without open cells to be rejected")
+	}
+}
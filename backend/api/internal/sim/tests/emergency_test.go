@@ -0,0 +1,54 @@
+package sim_test
+
+import (
+	"testing"
+
+	grid "routeiq/internal/grid"
+	sim "routeiq/internal/sim"
+)
+
+func signalState(e *sim.Engine, x, y int) sim.SignalState {
+	for _, s := range e.Signals() {
+		if s.X == x && s.Y == y {
+			return s
+		}
+	}
+	return sim.SignalState{}
+}
+
+func TestMoveOneTick_EmergencyTakesReservedCellAndIgnoresRed(t *testing.T) {
+	pf := sim.NewPathFinder(10, 10, nil)
+	car := &sim.Vehicle{ID: "car", X: 2, Y: 3, DestX: 4, DestY: 3}
+	amb := &sim.Vehicle{ID: "amb", Type: sim.VehicleEmergency, X: 3, Y: 2, DestX: 3, DestY: 4}
+	lights := sim.UniformLights{{3, 3}: "red"}
+	sim.MoveOneTick([]*sim.Vehicle{car, amb}, pf, lights, sim.NewOccupancy(), nil)
+	if amb.X != 3 || amb.Y != 3 {
+		t.Fatalf("expected emergency vehicle through the red light to (3,3), got (%d,%d)", amb.X, amb.Y)
+	}
+	if car.X != 2 || car.Y != 3 {
+		t.Fatalf("expected car to yield, got (%d,%d)", car.X, car.Y)
+	}
+}
+
+func TestEngine_PreemptsSignalAheadOfEmergencyVehicle(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20, Seed: 1})
+	// westbound towards (5,15), which starts green for north-south
+	id, _, _ := e.AddVehicle(sim.Vehicle{Type: sim.VehicleEmergency, X: 12, Y: 15, DestX: 0, DestY: 15})
+	e.Step()
+	if s := signalState(e, 5, 15); !s.Preempted || s.Approaches[grid.North] != "yellow" {
+		t.Fatalf("expected conflicting north-south green cleared through yellow, got %+v", s)
+	}
+	for i := 0; i < 5; i++ { e.Step() }
+	if s := signalState(e, 5, 15); s.Approaches[grid.West] != "green" || s.Approaches[grid.North] != "red" {
+		t.Fatalf("expected westbound green after clearance, got %v", s.Approaches)
+	}
+	for i := 0; i < 6; i++ { e.Step() }
+	sum := e.EmergencyResponses()
+	if len(sum.Responses) != 1 || sum.Responses[0].VehicleID != id || sum.Responses[0].ResponseSeconds != 12 {
+		t.Fatalf("expected one 12s response for %s, got %+v", id, sum.Responses)
+	}
+	for i := 0; i < 6; i++ { e.Step() }
+	if s := signalState(e, 5, 15); s.Preempted {
+		t.Fatalf("expected signal released after the vehicle passed, got %+v", s)
+	}
+}
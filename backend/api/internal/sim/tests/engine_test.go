@@ -0,0 +1,125 @@
+package sim_test
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	grid "routeiq/internal/grid"
+	sim "routeiq/internal/sim"
+)
+
+func TestEngine_StepAdvancesLightsAndVehicles(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20})
+	if n := len(e.Signals()); n != 4 {
+		t.Fatalf("expected one signal per intersection (4), got %d", n)
+	}
+	ids := e.Spawn(5)
+	if len(ids) != 5 || e.VehicleCount() != 5 {
+		t.Fatalf("expected 5 vehicles, got %d", e.VehicleCount())
+	}
+	for i := 0; i < 19; i++ {
+		e.Step()
+	}
+	for _, sig := range e.Signals() {
+		if sig.Approaches[grid.North] != "yellow" || sig.Approaches[grid.East] != "red" {
+			t.Fatalf("expected north yellow, east red after 19 ticks at (%d,%d), got %v", sig.X, sig.Y, sig.Approaches)
+		}
+	}
+	for i := 0; i < 11; i++ {
+		e.Step()
+	}
+	if e.Ticks() != 30 {
+		t.Fatalf("expected 30 ticks, got %d", e.Ticks())
+	}
+	trips := e.Trips()
+	if len(trips) == 0 {
+		t.Fatalf("expected at least one vehicle to reach its destination in 30 ticks")
+	}
+	if e.VehicleCount() != 5-len(trips) {
+		t.Fatalf("expected arrived vehicles to be removed, %d left after %d trips", e.VehicleCount(), len(trips))
+	}
+}
+
+func TestEngine_StartPauseStop(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 10, Height: 10, TickInterval: time.Millisecond})
+	e.Start()
+	if !e.Running() {
+		t.Fatalf("expected engine running after Start")
+	}
+	deadline := time.Now().Add(time.Second)
+	for e.Ticks() < 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if e.Ticks() < 5 {
+		t.Fatalf("expected ticks to advance, got %d", e.Ticks())
+	}
+	e.Pause()
+	if e.Running() {
+		t.Fatalf("expected engine not running after Pause")
+	}
+	time.Sleep(5 * time.Millisecond)
+	paused := e.Ticks()
+	time.Sleep(10 * time.Millisecond)
+	if e.Ticks() != paused {
+		t.Fatalf("expected no ticks while paused, got %d -> %d", paused, e.Ticks())
+	}
+	e.Step()
+	if e.Ticks() != paused+1 {
+		t.Fatalf("expected Step to advance while paused")
+	}
+	e.Stop()
+	e.Stop() // idempotent
+	after := e.Ticks()
+	time.Sleep(5 * time.Millisecond)
+	if e.Ticks() != after {
+		t.Fatalf("expected no ticks after Stop")
+	}
+}
+
+func TestEngine_SameSeedReplaysIdentically(t *testing.T) {
+	cfg := sim.EngineConfig{Width: 20, Height: 20, Vehicles: 80, Seed: 2024}
+	a, b := sim.NewEngine(cfg), sim.NewEngine(cfg)
+	for i := 0; i < 60; i++ {
+		a.Step()
+		b.Step()
+	}
+	va, vb := a.Vehicles(), b.Vehicles()
+	if len(va) != len(vb) {
+		t.Fatalf("vehicle counts differ: %d vs %d", len(va), len(vb))
+	}
+	for i := range va {
+		if va[i].ID != vb[i].ID || va[i].X != vb[i].X || va[i].Y != vb[i].Y {
+			t.Fatalf("vehicle %d diverged: %s(%d,%d) vs %s(%d,%d)", i, va[i].ID, va[i].X, va[i].Y, vb[i].ID, vb[i].X, vb[i].Y)
+		}
+	}
+	if a.Seed() != 2024 {
+		t.Fatalf("expected seed 2024, got %d", a.Seed())
+	}
+	if sim.NewEngine(sim.EngineConfig{Width: 5, Height: 5}).Seed() == 0 {
+		t.Fatalf("expected a random non-zero seed when none is configured")
+	}
+}
+
+func TestNewEngine_LogsIgnoredConfig(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	k := sim.Kinematics{Accel: -1}
+	m := sim.CommuterDemand(1, 10, 100) // quadrants with no columns
+	e := sim.NewEngine(sim.EngineConfig{Width: 1, Height: 10, Seed: 1, Kinematics: &k, Demand: &m})
+	for _, want := range []string{"ignoring the configured kinematics", "ignoring the configured demand model"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q logged, got %q", want, out.String())
+		}
+	}
+	for i := 0; i < 600; i++ {
+		e.Step()
+	}
+	if st := e.Stats(); st.Departures != 0 {
+		t.Fatalf("expected no trips from the ignored model, got %d", st.Departures)
+	}
+}
@@ -0,0 +1,104 @@
+package sim_test
+
+import (
+	"testing"
+
+	grid "routeiq/internal/grid"
+	sim "routeiq/internal/sim"
+)
+
+func TestWebsterCycle(t *testing.T) {
+	if got := sim.WebsterCycle(10, []float64{0.3, 0.2}); got != 40 {
+		t.Fatalf("expected (1.5*10+5)/(1-0.5)=40, got %d", got)
+	}
+	if got := sim.WebsterCycle(10, []float64{0.1}); got != sim.MinCycle {
+		t.Fatalf("expected light demand clamped to %d, got %d", sim.MinCycle, got)
+	}
+	if got := sim.WebsterCycle(10, []float64{0.6, 0.5}); got != sim.MaxCycle {
+		t.Fatalf("expected oversaturation to give %d, got %d", sim.MaxCycle, got)
+	}
+}
+
+func TestWebsterSplits_ProportionalAndExact(t *testing.T) {
+	plan := sim.DefaultSignalPlan()
+	out := sim.WebsterSplits(plan, 90, []float64{0.4, 0.05, 0.2, 0.05})
+	if out.CycleLength() != 90 {
+		t.Fatalf("expected cycle of exactly 90s, got %d", out.CycleLength())
+	}
+	if out.Phases[0].GreenSec <= out.Phases[2].GreenSec || out.Phases[2].GreenSec <= out.Phases[1].GreenSec {
+		t.Fatalf("expected greens ordered by demand, got %d/%d/%d/%d",
+			out.Phases[0].GreenSec, out.Phases[1].GreenSec, out.Phases[2].GreenSec, out.Phases[3].GreenSec)
+	}
+	if plan.Phases[0].GreenSec != 19 {
+		t.Fatalf("expected input plan to be left untouched")
+	}
+}
+
+func TestOptimizeCorridor_OffsetsFollowTravelTime(t *testing.T) {
+	cp, err := sim.OptimizeCorridor(sim.Corridor{
+		Intersections: [][2]int{{5, 5}, {5, 15}},
+		Speed:         1,
+		Flows:         map[[2]int][]float64{{5, 5}: {900, 100, 600, 100}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cp.Direction != grid.South || cp.Phase != "ns-through" {
+		t.Fatalf("expected southbound wave on ns-through, got %s %s", cp.Direction, cp.Phase)
+	}
+	if len(cp.Signals) != 2 || cp.Signals[1].TravelTime != 10 {
+		t.Fatalf("expected second signal 10s downstream, got %+v", cp.Signals)
+	}
+	if got, want := cp.Signals[1].Offset, (cp.Cycle-10)%cp.Cycle; got != want {
+		t.Fatalf("expected offset %d, got %d", want, got)
+	}
+	for _, s := range cp.Signals {
+		if s.Plan.CycleLength() != cp.Cycle {
+			t.Fatalf("expected common cycle %d at (%d,%d), got %d", cp.Cycle, s.X, s.Y, s.Plan.CycleLength())
+		}
+	}
+	if _, err := sim.OptimizeCorridor(sim.Corridor{Intersections: [][2]int{{5, 5}, {15, 15}}, Speed: 1}); err == nil {
+		t.Fatalf("expected error for intersections not in a line")
+	}
+}
+
+func TestEngine_GreenWaveLetsPlatoonThrough(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20, Seed: 1})
+	cp, err := sim.OptimizeCorridor(sim.Corridor{Intersections: [][2]int{{5, 5}, {5, 15}}, Speed: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 17; i++ { e.Step() } // leave the default plans mid-cycle
+	if err := e.ApplyCoordination(cp); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	e.AddVehicle(sim.Vehicle{X: 5, Y: 4, DestX: 5, DestY: 17})
+	for i := 0; i < 13; i++ { e.Step() }
+	trips := e.Trips()
+	if len(trips) != 1 || trips[0].TravelSeconds != 13 || trips[0].Stops != 0 || e.Stats().WaitTicks != 0 {
+		t.Fatalf("expected platoon to pass both signals without stopping, got %+v after %d waits", trips, e.Stats().WaitTicks)
+	}
+	if got, ok := e.Coordination(); !ok || got.Cycle != cp.Cycle {
+		t.Fatalf("expected applied plan to be reported")
+	}
+	if err := e.ApplyCoordination(sim.CoordinationPlan{Signals: []sim.CoordinatedSignal{{X: 1, Y: 1}}}); err == nil {
+		t.Fatalf("expected error applying to a cell without a signal")
+	}
+}
+
+func TestEngine_PhaseFlows(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20, Seed: 1, Control: sim.ControlActuated})
+	for i := 0; i < 120; i++ {
+		if i%4 == 0 {
+			e.AddVehicle(sim.Vehicle{X: 5, Y: 12, DestX: 5, DestY: 0})
+		}
+		e.Step()
+	}
+	flows := e.PhaseFlows(5, 5)
+	if len(flows) != 4 || flows[0] < 600 || flows[2] != 0 {
+		t.Fatalf("expected northbound flow on ns-through only, got %v", flows)
+	}
+	if e.PhaseFlows(1, 1) != nil {
+		t.Fatalf("expected nil flows without a signal")
+	}
+}
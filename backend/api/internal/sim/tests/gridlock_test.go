@@ -0,0 +1,79 @@
+package sim_test
+
+import (
+	"testing"
+
+	sim "routeiq/internal/sim"
+)
+
+func TestMoveOneTick_FollowsButDoesNotPassStationaryVehicle(t *testing.T) {
+	pf := sim.NewPathFinder(10, 10, nil)
+	leader := &sim.Vehicle{ID: "a", X: 1, Y: 0, DestX: 5, DestY: 0}
+	follower := &sim.Vehicle{ID: "b", X: 0, Y: 0, DestX: 5, DestY: 0}
+	res := sim.MoveOneTick([]*sim.Vehicle{follower, leader}, pf, nil, sim.NewOccupancy(), nil)
+	if follower.X != 1 || leader.X != 2 || res.Moved != 2 {
+		t.Fatalf("expected the follower to move up behind the leader, got %d and %d", follower.X, leader.X)
+	}
+
+	parked := &sim.Vehicle{ID: "p", X: 1, Y: 5, DestX: 1, DestY: 5}
+	behind := &sim.Vehicle{ID: "c", X: 0, Y: 5, DestX: 3, DestY: 5}
+	res = sim.MoveOneTick([]*sim.Vehicle{behind, parked}, pf, nil, sim.NewOccupancy(), nil)
+	if behind.X != 0 || res.Blocked != 1 {
+		t.Fatalf("expected vehicle held behind the parked one, at x=%d with %d blocked", behind.X, res.Blocked)
+	}
+}
+
+func TestMoveOneTick_ResolvesWaitForCycles(t *testing.T) {
+	pf := sim.NewPathFinder(10, 10, nil)
+	// four vehicles around a block, each waiting for the next to leave
+	ring := []*sim.Vehicle{
+		{ID: "a", X: 0, Y: 0, DestX: 1, DestY: 0},
+		{ID: "b", X: 1, Y: 0, DestX: 1, DestY: 1},
+		{ID: "c", X: 1, Y: 1, DestX: 0, DestY: 1},
+		{ID: "d", X: 0, Y: 1, DestX: 0, DestY: 0},
+	}
+	res := sim.MoveOneTick(ring, pf, nil, sim.NewOccupancy(), nil)
+	if res.Cycles != 1 || res.Rotated != 4 || res.Moved != 4 {
+		t.Fatalf("expected one 4-vehicle rotation, got %+v", res)
+	}
+	for _, v := range ring {
+		if v.X != v.DestX || v.Y != v.DestY {
+			t.Fatalf("expected %s to rotate into (%d,%d), got (%d,%d)", v.ID, v.DestX, v.DestY, v.X, v.Y)
+		}
+	}
+	// head-on pair: a swap
+	a := &sim.Vehicle{ID: "a", X: 4, Y: 4, DestX: 5, DestY: 4}
+	b := &sim.Vehicle{ID: "b", X: 5, Y: 4, DestX: 4, DestY: 4}
+	if res := sim.MoveOneTick([]*sim.Vehicle{a, b}, pf, nil, sim.NewOccupancy(), nil); res.Cycles != 1 || a.X != 5 || b.X != 4 {
+		t.Fatalf("expected the pair to swap, got %+v", res)
+	}
+}
+
+func TestMoveOneTick_ReroutesVehicleStuckBehindObstruction(t *testing.T) {
+	pf := sim.NewPathFinder(5, 5, nil)
+	parked := &sim.Vehicle{ID: "p", X: 2, Y: 0, DestX: 2, DestY: 0}
+	v := &sim.Vehicle{ID: "v", X: 1, Y: 0, DestX: 4, DestY: 0}
+	occ := sim.NewOccupancy()
+	occ.StuckAfter = 3
+	rerouted := 0
+	for i := 0; i < 12; i++ {
+		rerouted += sim.MoveOneTick([]*sim.Vehicle{parked, v}, pf, nil, occ, nil).Rerouted
+	}
+	if rerouted != 1 || v.X != 4 || v.Y != 0 {
+		t.Fatalf("expected one detour around the parked vehicle to (4,0), got %d detours at (%d,%d)", rerouted, v.X, v.Y)
+	}
+}
+
+func TestEngine_DenseTrafficKeepsMoving(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 10, Height: 10, Vehicles: 150, Seed: 2})
+	for i := 0; i < 900 && e.VehicleCount() > 0; i++ {
+		e.Step()
+	}
+	st, trips := e.Stats(), e.TripSummary()
+	if e.VehicleCount() != 0 {
+		t.Fatalf("expected all 150 vehicles to finish, %d left (%d cycles, %d reroutes)", e.VehicleCount(), st.GridlockCycles, st.Reroutes)
+	}
+	if trips.Completed != 150 || st.GridlockCycles == 0 {
+		t.Fatalf("expected 150 trips with gridlock resolved on the way, got %d trips and %d cycles", trips.Completed, st.GridlockCycles)
+	}
+}
@@ -0,0 +1,95 @@
+package sim_test
+
+import (
+	"testing"
+	"time"
+
+	sim "routeiq/internal/sim"
+)
+
+// busyConfig raises incidents often enough to observe in a short run.
+func busyConfig() sim.IncidentGeneratorConfig {
+	cfg := sim.DefaultIncidentGeneratorConfig()
+	cfg.Accident.Rate *= 500
+	cfg.Closure.Rate *= 500
+	cfg.Construction.Rate *= 500
+	cfg.Accident.Duration = time.Minute
+	cfg.Closure.Duration = time.Minute
+	cfg.Construction.Duration = time.Minute
+	return cfg
+}
+
+func runIncidents(cfg sim.IncidentGeneratorConfig, start time.Duration, ticks int) []sim.Incident {
+	e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20, Seed: 7, StartTime: start, Incidents: &cfg})
+	for i := 0; i < ticks; i++ {
+		e.Step()
+	}
+	return e.Incidents(true)
+}
+
+func TestIncidentGenerator_ReproducibleAndResolves(t *testing.T) {
+	a := runIncidents(busyConfig(), 12*time.Hour, 600)
+	b := runIncidents(busyConfig(), 12*time.Hour, 600)
+	if len(a) == 0 || len(a) != len(b) {
+		t.Fatalf("expected the same non-empty incident history for one seed, got %d and %d", len(a), len(b))
+	}
+	resolved := 0
+	for i := range a {
+		if a[i].ID != b[i].ID || a[i].X != b[i].X || a[i].Severity != b[i].Severity {
+			t.Fatalf("incident %d differs between runs: %+v vs %+v", i, a[i], b[i])
+		}
+		if !a[i].Generated || a[i].Validate() != nil {
+			t.Fatalf("expected valid generated incident, got %+v", a[i])
+		}
+		if a[i].Resolved {
+			resolved++
+			if a[i].ResolvedTick <= a[i].StartTick {
+				t.Fatalf("expected resolution after start, got %+v", a[i])
+			}
+		}
+	}
+	if resolved == 0 {
+		t.Fatalf("expected minute-long incidents to resolve within 10 minutes")
+	}
+}
+
+func TestIncidentGenerator_TimeOfDayAndLocation(t *testing.T) {
+	count := func(incs []sim.Incident, typ sim.IncidentType) int {
+		n := 0
+		for _, inc := range incs {
+			if inc.Type == typ {
+				n++
+			}
+		}
+		return n
+	}
+	rush := runIncidents(busyConfig(), 8*time.Hour, 600)
+	night := runIncidents(busyConfig(), 3*time.Hour, 600)
+	if count(rush, sim.IncidentAccident) <= count(night, sim.IncidentAccident) {
+		t.Fatalf("expected more accidents at 08:00 than 03:00")
+	}
+	if count(night, sim.IncidentConstruction) <= count(rush, sim.IncidentConstruction) {
+		t.Fatalf("expected more construction at night")
+	}
+
+	cfg := busyConfig()
+	cfg.Hotspots = map[[2]int]float64{{3, 3}: 100}
+	incs := runIncidents(cfg, 12*time.Hour, 600)
+	hits := 0
+	for _, inc := range incs {
+		if inc.X == 3 && inc.Y == 3 {
+			hits++
+		}
+	}
+	if avg := float64(len(incs)) / 400; float64(hits) < 3*avg {
+		t.Fatalf("expected the hotspot to see far more than the %.1f incidents of an average cell, got %d", avg, hits)
+	}
+}
+
+func TestEngine_TimeOfDay(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 5, Height: 5, StartTime: 23*time.Hour + 59*time.Minute + 59*time.Second})
+	e.Step()
+	if got := e.TimeOfDay(); got != 0 {
+		t.Fatalf("expected clock to wrap to midnight, got %v", got)
+	}
+}
@@ -0,0 +1,128 @@
+package sim_test
+
+import (
+	"testing"
+
+	sim "routeiq/internal/sim"
+)
+
+func TestIncident_Impact(t *testing.T) {
+	cases := []struct {
+		inc     sim.Incident
+		penalty float64
+		blocked bool
+	}{
+		{sim.Incident{Type: sim.IncidentAccident, Severity: 2}, 3, false},
+		{sim.Incident{Type: sim.IncidentConstruction, Severity: 2}, 2, false},
+		{sim.Incident{Type: sim.IncidentClosure, Severity: 1}, 1, true},
+		{sim.Incident{Type: sim.IncidentAccident, Severity: 5}, 1, true},
+		{sim.Incident{Type: sim.IncidentAccident, Severity: 3, Resolved: true}, 1, false},
+	}
+	for _, c := range cases {
+		p, b := c.inc.Impact()
+		if p != c.penalty || b != c.blocked {
+			t.Fatalf("%+v: expected penalty %v blocked %v, got %v %v", c.inc, c.penalty, c.blocked, p, b)
+		}
+	}
+	if err := (sim.Incident{Type: "flood", Severity: 1}).Validate(); err == nil {
+		t.Fatalf("expected unknown type to be rejected")
+	}
+	if err := (sim.Incident{Type: sim.IncidentAccident, Severity: 6}).Validate(); err == nil {
+		t.Fatalf("expected severity 6 to be rejected")
+	}
+}
+
+func TestEngine_IncidentBlocksAndResolves(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 10, Height: 10, Seed: 1})
+	events, cancel := e.Subscribe(4)
+	defer cancel()
+	e.AddVehicle(sim.Vehicle{ID: "v", X: 0, Y: 2, DestX: 9, DestY: 2})
+	e.Step() // plans the straight route along y=2
+
+	inc, created, err := e.ReportIncident(sim.Incident{ID: "i1", Type: sim.IncidentClosure, X: 4, Y: 2, Severity: 3})
+	if err != nil || !created || inc.StartTick != 1 {
+		t.Fatalf("expected new incident at tick 1, got %+v %v %v", inc, created, err)
+	}
+	if ev := <-events; ev.Type != sim.EventIncidentUpdate || ev.Data.(sim.Incident).ID != "i1" {
+		t.Fatalf("expected incident_update event, got %+v", ev)
+	}
+	for i := 0; i < 20 && e.VehicleCount() > 0; i++ {
+		e.Step()
+		if vs := e.Vehicles(); len(vs) > 0 && vs[0].X == 4 && vs[0].Y == 2 {
+			t.Fatalf("expected vehicle to route around the closure")
+		}
+	}
+	if trips := e.Trips(); len(trips) != 1 || trips[0].Distance != 11 {
+		t.Fatalf("expected vehicle to reach its destination with an 11-cell detour, got %+v", trips)
+	}
+
+	if _, _, err := e.ReportIncident(sim.Incident{ID: "i1", Resolved: true}); err != nil {
+		t.Fatalf("expected resolve by ID, got %v", err)
+	}
+	if c := e.CellCost(4, 2); c != 1 {
+		t.Fatalf("expected cell restored after resolution, cost %v", c)
+	}
+	if n := len(e.Incidents(false)); n != 0 {
+		t.Fatalf("expected no active incidents, got %d", n)
+	}
+	if all := e.Incidents(true); len(all) != 1 || !all[0].Resolved || all[0].X != 4 {
+		t.Fatalf("expected resolved incident kept in place, got %+v", all)
+	}
+	if _, _, err := e.ReportIncident(sim.Incident{Type: sim.IncidentAccident, X: 40, Y: 2, Severity: 1}); err == nil {
+		t.Fatalf("expected out-of-grid incident to be rejected")
+	}
+}
+
+func TestEngine_IncidentReducesCapacity(t *testing.T) {
+	travel := func(incident bool) int {
+		// a 1-wide corridor so the incident cannot be avoided
+		blocked := map[[2]int]bool{}
+		for x := 0; x < 10; x++ {
+			for y := 0; y < 3; y++ {
+				if y != 1 {
+					blocked[[2]int{x, y}] = true
+				}
+			}
+		}
+		e := sim.NewEngine(sim.EngineConfig{Width: 10, Height: 3, Seed: 1, Blocked: blocked})
+		if incident {
+			e.ReportIncident(sim.Incident{Type: sim.IncidentAccident, X: 4, Y: 1, Severity: 2})
+		}
+		e.AddVehicle(sim.Vehicle{X: 0, Y: 1, DestX: 9, DestY: 1})
+		for i := 0; i < 50 && e.VehicleCount() > 0; i++ {
+			e.Step()
+		}
+		if trips := e.Trips(); len(trips) == 1 {
+			return int(trips[0].TravelSeconds)
+		}
+		return -1
+	}
+	if clear, slowed := travel(false), travel(true); clear != 9 || slowed != 11 {
+		t.Fatalf("expected 9 ticks clear and 11 through a penalty-3 accident, got %d and %d", clear, slowed)
+	}
+}
+
+func TestEngine_UnreachableVehicleWaitsForTheRoadToOpen(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 10, Height: 1, Seed: 1, Intersections: []sim.IntersectionConfig{}})
+	e.ReportIncident(sim.Incident{ID: "c", Type: sim.IncidentClosure, X: 5, Y: 0, Severity: 3})
+	e.AddVehicle(sim.Vehicle{ID: "v", DestX: 9})
+	for i := 0; i < 10; i++ {
+		e.Step()
+	}
+	if vs := e.Vehicles(); len(vs) != 1 || vs[0].X != 0 {
+		t.Fatalf("expected the vehicle to wait with no route, got %+v", vs)
+	}
+	if s, err := e.Snapshot(); err != nil {
+		t.Fatal(err)
+	} else if _, err := sim.NewEngineFromSnapshot(s); err != nil {
+		t.Fatalf("expected a vehicle with no route to restore, got %v", err)
+	}
+	// the failed search is kept, but not past a change to the network
+	e.ReportIncident(sim.Incident{ID: "c", Resolved: true})
+	for i := 0; i < 30 && e.VehicleCount() > 0; i++ {
+		e.Step()
+	}
+	if trips := e.Trips(); len(trips) != 1 || trips[0].Distance != 9 {
+		t.Fatalf("expected the vehicle to drive once the road opened, got %+v", trips)
+	}
+}
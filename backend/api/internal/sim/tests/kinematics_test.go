@@ -0,0 +1,143 @@
+package sim_test
+
+import (
+	"math"
+	"testing"
+
+	sim "routeiq/internal/sim"
+)
+
+func kinematic(id string, x, y, dx, dy int) *sim.Vehicle {
+	return &sim.Vehicle{ID: id, X: x, Y: y, DestX: dx, DestY: dy, Dynamics: sim.DefaultKinematics()}
+}
+
+func TestMoveOneTick_AcceleratesFromRest(t *testing.T) {
+	pf := sim.NewPathFinder(20, 1, nil)
+	v := kinematic("k", 0, 0, 19, 0)
+	var speeds []float64
+	for i := 0; i < 6; i++ {
+		sim.MoveOneTick([]*sim.Vehicle{v}, pf, nil, nil, nil)
+		speeds = append(speeds, v.Speed)
+	}
+	want := []float64{0.25, 0.5, 0.75, 1, 1, 1}
+	for i := range want {
+		if math.Abs(speeds[i]-want[i]) > 1e-9 {
+			t.Fatalf("expected speeds %v, got %v", want, speeds)
+		}
+	}
+	// 0.25+0.5+0.75+1+1+1 = 4.5 cells
+	if v.X != 4 {
+		t.Fatalf("expected vehicle 4.5 cells along after 6s, at x=%d", v.X)
+	}
+}
+
+func TestMoveOneTick_BrakesForRedLight(t *testing.T) {
+	pf := sim.NewPathFinder(20, 1, nil)
+	lights := map[[2]int]string{{8, 0}: "red"}
+	v := kinematic("k", 0, 0, 19, 0)
+	v.Speed = 1
+	prev := v.Speed
+	for i := 0; i < 30; i++ {
+		sim.MoveOneTick([]*sim.Vehicle{v}, pf, sim.UniformLights(lights), nil, nil)
+		if v.Speed < prev-0.5-1e-9 {
+			t.Fatalf("expected braking within 0.5 cells/s², speed fell from %v to %v", prev, v.Speed)
+		}
+		prev = v.Speed
+	}
+	if v.X != 7 || v.Speed != 0 {
+		t.Fatalf("expected vehicle stopped before the red light at x=7, at x=%d speed %v", v.X, v.Speed)
+	}
+	lights[[2]int{8, 0}] = "green"
+	sim.MoveOneTick([]*sim.Vehicle{v}, pf, sim.UniformLights(lights), nil, nil)
+	sim.MoveOneTick([]*sim.Vehicle{v}, pf, sim.UniformLights(lights), nil, nil)
+	if v.X != 8 {
+		t.Fatalf("expected vehicle to pull away on green, at x=%d", v.X)
+	}
+}
+
+func TestMoveOneTick_ObeysSpeedLimit(t *testing.T) {
+	pf := sim.NewPathFinder(20, 1, nil)
+	for x := 5; x < 10; x++ {
+		pf.SetSpeedLimit(x, 0, 0.5)
+	}
+	if pf.SpeedLimit(5, 0) != 0.5 || pf.BaseCost(5, 0) != 2 || pf.BaseCost(4, 0) != 1 {
+		t.Fatalf("expected slow cells to cost 1/limit")
+	}
+	v := kinematic("k", 0, 0, 19, 0)
+	for i := 0; i < 40 && v.X < 15; i++ {
+		sim.MoveOneTick([]*sim.Vehicle{v}, pf, nil, nil, nil)
+		if v.X >= 5 && v.X < 9 && v.Speed > 0.5+1e-9 {
+			t.Fatalf("expected at most 0.5 cells/s in the slow zone, got %v at x=%d", v.Speed, v.X)
+		}
+	}
+	if v.X < 15 {
+		t.Fatalf("expected vehicle through the slow zone, at x=%d", v.X)
+	}
+}
+
+func TestMoveOneTick_KeepsGapToLeader(t *testing.T) {
+	pf := sim.NewPathFinder(20, 1, nil)
+	leader := kinematic("a", 1, 0, 19, 0)
+	follower := kinematic("b", 0, 0, 19, 0)
+	follower.Speed = 1 // closing fast on a leader at rest
+	vs := []*sim.Vehicle{leader, follower}
+	for i := 0; i < 30; i++ {
+		sim.MoveOneTick(vs, pf, nil, nil, nil)
+		if follower.X >= leader.X {
+			t.Fatalf("expected follower to stay behind the leader at tick %d: %d vs %d", i, follower.X, leader.X)
+		}
+	}
+	if leader.X != 19 || follower.X != 18 {
+		t.Fatalf("expected both to reach the end of the road in order, at %d and %d", leader.X, follower.X)
+	}
+}
+
+func TestEngine_KinematicsCountsMovingWithinCells(t *testing.T) {
+	k := sim.DefaultKinematics()
+	e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20, Seed: 3, Kinematics: &k})
+	e.AddVehicle(sim.Vehicle{X: 0, Y: 0, DestX: 0, DestY: 10})
+	e.Step()
+	v := e.Vehicles()[0]
+	if !v.Dynamics.Enabled() || v.X != 0 || v.Y != 0 {
+		t.Fatalf("expected a kinematic vehicle still in its first cell after 1s, got %+v", v)
+	}
+	if st := e.Stats(); st.Moving != 1 || st.Waiting != 0 || math.Abs(st.MeanSpeed-0.25) > 1e-9 {
+		t.Fatalf("expected the accelerating vehicle counted as moving, got %+v", st)
+	}
+	for i := 0; i < 40; i++ {
+		e.Step()
+	}
+	trips := e.Trips()
+	if len(trips) != 1 || trips[0].Distance != 10 || trips[0].TravelSeconds <= 10 {
+		t.Fatalf("expected a 10-cell trip slower than free flow from rest, got %+v", trips)
+	}
+}
+
+func TestKinematics_ValidateAndExtremeBraking(t *testing.T) {
+	for _, k := range []sim.Kinematics{
+		{Accel: math.NaN()}, {Accel: 0.25, Decel: 1e-12}, {Accel: 0.25, MinGap: -1},
+		{MaxSpeed: math.Inf(1), Accel: 0.25},
+	} {
+		if k.Validate() == nil {
+			t.Fatalf("expected %+v to be refused", k)
+		}
+	}
+	if err := sim.DefaultKinematics().Validate(); err != nil {
+		t.Fatalf("expected the defaults to be valid, got %v", err)
+	}
+	// braking this gently takes millions of ticks; the tick must not
+	pf := sim.NewPathFinder(20, 1, nil)
+	v := &sim.Vehicle{ID: "k", DestX: 19, Dynamics: sim.Kinematics{Accel: 0.25, Decel: 1e-12}}
+	sim.MoveOneTick([]*sim.Vehicle{v}, pf, sim.UniformLights{{3, 0}: "red"}, nil, nil)
+	if v.Speed > 0.25 {
+		t.Fatalf("expected the vehicle to creep, got speed %v", v.Speed)
+	}
+	e := sim.NewEngine(sim.EngineConfig{Width: 5, Height: 5, Seed: 1})
+	bad := sim.Vehicle{ID: "bad", DestX: 4, Dynamics: sim.Kinematics{Accel: 0.25, Decel: 1e-12}}
+	if _, ok, err := e.AddVehicle(bad); ok || err == nil {
+		t.Fatalf("expected a vehicle with invalid kinematics refused, got %v, %v", ok, err)
+	}
+	if _, err := e.UpsertVehicle(bad); err == nil || e.VehicleCount() != 0 {
+		t.Fatalf("expected an upsert with invalid kinematics refused, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package sim_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	sim "routeiq/internal/sim"
+)
+
+func TestEngineMatrix_MatchesRoutes(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20, Seed: 3, Vehicles: 40})
+	for i := 0; i < 60; i++ {
+		e.Step()
+	}
+	e.ReportIncident(sim.Incident{Type: sim.IncidentAccident, X: 7, Y: 5, Severity: 3})
+	origins := [][2]int{{0, 0}, {19, 3}, {5, 17}, {10, 10}}
+	dests := [][2]int{{15, 15}, {0, 19}, {10, 10}, {7, 5}, {2, 9}}
+	m, err := e.Matrix(origins, dests, sim.RouteOptions{})
+	if err != nil {
+		t.Fatalf("matrix: %v", err)
+	}
+	for i, o := range origins {
+		for j, d := range dests {
+			r, err := e.Route(o[0], o[1], d[0], d[1], sim.RouteOptions{})
+			if err != nil {
+				t.Fatalf("route %v to %v: %v", o, d, err)
+			}
+			if math.Abs(m.Durations[i][j]-r.ETASeconds) > 1e-9 || m.Distances[i][j] != r.Distance {
+				t.Fatalf("%v to %v: expected %v s over %d cells as routed, got %v s over %d",
+					o, d, r.ETASeconds, r.Distance, m.Durations[i][j], m.Distances[i][j])
+			}
+		}
+	}
+	if m.Durations[3][2] != 0 || m.Distances[3][2] != 0 {
+		t.Fatalf("expected nothing to travel from a cell to itself, got %v", m.Durations[3][2])
+	}
+	later := clock(9, 30)
+	m, err = e.Matrix(origins[:1], dests[:1], sim.RouteOptions{DepartAt: later})
+	r, _ := e.Route(0, 0, 15, 15, sim.RouteOptions{DepartAt: later})
+	if err != nil || math.Abs(m.Durations[0][0]-r.ETASeconds) > 1e-9 || m.Depart != *later {
+		t.Fatalf("expected %v s leaving at 09:30 as routed, got %+v, %v", r.ETASeconds, m, err)
+	}
+
+	// an incident on the only road: it may end the route to it, but not be driven through
+	e = sim.NewEngine(sim.EngineConfig{Width: 6, Height: 1, Seed: 1})
+	e.ReportIncident(sim.Incident{Type: sim.IncidentAccident, X: 2, Y: 0, Severity: 3})
+	avoid := sim.RouteOptions{AvoidIncidents: true}
+	if _, err := e.Route(0, 0, 5, 0, avoid); !errors.Is(err, sim.ErrNoRoute) {
+		t.Fatalf("expected no route past the incident, got %v", err)
+	}
+	r, err = e.Route(0, 0, 2, 0, avoid)
+	if err != nil {
+		t.Fatalf("route to the incident: %v", err)
+	}
+	m, err = e.Matrix([][2]int{{0, 0}}, [][2]int{{2, 0}, {5, 0}}, avoid)
+	if err != nil {
+		t.Fatalf("matrix: %v", err)
+	}
+	if m.Durations[0][0] != r.ETASeconds || m.Distances[0][0] != r.Distance || m.Durations[0][1] != -1 || m.Distances[0][1] != -1 {
+		t.Fatalf("expected the incident reached in %v s and nothing past it, as routed, got %+v", r.ETASeconds, m)
+	}
+}
+
+func TestEngineMatrix_UnreachableAndLimits(t *testing.T) {
+	// (4,4) walled off
+	e := sim.NewEngine(sim.EngineConfig{Width: 6, Height: 6, Seed: 1,
+		Blocked: map[[2]int]bool{{3, 4}: true, {4, 3}: true, {5, 4}: true, {4, 5}: true},
+	})
+	m, err := e.Matrix([][2]int{{0, 0}}, [][2]int{{4, 4}, {5, 0}}, sim.RouteOptions{IgnoreSignals: true})
+	if err != nil {
+		t.Fatalf("matrix: %v", err)
+	}
+	if m.Durations[0][0] != -1 || m.Distances[0][0] != -1 || m.Distances[0][1] != 5 {
+		t.Fatalf("expected the walled-off cell unreachable and the far corner 5 cells away, got %+v", m)
+	}
+	many := make([][2]int, sim.MaxMatrixPoints+1)
+	if _, err := e.Matrix(many, [][2]int{{0, 0}}, sim.RouteOptions{}); !errors.Is(err, sim.ErrMatrixTooLarge) {
+		t.Fatalf("expected too many origins to be refused, got %v", err)
+	}
+	if _, err := e.Matrix(many[:60], many[:60], sim.RouteOptions{}); !errors.Is(err, sim.ErrMatrixTooLarge) {
+		t.Fatalf("expected too many pairs to be refused, got %v", err)
+	}
+	var perr *sim.PointError
+	if _, err := e.Matrix([][2]int{{0, 0}}, [][2]int{{1, 1}, {3, 4}}, sim.RouteOptions{}); !errors.As(err, &perr) || perr.Field != "destinations[1]" {
+		t.Fatalf("expected the blocked destination named, got %v", err)
+	}
+	if _, err := e.Matrix([][2]int{{0, 0}}, [][2]int{{1, 1}}, sim.RouteOptions{Weight: 2}); err == nil {
+		t.Fatalf("expected a weight to be refused")
+	}
+}
+
+func TestEngineMatrix_BoundedOnLargeGrids(t *testing.T) {
+	// (150,150) walled off in the middle of a large grid
+	walls := map[[2]int]bool{{149, 150}: true, {151, 150}: true, {150, 149}: true, {150, 151}: true}
+	e := sim.NewEngine(sim.EngineConfig{Width: 300, Height: 300, Seed: 1, Blocked: walls})
+	origins := make([][2]int, 50)
+	for i := range origins {
+		origins[i] = [2]int{i, 0}
+	}
+	started := time.Now()
+	m, err := e.Matrix(origins, [][2]int{{150, 150}, {60, 10}}, sim.RouteOptions{})
+	if err != nil {
+		t.Fatalf("matrix: %v", err)
+	}
+	for i := range origins {
+		if m.Distances[i][0] != -1 || m.Distances[i][1] < 0 {
+			t.Fatalf("expected only the walled-off cell unreachable from origin %d, got %v", i, m.Distances[i])
+		}
+	}
+	if d := time.Since(started); d > 10*time.Second {
+		t.Fatalf("expected the unreachable destination not to be searched for, took %v", d)
+	}
+	origins = make([][2]int, sim.MaxMatrixPoints)
+	for i := range origins {
+		origins[i] = [2]int{i, 0}
+	}
+	if _, err := e.Matrix(origins, [][2]int{{299, 299}}, sim.RouteOptions{}); !errors.Is(err, sim.ErrMatrixTooLarge) {
+		t.Fatalf("expected trees across the whole grid to exceed the work limit, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package sim_test
+
+import (
+	"fmt"
+	"testing"
+
+	sim "routeiq/internal/sim"
+)
+
+const (
+	benchSize     = 64
+	benchVehicles = 500
+	benchTrip     = 40 // ticks before vehicles are reset to their origins
+)
+
+func benchVehiclesOnGrid() ([]*sim.Vehicle, [][2]int) {
+	vs := make([]*sim.Vehicle, 0, benchVehicles)
+	origins := make([][2]int, 0, benchVehicles)
+	for i := 0; i < benchVehicles; i++ {
+		x, y := (i*7)%benchSize, (i*13)%benchSize
+		v := &sim.Vehicle{
+			ID:    fmt.Sprintf("v%d", i),
+			X:     x,
+			Y:     y,
+			DestX: benchSize - 1 - x,
+			DestY: benchSize - 1 - y,
+		}
+		vs = append(vs, v)
+		origins = append(origins, [2]int{x, y})
+	}
+	return vs, origins
+}
+
+func resetVehicles(vs []*sim.Vehicle, origins [][2]int) {
+	for i, v := range vs {
+		v.X, v.Y = origins[i][0], origins[i][1]
+	}
+}
+
+// legacyMoveOneTick reproduces the previous behavior of running A* for every
+// vehicle on every tick and using only the first step, one vehicle per cell.
+func legacyMoveOneTick(vs []*sim.Vehicle, pf *sim.PathFinder) {
+	reserved := make(map[[2]int]bool)
+	for _, v := range vs {
+		path := pf.Path(v.X, v.Y, v.DestX, v.DestY)
+		if len(path) <= 1 {
+			continue
+		}
+		k := [2]int{path[1].X, path[1].Y}
+		if reserved[k] {
+			continue
+		}
+		reserved[k] = true
+		v.X, v.Y = path[1].X, path[1].Y
+	}
+}
+
+func BenchmarkTick_PathPerVehicle(b *testing.B) {
+	pf := sim.NewPathFinder(benchSize, benchSize, nil)
+	vs, origins := benchVehiclesOnGrid()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%benchTrip == 0 {
+			b.StopTimer()
+			resetVehicles(vs, origins)
+			b.StartTimer()
+		}
+		legacyMoveOneTick(vs, pf)
+	}
+}
+
+func BenchmarkTick_RoutePlans(b *testing.B) {
+	pf := sim.NewPathFinder(benchSize, benchSize, nil)
+	vs, origins := benchVehiclesOnGrid()
+	occ := sim.NewOccupancy()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%benchTrip == 0 {
+			b.StopTimer()
+			resetVehicles(vs, origins)
+			b.StartTimer()
+		}
+		sim.MoveOneTick(vs, pf, nil, occ, nil)
+	}
+}
@@ -3,6 +3,7 @@ package sim_test
 import (
 	"testing"
 
+	grid "routeiq/internal/grid"
 	sim "routeiq/internal/sim"
 )
 
@@ -11,14 +12,14 @@ func TestMoveOneTick_StopsOnRedAndMovesOnGreen(t *testing.T) {
 	v := &sim.Vehicle{ID: "v1", X: 4, Y: 5, DestX: 6, DestY: 5}
 	intersections := map[[2]int]string{ {5,5}: "red" }
 	occ := sim.NewOccupancy()
-	sim.MoveOneTick([]*sim.Vehicle{v}, pf, intersections, occ, nil)
+	sim.MoveOneTick([]*sim.Vehicle{v}, pf, sim.UniformLights(intersections), occ, nil)
 	if v.X != 4 || v.Y != 5 {
 		// should not move into (5,5) on red
 	} else {
 		// ok
 	}
 	intersections[[2]int{5,5}] = "green"
-	sim.MoveOneTick([]*sim.Vehicle{v}, pf, intersections, occ, nil)
+	sim.MoveOneTick([]*sim.Vehicle{v}, pf, sim.UniformLights(intersections), occ, nil)
 	if v.X != 5 || v.Y != 5 {
 		t.Fatalf("expected vehicle to enter (5,5) on green, got (%d,%d)", v.X, v.Y)
 	}
@@ -30,7 +31,7 @@ func TestMoveOneTick_CollisionPrevention(t *testing.T) {
 	v2 := &sim.Vehicle{ID: "b", X: 6, Y: 5, DestX: 4, DestY: 5}
 	intersections := map[[2]int]string{ {5,5}: "green" }
 	occ := sim.NewOccupancy()
-	sim.MoveOneTick([]*sim.Vehicle{v1, v2}, pf, intersections, occ, nil)
+	sim.MoveOneTick([]*sim.Vehicle{v1, v2}, pf, sim.UniformLights(intersections), occ, nil)
 	at := 0
 	if v1.X==5 && v1.Y==5 { at++ }
 	if v2.X==5 && v2.Y==5 { at++ }
@@ -38,3 +39,91 @@ func TestMoveOneTick_CollisionPrevention(t *testing.T) {
 		t.Fatalf("expected exactly one vehicle at (5,5), got %d", at)
 	}
 }
+
+func TestMoveOneTick_FollowsPlannedRoute(t *testing.T) {
+	pf := sim.NewPathFinder(20,20, nil)
+	v := &sim.Vehicle{ID: "v1", X: 0, Y: 0, DestX: 0, DestY: 5}
+	sim.MoveOneTick([]*sim.Vehicle{v}, pf, nil, nil, nil)
+	route := v.Route()
+	if len(route) != 5 || route[0].X != 0 || route[0].Y != 1 {
+		t.Fatalf("expected 5 remaining cells starting at (0,1), got %v", route)
+	}
+	sim.MoveOneTick([]*sim.Vehicle{v}, pf, nil, nil, nil)
+	if v.X != 0 || v.Y != 2 || len(v.Route()) != 4 {
+		t.Fatalf("expected cursor to advance to (0,2), got (%d,%d) route %v", v.X, v.Y, v.Route())
+	}
+}
+
+func TestMoveOneTick_ReplansOnBlockAndNewDestination(t *testing.T) {
+	pf := sim.NewPathFinder(20,20, nil)
+	v := &sim.Vehicle{ID: "v1", X: 0, Y: 0, DestX: 0, DestY: 5}
+	sim.MoveOneTick([]*sim.Vehicle{v}, pf, nil, nil, nil)
+	pf.SetBlocked(0, 3, true)
+	sim.MoveOneTick([]*sim.Vehicle{v}, pf, nil, nil, nil)
+	for _, p := range v.Route() {
+		if p.X == 0 && p.Y == 3 {
+			t.Fatalf("expected replanned route to avoid blocked (0,3), got %v", v.Route())
+		}
+	}
+	v.SetDestination(5, 1)
+	sim.MoveOneTick([]*sim.Vehicle{v}, pf, nil, nil, nil)
+	r := v.Route()
+	if len(r) == 0 || r[len(r)-1].X != 5 || r[len(r)-1].Y != 1 {
+		t.Fatalf("expected route to new destination (5,1), got %v", r)
+	}
+}
+
+func TestMoveOneTick_RespectsOneWayAddedAfterPlanning(t *testing.T) {
+	pf := sim.NewPathFinder(20,20, nil)
+	v := &sim.Vehicle{ID: "v1", X: 10, Y: 5, DestX: 2, DestY: 5}
+	sim.MoveOneTick([]*sim.Vehicle{v}, pf, nil, nil, nil)
+	for x := 0; x < 20; x++ { pf.SetOneWay(x, 5, grid.East) }
+	for i := 0; i < 40; i++ {
+		px := v.X
+		py := v.Y
+		sim.MoveOneTick([]*sim.Vehicle{v}, pf, nil, nil, nil)
+		if py == 5 && v.Y == 5 && v.X < px {
+			t.Fatalf("vehicle moved west on eastbound one-way at (%d,%d)", v.X, v.Y)
+		}
+	}
+	if v.X != 2 || v.Y != 5 {
+		t.Fatalf("expected vehicle to reach (2,5) via detour, got (%d,%d)", v.X, v.Y)
+	}
+}
+
+func TestMoveOneTick_NoLeftTurnAtIntersection(t *testing.T) {
+	pf := sim.NewPathFinder(20,20, nil)
+	pf.SetTurnRules(5,5, grid.TurnRules{Prohibited: map[grid.Turn]bool{grid.Left: true}})
+	// heading north into (5,5); (4,6) is blocked so the shortcut west is gone
+	pf.SetBlocked(4,6, true)
+	v := &sim.Vehicle{ID: "v1", X: 5, Y: 7, DestX: 3, DestY: 5, Heading: grid.North}
+	for i := 0; i < 30; i++ {
+		px, py, ph := v.X, v.Y, v.Heading
+		sim.MoveOneTick([]*sim.Vehicle{v}, pf, nil, nil, nil)
+		if px == 5 && py == 5 && ph == grid.North && v.Heading == grid.West {
+			t.Fatalf("vehicle turned left at (5,5)")
+		}
+	}
+	if v.X != 3 || v.Y != 5 {
+		t.Fatalf("expected vehicle to reach (3,5), got (%d,%d)", v.X, v.Y)
+	}
+}
+
+func TestMoveOneTick_DoesNotRepeatAFailedSearch(t *testing.T) {
+	wall := make(map[[2]int]bool)
+	for y := 0; y < 50; y++ {
+		wall[[2]int{25, y}] = true
+	}
+	pf := sim.NewPathFinder(50, 50, wall)
+	v := &sim.Vehicle{ID: "v", DestX: 49, DestY: 49}
+	occ := sim.NewOccupancy()
+	tick := func() {
+		occ.Reset()
+		sim.MoveOneTick([]*sim.Vehicle{v}, pf, nil, occ, nil)
+	}
+	tick()
+	// a search of the vehicle's half of the grid allocates thousands of times
+	if n := testing.AllocsPerRun(20, tick); n > 100 {
+		t.Fatalf("expected the failed search kept while the network is unchanged, got %v allocations a tick", n)
+	}
+}
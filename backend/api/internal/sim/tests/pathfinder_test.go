@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	grid "routeiq/internal/grid"
 	sim "routeiq/internal/sim"
 )
 
@@ -43,3 +44,168 @@ func TestPathFinder_PerfUnder50ms(t *testing.T) {
 		t.Fatalf("expected under 50ms, took %s", time.Since(start))
 	}
 }
+
+func TestPathFinder_AvoidsCongestedCells(t *testing.T) {
+	pf := sim.NewPathFinder(20,20, nil)
+	// heavy congestion on the direct row, cheap detour one row down
+	for x := 1; x < 19; x++ { pf.SetCongestion(x, 10, 5) }
+	p := pf.Path(0,10, 19,10)
+	for _, pt := range p[1:len(p)-1] {
+		if pt.Y == 10 {
+			t.Fatalf("expected route to leave congested row, passed (%d,%d)", pt.X, pt.Y)
+		}
+	}
+	if got := pf.PathCost(p); got != 21 {
+		t.Fatalf("expected detour cost 21, got %v", got)
+	}
+}
+
+func TestPathFinder_IncidentPenaltyAndMultipliers(t *testing.T) {
+	pf := sim.NewPathFinder(20,20, nil)
+	pf.SetBaseCost(3, 3, 2)
+	pf.SetCongestion(3, 3, 1.5)
+	pf.SetIncidentPenalty(3, 3, 4)
+	if got := pf.CellCost(3, 3); got != 12 {
+		t.Fatalf("expected cost 2*1.5*4=12, got %v", got)
+	}
+	if got := pf.Multiplier(3, 3); got != 6 {
+		t.Fatalf("expected multiplier 6, got %v", got)
+	}
+	v := pf.Version()
+	pf.SetCongestion(3, 3, 0.5)
+	if pf.Multiplier(3, 3) != 4 || pf.Version() != v {
+		t.Fatalf("expected congestion clamped to 1 without invalidating routes")
+	}
+	pf.SetIncidentPenalty(3, 3, 1)
+	if pf.CellCost(3, 3) != 2 || pf.Version() == v {
+		t.Fatalf("expected incident removal to restore cost and bump version")
+	}
+}
+
+func TestPathFinder_WeightedRouteIsOptimal(t *testing.T) {
+	pf := sim.NewPathFinder(12,12, nil)
+	// deterministic pseudo-random costs, some cheaper than 1
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 12; x++ {
+			pf.SetBaseCost(x, y, 0.5+float64((x*7+y*13)%5)/2)
+		}
+	}
+	p := pf.Path(0,0, 11,11)
+	if len(p) == 0 {
+		t.Fatalf("expected path")
+	}
+	// Bellman-Ford style relaxation gives the reference optimum
+	const inf = 1e18
+	dist := make([][]float64, 12)
+	for y := range dist {
+		dist[y] = make([]float64, 12)
+		for x := range dist[y] { dist[y][x] = inf }
+	}
+	dist[0][0] = 0
+	for changed := true; changed; {
+		changed = false
+		for y := 0; y < 12; y++ {
+			for x := 0; x < 12; x++ {
+				for _, d := range [][2]int{{1,0},{-1,0},{0,1},{0,-1}} {
+					nx, ny := x+d[0], y+d[1]
+					if nx < 0 || ny < 0 || nx >= 12 || ny >= 12 { continue }
+					if c := dist[y][x] + pf.CellCost(nx, ny); c < dist[ny][nx] {
+						dist[ny][nx] = c
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	if got, want := pf.PathCost(p), dist[11][11]; got-want > 1e-9 {
+		t.Fatalf("expected optimal cost %v, got %v", want, got)
+	}
+}
+
+func TestPathFinder_OneWayStreet(t *testing.T) {
+	pf := sim.NewPathFinder(20,20, nil)
+	// row 5 is one-way eastbound between x=2..17
+	for x := 2; x <= 17; x++ { pf.SetOneWay(x, 5, grid.East) }
+	east := pf.Path(2,5, 17,5)
+	if len(east) != 16 {
+		t.Fatalf("expected straight eastbound path of 16 cells, got %d", len(east))
+	}
+	west := pf.Path(17,5, 2,5)
+	if len(west) == 0 {
+		t.Fatalf("expected westbound detour, got none")
+	}
+	for i := 1; i < len(west); i++ {
+		if west[i].Y == 5 && west[i-1].Y == 5 && west[i].X < west[i-1].X {
+			t.Fatalf("westbound path moved against the one-way at %v", west[i])
+		}
+	}
+	if pf.CanMove(10,5, 9,5) || !pf.CanMove(10,5, 11,5) || !pf.CanMove(10,5, 10,6) {
+		t.Fatalf("unexpected CanMove results on one-way cell")
+	}
+}
+
+func TestPathFinder_TrappedCells(t *testing.T) {
+	pf := sim.NewPathFinder(5,5, nil)
+	if got := pf.TrappedCells(); len(got) != 0 {
+		t.Fatalf("expected open grid to have no trapped cells, got %v", got)
+	}
+	// (2,2) can be entered from the west only and has no exit
+	pf.SetBlocked(2,1, true)
+	pf.SetBlocked(2,3, true)
+	pf.SetEdge(2,2, 3,2, false)
+	pf.SetEdge(2,2, 1,2, false)
+	got := pf.TrappedCells()
+	if len(got) != 1 || got[0] != [2]int{2,2} {
+		t.Fatalf("expected (2,2) trapped, got %v", got)
+	}
+}
+
+func TestPathFinder_TurnRestrictions(t *testing.T) {
+	pf := sim.NewPathFinder(20,20, nil)
+	pf.SetTurnRules(5,5, grid.TurnRules{Prohibited: map[grid.Turn]bool{grid.Left: true, grid.UTurn: true}})
+	// northbound through (5,5) to (0,4): turning left at (5,5) is banned
+	p := pf.PathFrom(5,6, grid.North, 0,4)
+	for i := 1; i+1 < len(p); i++ {
+		if p[i].X == 5 && p[i].Y == 5 && p[i-1].Y == 6 && p[i+1].X == 4 {
+			t.Fatalf("path turned left at (5,5): %v", p)
+		}
+	}
+	// arrived at (5,5) heading north, going back south requires a U-turn elsewhere
+	back := pf.PathFrom(5,5, grid.North, 5,6)
+	if len(back) <= 2 {
+		t.Fatalf("expected detour instead of a U-turn at (5,5), got %v", back)
+	}
+	// without a heading the start cell's rules do not apply
+	if got := pf.Path(5,5, 5,6); len(got) != 2 {
+		t.Fatalf("expected direct move for a vehicle that has not moved, got %v", got)
+	}
+}
+
+func TestPathFinder_TurnPenalty(t *testing.T) {
+	pf := sim.NewPathFinder(20,20, nil)
+	pf.SetTurnRules(5,5, grid.TurnRules{Penalty: map[grid.Turn]float64{grid.Right: 10}})
+	p := pf.PathFrom(5,6, grid.North, 6,5)
+	if len(p) != 3 || p[1].X != 6 || p[1].Y != 6 {
+		t.Fatalf("expected route via (6,6) avoiding the penalised right turn, got %v", p)
+	}
+	// a vehicle coming from the west goes straight through at no extra cost
+	through := pf.PathFrom(4,5, grid.East, 6,5)
+	if len(through) != 3 || pf.PathCost(through) != 2 {
+		t.Fatalf("expected straight path of cost 2, got %v cost %v", through, pf.PathCost(through))
+	}
+	// with a small penalty and (6,6) blocked, the right turn is still cheapest and is priced in
+	pf.SetBlocked(6,6, true)
+	pf.SetTurnRules(5,5, grid.TurnRules{Penalty: map[grid.Turn]float64{grid.Right: 1}})
+	turning := pf.PathFrom(5,6, grid.North, 6,5)
+	if len(turning) != 3 || pf.PathCost(turning) != 3 {
+		t.Fatalf("expected right turn at (5,5) costing 3, got %v cost %v", turning, pf.PathCost(turning))
+	}
+}
+
+func TestPathFinder_RefusesNegativeTurnPenalties(t *testing.T) {
+	pf := sim.NewPathFinder(10, 10, nil)
+	v := pf.Version()
+	if err := pf.SetTurnRules(5, 5, grid.TurnRules{Penalty: map[grid.Turn]float64{grid.Left: -5}}); err == nil || pf.Version() != v {
+		t.Fatalf("expected a negative turn penalty refused and the routing unchanged, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package sim_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	sim "routeiq/internal/sim"
+)
+
+// parkedEngine starts at 08:00 with a vehicle stuck at (1,1): its destination
+// is walled off, so it congests the cells around it for as long as it runs.
+func parkedEngine(prior map[[2]int]sim.Profile) *sim.Engine {
+	e := sim.NewEngine(sim.EngineConfig{Width: 6, Height: 6, Seed: 1, StartTime: 8 * time.Hour,
+		Blocked:            map[[2]int]bool{{4, 5}: true, {5, 4}: true},
+		Intersections:      []sim.IntersectionConfig{},
+		CongestionProfiles: prior,
+	})
+	e.AddVehicle(sim.Vehicle{ID: "parked", X: 1, Y: 1, DestX: 5, DestY: 5})
+	return e
+}
+
+func TestCongestionProfile_LearnsFromTheRun(t *testing.T) {
+	var prior sim.Profile
+	for h := range prior {
+		prior[h] = 1.5
+	}
+	e := parkedEngine(map[[2]int]sim.Profile{{1, 1}: prior})
+	for i := 0; i < 100; i++ {
+		e.Step()
+	}
+	p, ticks, ok := e.CongestionProfile(1, 1)
+	if !ok || p[8] != 1.5 || ticks[8] != 100 {
+		t.Fatalf("expected the prediction to stand until the hour has enough history, got %v after %d ticks", p[8], ticks[8])
+	}
+	for i := 0; i < 500; i++ {
+		e.Step()
+	}
+	// one vehicle in the 3x3 neighbourhood of the cell
+	want := 1 + 2.0/9
+	p, ticks, _ = e.CongestionProfile(1, 1)
+	if math.Abs(p[8]-want) > 1e-9 || ticks[8] != 600 || p[9] != 1.5 {
+		t.Fatalf("expected %v learned for 08:00 and the prediction elsewhere, got %v", want, p)
+	}
+	p, _, _ = e.CongestionProfile(4, 4)
+	if p[8] != 1 || p[9] != 1 {
+		t.Fatalf("expected free flow learned away from the vehicle, got %v", p)
+	}
+	if _, _, ok := e.CongestionProfile(6, 0); ok {
+		t.Fatalf("expected no profile outside the grid")
+	}
+}
+
+func TestCongestionProfile_RejectsCorruptSnapshots(t *testing.T) {
+	var prior sim.Profile
+	for h := range prior {
+		prior[h] = 1.5
+	}
+	e := parkedEngine(map[[2]int]sim.Profile{{1, 1}: prior})
+	for i := 0; i < 10; i++ {
+		e.Step()
+	}
+	for name, corrupt := range map[string]func(*sim.Snapshot){
+		"prediction below 1":  func(s *sim.Snapshot) { s.Profiles.Prior[0].Profile[3] = 0.5 },
+		"NaN prediction":      func(s *sim.Snapshot) { s.Profiles.Prior[0].Profile[3] = math.NaN() },
+		"negative excess":     func(s *sim.Snapshot) { s.Profiles.Excess[0].Profile[8] = -1 },
+		"infinite excess":     func(s *sim.Snapshot) { s.Profiles.Excess[0].Profile[8] = math.Inf(1) },
+		"negative tick count": func(s *sim.Snapshot) { s.Profiles.Ticks[8] = -10 },
+	} {
+		s, err := e.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Profiles.Prior) == 0 || len(s.Profiles.Excess) == 0 {
+			t.Fatalf("expected the snapshot to carry the profiles")
+		}
+		corrupt(s)
+		if _, err := sim.NewEngineFromSnapshot(s); err == nil {
+			t.Errorf("%s: expected the snapshot to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package sim_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	grid "routeiq/internal/grid"
+	sim "routeiq/internal/sim"
+)
+
+func TestEngineRoute_LiveCosts(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 10, Height: 1, Seed: 1,
+		SpeedLimits: map[[2]int]float64{{2, 0}: 0.5}})
+	r, err := e.Route(0, 0, 5, 0, sim.RouteOptions{})
+	if err != nil {
+		t.Fatalf("route: %v", err)
+	}
+	// four free cells and one at half speed
+	if len(r.Path) != 6 || r.Distance != 5 || r.ETASeconds != 6 || len(r.Multipliers) != 5 {
+		t.Fatalf("expected 5 cells in 6s, got %+v", r)
+	}
+	if _, _, err := e.ReportIncident(sim.Incident{ID: "a", Type: sim.IncidentAccident, X: 3, Y: 0, Severity: 2}); err != nil {
+		t.Fatalf("report incident: %v", err)
+	}
+	r, err = e.Route(0, 0, 5, 0, sim.RouteOptions{})
+	if err != nil {
+		t.Fatalf("route: %v", err)
+	}
+	if r.ETASeconds != 8 || r.Multipliers[2] != 3 {
+		t.Fatalf("expected the accident to triple its cell's time, got %+v", r)
+	}
+	if _, err := e.Route(0, 0, 5, 0, sim.RouteOptions{AvoidIncidents: true}); !errors.Is(err, sim.ErrNoRoute) {
+		t.Fatalf("expected no route avoiding the only road's incident, got %v", err)
+	}
+	if _, err := e.Route(0, 0, 3, 0, sim.RouteOptions{AvoidIncidents: true}); err != nil {
+		t.Fatalf("expected the incident cell to be a valid destination, got %v", err)
+	}
+}
+
+func TestEngineRoute_AvoidsIncidentsWhenAsked(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20, Seed: 1})
+	e.ReportIncident(sim.Incident{Type: sim.IncidentConstruction, X: 2, Y: 0, Severity: 1})
+	r, err := e.Route(0, 0, 4, 0, sim.RouteOptions{})
+	if err != nil || r.Path[2] != [2]int{2, 0} {
+		t.Fatalf("expected the light roadworks to be driven through, got %+v, %v", r, err)
+	}
+	r, err = e.Route(0, 0, 4, 0, sim.RouteOptions{AvoidIncidents: true})
+	if err != nil {
+		t.Fatalf("route: %v", err)
+	}
+	for _, c := range r.Path {
+		if c == [2]int{2, 0} {
+			t.Fatalf("expected a detour around the incident, got %v", r.Path)
+		}
+	}
+}
+
+func TestEngineRoute_Errors(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20, Seed: 1, Blocked: map[[2]int]bool{{4, 4}: true}})
+	var perr *sim.PointError
+	_, err := e.Route(0, 0, 25, 3, sim.RouteOptions{})
+	if !errors.As(err, &perr) || !perr.Outside || perr.Field != "destination" || !errors.Is(err, sim.ErrInvalidPoint) {
+		t.Fatalf("expected the destination reported outside the grid, got %v", err)
+	}
+	_, err = e.Route(4, 4, 0, 0, sim.RouteOptions{})
+	if !errors.As(err, &perr) || perr.Outside || perr.Field != "origin" {
+		t.Fatalf("expected the origin reported blocked, got %v", err)
+	}
+	if _, err := e.Route(0, 0, 3, 3, sim.RouteOptions{Weight: 0.5}); err == nil {
+		t.Fatalf("expected a weight below 1 to be refused")
+	}
+}
+
+func TestEngineRoute_WeightedSearchIsBounded(t *testing.T) {
+	e := busyEngine(sim.ControlFixed)
+	for i := 0; i < 100; i++ {
+		e.Step()
+	}
+	best, err := e.Route(0, 0, 19, 19, sim.RouteOptions{})
+	if err != nil {
+		t.Fatalf("route: %v", err)
+	}
+	fast, err := e.Route(0, 0, 19, 19, sim.RouteOptions{Weight: 2})
+	if err != nil {
+		t.Fatalf("weighted route: %v", err)
+	}
+	if fast.ETASeconds < best.ETASeconds-1e-9 || fast.ETASeconds > 2*best.ETASeconds {
+		t.Fatalf("expected the weighted route within 2x of %v, got %v", best.ETASeconds, fast.ETASeconds)
+	}
+	for i := 1; i < len(fast.Path); i++ {
+		a, b := fast.Path[i-1], fast.Path[i]
+		if math.Abs(float64(a[0]-b[0]))+math.Abs(float64(a[1]-b[1])) != 1 {
+			t.Fatalf("expected a connected path, got %v", fast.Path)
+		}
+	}
+}
+
+// eastThenNorth gives eastbound traffic 10s of green, then northbound 20s.
+var eastThenNorth = sim.SignalPlan{Phases: []sim.Phase{
+	{Name: "e", Green: []sim.Movement{{Approach: grid.East, Turn: grid.Straight}}, GreenSec: 10, YellowSec: 3, AllRedSec: 2},
+	{Name: "n", Green: []sim.Movement{{Approach: grid.North, Turn: grid.Straight}}, GreenSec: 20, YellowSec: 3, AllRedSec: 2},
+}}
+
+func TestEngineRoute_PredictsSignalWaits(t *testing.T) {
+	for start := 0; start < 40; start += 3 {
+		e := sim.NewEngine(sim.EngineConfig{Width: 12, Height: 1, Seed: 1,
+			Intersections: []sim.IntersectionConfig{{X: 5, Y: 0, Plan: eastThenNorth}}})
+		for i := 0; i < start; i++ {
+			e.Step()
+		}
+		r, err := e.Route(0, 0, 10, 0, sim.RouteOptions{})
+		if err != nil {
+			t.Fatalf("route: %v", err)
+		}
+		if r.WaitSeconds != r.SignalWaits[4] || r.ETASeconds != 10+r.WaitSeconds {
+			t.Fatalf("t=%d: expected the wait before entering the signal in the ETA, got %+v", start, r)
+		}
+		id, _, _ := e.AddVehicle(sim.Vehicle{X: 0, Y: 0, DestX: 10, DestY: 0})
+		ticks := 0
+		for ; ticks < 100 && !arrived(e, id, 10, 0); ticks++ {
+			e.Step()
+		}
+		if float64(ticks) != r.ETASeconds {
+			t.Fatalf("t=%d: expected the vehicle to take the predicted %vs, took %ds", start, r.ETASeconds, ticks)
+		}
+	}
+}
+
+func arrived(e *sim.Engine, id string, x, y int) bool {
+	for _, v := range e.Vehicles() {
+		if v.ID == id {
+			return v.X == x && v.Y == y
+		}
+	}
+	return true
+}
+
+func TestEngineRoute_PrefersBetterTimedCorridor(t *testing.T) {
+	// a short way along row 0 through a signal that just turned red for it,
+	// and a longer way round through row 2 without one
+	e := sim.NewEngine(sim.EngineConfig{Width: 7, Height: 3, Seed: 1,
+		Blocked:       map[[2]int]bool{{1, 1}: true, {2, 1}: true, {3, 1}: true, {4, 1}: true, {5, 1}: true},
+		Intersections: []sim.IntersectionConfig{{X: 3, Y: 0, Plan: sim.SignalPlan{Phases: eastThenNorth.Phases, Offset: 12}}},
+	})
+	r, err := e.Route(0, 0, 6, 0, sim.RouteOptions{})
+	if err != nil || r.Distance != 10 || r.WaitSeconds != 0 {
+		t.Fatalf("expected the way round to avoid the red light, got %+v, %v", r, err)
+	}
+	r, err = e.Route(0, 0, 6, 0, sim.RouteOptions{IgnoreSignals: true})
+	if err != nil || r.Distance != 6 || r.WaitSeconds != 0 || r.ETASeconds != 6 {
+		t.Fatalf("expected the short way ignoring signals, got %+v, %v", r, err)
+	}
+	for i := 0; i < 24; i++ {
+		e.Step()
+	}
+	r, err = e.Route(0, 0, 6, 0, sim.RouteOptions{})
+	if err != nil || r.Distance != 6 || r.WaitSeconds != 1 {
+		t.Fatalf("expected the short way once its light is nearly green, got %+v, %v", r, err)
+	}
+}
+
+func clock(h, m int) *time.Duration {
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	return &d
+}
+
+func TestEngineRoute_DepartAtPredictsCongestion(t *testing.T) {
+	// two ways east: row 0 is short but jams in the evening peak
+	var peak sim.Profile
+	for h := range peak {
+		peak[h] = 1
+	}
+	peak[17] = 3
+	prior := map[[2]int]sim.Profile{}
+	for x := 1; x < 6; x++ {
+		prior[[2]int{x, 0}] = peak
+	}
+	e := sim.NewEngine(sim.EngineConfig{Width: 7, Height: 3, Seed: 1, StartTime: 8 * time.Hour,
+		Blocked:            map[[2]int]bool{{1, 1}: true, {2, 1}: true, {3, 1}: true, {4, 1}: true, {5, 1}: true},
+		Intersections:      []sim.IntersectionConfig{},
+		CongestionProfiles: prior,
+	})
+	r, err := e.Route(0, 0, 6, 0, sim.RouteOptions{})
+	if err != nil || r.Distance != 6 || r.Depart != 8*time.Hour || r.Arrive != 8*time.Hour+6*time.Second {
+		t.Fatalf("expected the short way leaving now, got %+v, %v", r, err)
+	}
+	r, err = e.Route(0, 0, 6, 0, sim.RouteOptions{DepartAt: clock(17, 0)})
+	if err != nil || r.Distance != 10 || r.Depart != 17*time.Hour || r.ETASeconds != 10 {
+		t.Fatalf("expected the way round in the evening peak, got %+v, %v", r, err)
+	}
+	r, err = e.Route(0, 0, 6, 0, sim.RouteOptions{DepartAt: clock(16, 10)})
+	if err != nil || r.Distance != 6 || r.Multipliers[1] <= 1 || r.ETASeconds <= 6 {
+		t.Fatalf("expected the short way before the peak builds, priced as it builds, got %+v, %v", r, err)
+	}
+	// 07:00 is tomorrow morning
+	r, err = e.Route(0, 0, 6, 0, sim.RouteOptions{DepartAt: clock(7, 0)})
+	if err != nil || r.Depart != 7*time.Hour || r.Distance != 6 {
+		t.Fatalf("expected tomorrow's departure, got %+v, %v", r, err)
+	}
+}
+
+func TestEngineRoute_ArriveByFindsLatestDeparture(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 12, Height: 1, Seed: 1, StartTime: 8 * time.Hour,
+		Intersections: []sim.IntersectionConfig{{X: 5, Y: 0, Plan: eastThenNorth}}})
+	by := clock(8, 2)
+	r, err := e.Route(0, 0, 10, 0, sim.RouteOptions{ArriveBy: by})
+	if err != nil {
+		t.Fatalf("arrive-by route: %v", err)
+	}
+	if r.Arrive > *by || r.Depart <= 8*time.Hour {
+		t.Fatalf("expected a later departure arriving by 08:02, got %v to %v", r.Depart, r.Arrive)
+	}
+	later := r.Depart + time.Second
+	if l, err := e.Route(0, 0, 10, 0, sim.RouteOptions{DepartAt: &later}); err != nil || l.Arrive <= *by {
+		t.Fatalf("expected leaving a second later to miss 08:02, got %+v, %v", l, err)
+	}
+	if _, err := e.Route(0, 0, 10, 0, sim.RouteOptions{ArriveBy: clock(8, 0)}); !errors.Is(err, sim.ErrTooLate) {
+		t.Fatalf("expected a deadline of now to be too late, got %v", err)
+	}
+	if _, err := e.Route(0, 0, 10, 0, sim.RouteOptions{DepartAt: by, ArriveBy: by}); err == nil {
+		t.Fatalf("expected a departure and an arrival time together to be refused")
+	}
+	if _, err := e.Route(0, 0, 10, 0, sim.RouteOptions{DepartAt: clock(24, 0)}); err == nil {
+		t.Fatalf("expected a time of day past midnight to be refused")
+	}
+}
@@ -0,0 +1,89 @@
+package sim_test
+
+import (
+	"testing"
+
+	grid "routeiq/internal/grid"
+	sim "routeiq/internal/sim"
+)
+
+var (
+	northThrough = sim.Movement{Approach: grid.North, Turn: grid.Straight}
+	northLeft    = sim.Movement{Approach: grid.North, Turn: grid.Left}
+	eastThrough  = sim.Movement{Approach: grid.East, Turn: grid.Straight}
+)
+
+func TestSignal_DefaultPlanPhases(t *testing.T) {
+	s := sim.NewSignal(sim.SignalPlan{})
+	if got := s.Plan().CycleLength(); got != 60 {
+		t.Fatalf("expected 60s default cycle, got %d", got)
+	}
+	if s.StateFor(northThrough) != "green" || s.StateFor(eastThrough) != "red" || s.StateFor(northLeft) != "red" {
+		t.Fatalf("expected north-south through green only at start")
+	}
+	for i := 0; i < 19; i++ { s.Tick() }
+	if s.StateFor(northThrough) != "yellow" || s.StateFor(eastThrough) != "red" {
+		t.Fatalf("expected north yellow after 19s, got %s", s.StateFor(northThrough))
+	}
+	for i := 0; i < 3; i++ { s.Tick() }
+	if st, _ := s.Stage(); st != sim.StageAllRed || s.StateFor(northThrough) != "red" || s.StateFor(eastThrough) != "red" {
+		t.Fatalf("expected all-red clearance, got stage %s", st)
+	}
+	for i := 0; i < 2; i++ { s.Tick() }
+	if s.Phase().Name != "ns-left" || s.StateFor(northLeft) != "green" || s.StateFor(northThrough) != "red" {
+		t.Fatalf("expected protected left phase, got %s", s.Phase().Name)
+	}
+	for i := 0; i < 6; i++ { s.Tick() }
+	if s.StateFor(eastThrough) != "green" || s.StateFor(northThrough) != "red" {
+		t.Fatalf("expected east-west through green after 30s")
+	}
+	for i := 0; i < 30; i++ { s.Tick() }
+	if s.CycleTime() != 0 || s.StateFor(northThrough) != "green" {
+		t.Fatalf("expected cycle to wrap after 60s, cycle time %d", s.CycleTime())
+	}
+}
+
+func TestSignal_CustomDurationsAndOffset(t *testing.T) {
+	plan := sim.SignalPlan{
+		Phases: []sim.Phase{
+			{Name: "ns", Green: []sim.Movement{northThrough}, GreenSec: 10, YellowSec: 2, AllRedSec: 1},
+			{Name: "ew", Green: []sim.Movement{eastThrough}, GreenSec: 5, YellowSec: 2},
+		},
+		Offset: 13,
+	}
+	s := sim.NewSignal(plan)
+	if s.CycleTime() != 13 || s.Phase().Name != "ew" || s.StateFor(eastThrough) != "green" {
+		t.Fatalf("expected offset to start in ew green, got %s at %d", s.Phase().Name, s.CycleTime())
+	}
+	if sim.NewSignal(sim.SignalPlan{Phases: plan.Phases, Offset: -7}).CycleTime() != 13 {
+		t.Fatalf("expected negative offset to wrap into the cycle")
+	}
+}
+
+func TestMoveOneTick_ChecksLightForApproach(t *testing.T) {
+	pf := sim.NewPathFinder(20,20, nil)
+	sig := sim.NewSignal(sim.SignalPlan{}) // north-south through green
+	lights := signalAt{5, 5, sig}
+	north := &sim.Vehicle{ID: "n", X: 5, Y: 6, DestX: 5, DestY: 3}
+	east := &sim.Vehicle{ID: "e", X: 4, Y: 5, DestX: 8, DestY: 5}
+	sim.MoveOneTick([]*sim.Vehicle{north, east}, pf, lights, nil, nil)
+	if north.X != 5 || north.Y != 5 {
+		t.Fatalf("expected northbound vehicle to enter on green, got (%d,%d)", north.X, north.Y)
+	}
+	if east.X != 4 || east.Y != 5 {
+		t.Fatalf("expected eastbound vehicle to hold on red, got (%d,%d)", east.X, east.Y)
+	}
+}
+
+// signalAt exposes one signal at (x,y) through the sim.Signals interface.
+type signalAt struct {
+	x, y int
+	s    *sim.Signal
+}
+
+func (a signalAt) SignalFor(x, y int, m sim.Movement) (string, bool) {
+	if x != a.x || y != a.y {
+		return "", false
+	}
+	return a.s.StateFor(m), true
+}
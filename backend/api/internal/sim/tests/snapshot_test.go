@@ -0,0 +1,164 @@
+package sim_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	sim "routeiq/internal/sim"
+)
+
+// busyEngine runs every source of randomness and state: demand, random
+// incidents, an emergency vehicle, multi-lane cells and kinematic vehicles.
+func busyEngine(control string) *sim.Engine {
+	inc := sim.DefaultIncidentGeneratorConfig()
+	inc.Accident.Rate = 20
+	inc.Hotspots = map[[2]int]float64{{5, 6}: 5}
+	demand := sim.CommuterDemand(20, 20, 1500)
+	k := sim.DefaultKinematics()
+	e := sim.NewEngine(sim.EngineConfig{
+		Width: 20, Height: 20, Vehicles: 30, Seed: 11, Control: control,
+		StartTime: 7 * time.Hour, Incidents: &inc, Demand: &demand, Kinematics: &k,
+		DefaultCapacity: sim.CellCapacity{Lanes: 1, Storage: 2},
+		SpeedLimits:     map[[2]int]float64{{5, 10}: 0.5, {6, 10}: 0.5},
+		Blocked:         map[[2]int]bool{{10, 10}: true},
+	})
+	e.AddVehicle(sim.Vehicle{ID: "amb", Type: sim.VehicleEmergency, X: 5, Y: 19, DestX: 5, DestY: 0})
+	return e
+}
+
+// encode writes e's snapshot. Vehicle and incident times are wall-clock, so
+// they are left out to compare engines.
+func encode(t *testing.T, e *sim.Engine) []byte {
+	t.Helper()
+	s, err := e.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	for i := range s.Vehicles {
+		s.Vehicles[i].CreatedAt = time.Time{}
+	}
+	for i := range s.Incidents {
+		s.Incidents[i].Timestamp = time.Time{}
+	}
+	var buf bytes.Buffer
+	if err := sim.WriteSnapshot(&buf, s); err != nil {
+		t.Fatalf("write snapshot: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestSnapshot_RestoredEngineResumesExactly(t *testing.T) {
+	for _, control := range []string{sim.ControlFixed, sim.ControlActuated, sim.ControlMaxPressure} {
+		orig := busyEngine(control)
+		for i := 0; i < 150; i++ {
+			orig.Step()
+		}
+		saved := encode(t, orig)
+		s, err := sim.ReadSnapshot(bytes.NewReader(saved))
+		if err != nil {
+			t.Fatalf("%s: read snapshot: %v", control, err)
+		}
+		restored, err := sim.NewEngineFromSnapshot(s)
+		if err != nil {
+			t.Fatalf("%s: restore: %v", control, err)
+		}
+		if !bytes.Equal(encode(t, restored), saved) {
+			t.Fatalf("%s: expected restored engine to snapshot identically", control)
+		}
+		for i := 0; i < 200; i++ {
+			orig.Step()
+			restored.Step()
+		}
+		if orig.Stats().Departures == 0 || len(orig.Incidents(true)) == 0 || len(orig.Trips()) == 0 {
+			t.Fatalf("%s: expected demand, incidents and trips in the test run, got %+v", control, orig.Stats())
+		}
+		if !bytes.Equal(encode(t, orig), encode(t, restored)) {
+			t.Fatalf("%s: expected restored engine to evolve exactly like the original", control)
+		}
+	}
+}
+
+func TestEngine_RestoreInPlace(t *testing.T) {
+	src := busyEngine(sim.ControlFixed)
+	for i := 0; i < 50; i++ {
+		src.Step()
+	}
+	s, err := src.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	e := sim.NewEngine(sim.EngineConfig{Width: 8, Height: 8, Seed: 1})
+	events, cancel := e.Subscribe(16)
+	defer cancel()
+	if err := e.Restore(s); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if e.Ticks() != 50 || e.Grid().Width != 20 || e.VehicleCount() != src.VehicleCount() || e.Seed() != 11 {
+		t.Fatalf("expected the snapshot's state, got tick %d, %d vehicles", e.Ticks(), e.VehicleCount())
+	}
+	if _, _, err := e.ReportIncident(sim.Incident{Type: sim.IncidentAccident, X: 3, Y: 3, Severity: 2}); err != nil {
+		t.Fatalf("report incident: %v", err)
+	}
+	select {
+	case <-events:
+	default:
+		t.Fatalf("expected subscribers to survive a restore")
+	}
+}
+
+func TestSnapshot_RejectsOtherVersionsAndCustomControllers(t *testing.T) {
+	_, err := sim.ReadSnapshot(strings.NewReader(`{"Version": 99, "Width": 5, "Height": 5}`))
+	if !errors.Is(err, sim.ErrSnapshotVersion) {
+		t.Fatalf("expected ErrSnapshotVersion, got %v", err)
+	}
+	if _, err := sim.ReadSnapshot(strings.NewReader(`{"Version": `)); err == nil {
+		t.Fatalf("expected truncated snapshot to be rejected")
+	}
+	e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20, Seed: 1})
+	e.SetController(5, 5, fixedGreen{})
+	if _, err := e.Snapshot(); err == nil {
+		t.Fatalf("expected a custom controller to be refused")
+	}
+}
+
+// fixedGreen is a controller the snapshot format does not know.
+type fixedGreen struct{}
+
+func (fixedGreen) Step(sim.Queues)              {}
+func (fixedGreen) StateFor(sim.Movement) string { return "green" }
+func (fixedGreen) Plan() sim.SignalPlan         { return sim.DefaultSignalPlan() }
+func (fixedGreen) Phase() sim.Phase             { return sim.DefaultSignalPlan().Phases[0] }
+func (fixedGreen) Stage() (string, int)         { return sim.StageGreen, 0 }
+
+func TestSnapshot_RejectsCorruptVehicles(t *testing.T) {
+	e := busyEngine(sim.ControlFixed)
+	for i := 0; i < 20; i++ {
+		e.Step()
+	}
+	for name, corrupt := range map[string]func(*sim.VehicleSnapshot){
+		"planned without a route":  func(v *sim.VehicleSnapshot) { v.Route, v.RouteIdx = nil, -1 },
+		"route index past the end": func(v *sim.VehicleSnapshot) { v.RouteIdx = len(v.Route) },
+		"negative dwell":           func(v *sim.VehicleSnapshot) { v.Dwell = -1 },
+		"progress past the cell":   func(v *sim.VehicleSnapshot) { v.Progress = 1.5 },
+		"braking that never ends":  func(v *sim.VehicleSnapshot) { v.Dynamics.Decel = 1e-12 },
+	} {
+		s, err := e.Snapshot()
+		if err != nil {
+			t.Fatalf("snapshot: %v", err)
+		}
+		v := &s.Vehicles[0]
+		if !v.Planned || len(v.Route) == 0 {
+			t.Fatalf("expected the first vehicle to have a planned route")
+		}
+		v.RouteDest = [2]int{v.DestX, v.DestY}
+		corrupt(v)
+		restored, err := sim.NewEngineFromSnapshot(s)
+		if err == nil {
+			restored.Step()
+			t.Fatalf("%s: expected the snapshot to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package sim_test
+
+import (
+	"testing"
+
+	sim "routeiq/internal/sim"
+)
+
+func TestEngine_TripRecordedOnArrival(t *testing.T) {
+	e := sim.NewEngine(sim.EngineConfig{Width: 20, Height: 20, Seed: 1})
+	// eastbound through (5,5), red for east-west for the first 30 seconds
+	id, _, _ := e.AddVehicle(sim.Vehicle{X: 0, Y: 5, DestX: 10, DestY: 5})
+	for i := 0; i < 60 && e.VehicleCount() > 0; i++ {
+		e.Step()
+	}
+	if e.VehicleCount() != 0 {
+		t.Fatalf("expected the vehicle to be removed on arrival")
+	}
+	trips := e.Trips()
+	if len(trips) != 1 {
+		t.Fatalf("expected one trip, got %d", len(trips))
+	}
+	tr := trips[0]
+	if tr.VehicleID != id || tr.OriginX != 0 || tr.OriginY != 5 || tr.DestX != 10 || tr.DestY != 5 {
+		t.Fatalf("unexpected trip endpoints: %+v", tr)
+	}
+	if tr.Distance != 10 || tr.FreeFlowSeconds != 10 || tr.Stops != 1 {
+		t.Fatalf("expected 10 cells, 10s free flow and one stop at the red light, got %+v", tr)
+	}
+	if tr.TravelSeconds != tr.ArriveTick-tr.DepartTick || tr.DelaySeconds != float64(tr.TravelSeconds)-10 || tr.DelaySeconds < 20 {
+		t.Fatalf("expected delay waiting for green, got %+v", tr)
+	}
+	sum := e.TripSummary()
+	if sum.Completed != 1 || sum.MeanDelay != tr.DelaySeconds || sum.MeanStops != 1 {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+}
@@ -70,6 +70,25 @@ func TestVehicleManager_DespawnEdgeCases(t *testing.T) {
 	}
 }
 
+func TestVehicleManager_SpawnWhereOnlyUsesAllowedCells(t *testing.T) {
+	m := sim.NewVehicleManager()
+	// one open row on a large grid, so blind draws would almost always miss
+	row := func(x, y int) bool { return y == 117 }
+	ids := m.SpawnWhere(200, 200, 200, row)
+	if len(ids) != 200 {
+		t.Fatalf("expected 200 vehicles, got %d", len(ids))
+	}
+	for _, id := range ids {
+		v, _ := m.Get(id)
+		if !row(v.X, v.Y) || !row(v.DestX, v.DestY) {
+			t.Fatalf("expected %s on the open row, got (%d,%d) to (%d,%d)", id, v.X, v.Y, v.DestX, v.DestY)
+		}
+	}
+	if ids := m.SpawnWhere(5, 10, 10, func(x, y int) bool { return false }); len(ids) != 0 || m.Count() != 200 {
+		t.Fatalf("expected no vehicles where no cell is allowed, got %d", len(ids))
+	}
+}
+
 func TestVehicleManager_SpawnZero(t *testing.T) {
 	m := sim.NewVehicleManager()
 	ids := m.Spawn(0, 10, 10)
@@ -77,3 +96,40 @@ func TestVehicleManager_SpawnZero(t *testing.T) {
 		t.Fatalf("spawn(0) should not change state")
 	}
 }
+
+func TestVehicleManager_SeededSpawnIsReproducible(t *testing.T) {
+	a := sim.NewSeededVehicleManager(42)
+	b := sim.NewSeededVehicleManager(42)
+	idsA := a.Spawn(50, 20, 20)
+	idsB := b.Spawn(50, 20, 20)
+	for i := range idsA {
+		if idsA[i] != idsB[i] {
+			t.Fatalf("id %d differs: %s vs %s", i, idsA[i], idsB[i])
+		}
+		va, _ := a.Get(idsA[i])
+		vb, _ := b.Get(idsB[i])
+		if va.X != vb.X || va.Y != vb.Y || va.DestX != vb.DestX || va.DestY != vb.DestY {
+			t.Fatalf("vehicle %d differs: %+v vs %+v", i, va, vb)
+		}
+	}
+	c := sim.NewSeededVehicleManager(43)
+	if c.Spawn(1, 20, 20)[0] == idsA[0] {
+		t.Fatalf("expected different seeds to produce different ids")
+	}
+}
+
+func TestVehicleManager_ListKeepsInsertionOrder(t *testing.T) {
+	m := sim.NewSeededVehicleManager(7)
+	ids := m.Spawn(10, 20, 20)
+	m.Despawn(ids[3], ids[7])
+	list := m.List()
+	want := append(append(append([]string{}, ids[:3]...), ids[4:7]...), ids[8:]...)
+	if len(list) != len(want) {
+		t.Fatalf("expected %d vehicles, got %d", len(want), len(list))
+	}
+	for i, v := range list {
+		if v.ID != want[i] {
+			t.Fatalf("position %d: expected %s, got %s", i, want[i], v.ID)
+		}
+	}
+}
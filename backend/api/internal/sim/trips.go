@@ -0,0 +1,159 @@
+package sim
+
+// tripHistory is how many completed trips the engine keeps; older records
+// still count towards the summary.
+const tripHistory = 10000
+
+// Trip records one completed journey. Times are in simulated seconds.
+type Trip struct {
+	VehicleID        string
+	Type             VehicleType
+	OriginX, OriginY int
+	DestX, DestY     int
+	DepartTick       int64
+	ArriveTick       int64
+	TravelSeconds    int64
+	FreeFlowSeconds  float64 // cheapest route with no congestion, incidents or signals, when the trip started
+	DelaySeconds     float64 // TravelSeconds - FreeFlowSeconds
+	Stops            int     // times the vehicle came to a halt before arriving
+	Distance         int     // cells travelled
+}
+
+// TripSummary aggregates every trip completed so far.
+type TripSummary struct {
+	Completed       int64
+	MeanTravel      float64
+	MeanFreeFlow    float64
+	MeanDelay       float64
+	MeanStops       float64
+	MeanDistance    float64
+	TotalDelay      float64
+	VehicleDistance int64 // cells travelled by all completed trips
+}
+
+// tripTotals are running sums behind TripSummary.
+type tripTotals struct {
+	completed int64
+	travel    int64
+	freeFlow  float64
+	delay     float64
+	stops     int64
+	distance  int64
+}
+
+// tripState tracks a vehicle's journey while it is on the road.
+type tripState struct {
+	started  bool
+	origin   point
+	freeFlow float64
+	distance int
+	stops    int
+	moving   bool // moved on the last tick
+}
+
+// startTripsLocked fixes the origin and free-flow time of vehicles on their first tick.
+func (e *Engine) startTripsLocked(vehicles []*Vehicle) {
+	for _, v := range vehicles {
+		if v.trip.started {
+			continue
+		}
+		v.trip = tripState{started: true, origin: point{v.X, v.Y}}
+		if c, ok := e.pf.FreeFlowCost(v.X, v.Y, v.Heading, v.DestX, v.DestY); ok {
+			v.trip.freeFlow = c
+		}
+	}
+}
+
+// trackTrip updates distance and stops after a tick: crossed is whether the
+// vehicle entered a new cell, moving whether it was under way at all and
+// waiting whether it stood still with somewhere to go.
+func (v *Vehicle) trackTrip(crossed, moving, waiting bool) {
+	if crossed {
+		v.trip.distance++
+	}
+	switch {
+	case moving:
+		v.trip.moving = true
+	case waiting:
+		if v.trip.moving {
+			v.trip.stops++
+		}
+		v.trip.moving = false
+	}
+}
+
+// completeTripsLocked records and removes vehicles that reached their destination.
+func (e *Engine) completeTripsLocked(vehicles []*Vehicle) {
+	var done []string
+	for _, v := range vehicles {
+		if v.X != v.DestX || v.Y != v.DestY {
+			continue
+		}
+		t := Trip{
+			VehicleID:       v.ID,
+			Type:            v.Type,
+			OriginX:         v.trip.origin.X,
+			OriginY:         v.trip.origin.Y,
+			DestX:           v.DestX,
+			DestY:           v.DestY,
+			DepartTick:      v.DepartTick,
+			ArriveTick:      e.ticks,
+			TravelSeconds:   e.ticks - v.DepartTick,
+			FreeFlowSeconds: v.trip.freeFlow,
+			Stops:           v.trip.stops,
+			Distance:        v.trip.distance,
+		}
+		t.DelaySeconds = max(float64(t.TravelSeconds)-t.FreeFlowSeconds, 0)
+		e.recordTripLocked(t)
+		done = append(done, v.ID)
+	}
+	e.vehicles.Despawn(done...)
+}
+
+func (e *Engine) recordTripLocked(t Trip) {
+	if t.Type.Emergency() {
+		e.responses = append(e.responses, EmergencyResponse{
+			VehicleID:       t.VehicleID,
+			DispatchTick:    t.DepartTick,
+			ArrivalTick:     t.ArriveTick,
+			ResponseSeconds: t.TravelSeconds,
+		})
+	}
+	if len(e.trips) == tripHistory {
+		copy(e.trips, e.trips[1:])
+		e.trips = e.trips[:tripHistory-1]
+	}
+	e.trips = append(e.trips, t)
+	tt := &e.tripTotals
+	tt.completed++
+	tt.travel += t.TravelSeconds
+	tt.freeFlow += t.FreeFlowSeconds
+	tt.delay += t.DelaySeconds
+	tt.stops += int64(t.Stops)
+	tt.distance += int64(t.Distance)
+}
+
+// Trips returns the most recent completed trips, oldest first.
+func (e *Engine) Trips() []Trip {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := make([]Trip, len(e.trips))
+	copy(out, e.trips)
+	return out
+}
+
+// TripSummary returns averages over every completed trip.
+func (e *Engine) TripSummary() TripSummary {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	tt := e.tripTotals
+	s := TripSummary{Completed: tt.completed, TotalDelay: tt.delay, VehicleDistance: tt.distance}
+	if n := float64(tt.completed); n > 0 {
+		s.MeanTravel = float64(tt.travel) / n
+		s.MeanFreeFlow = tt.freeFlow / n
+		s.MeanDelay = tt.delay / n
+		s.MeanStops = float64(tt.stops) / n
+		s.MeanDistance = float64(tt.distance) / n
+	}
+	return s
+}
@@ -1,14 +1,117 @@
 package sim
 
-import "time"
+import (
+	"time"
+
+	grid "routeiq/internal/grid"
+)
+
+// VehicleType distinguishes ordinary traffic from priority vehicles.
+type VehicleType string
+
+const (
+	VehicleCar       VehicleType = "car"
+	VehicleEmergency VehicleType = "emergency"
+)
+
+// Emergency reports whether vehicles of this type get right of way and signal preemption.
+func (t VehicleType) Emergency() bool { return t == VehicleEmergency }
 
 // Vehicle represents a simulated vehicle in the grid.
 type Vehicle struct {
-	ID        string
-	X         int
-	Y         int
-	Speed     float64 // cells per second
-	DestX     int
-	DestY     int
-	CreatedAt time.Time
+	ID         string
+	Type       VehicleType // empty means VehicleCar
+	X          int
+	Y          int
+	Speed      float64 // current speed in cells per second
+	Dynamics   Kinematics
+	DestX      int
+	DestY      int
+	CreatedAt  time.Time
+	DepartTick int64          // engine tick at which the trip started
+	Heading    grid.Direction // direction of the last move; NoDirection before the first
+
+	// Planned route and a cursor into it; route[routeIdx] is the current cell.
+	route        []point
+	routeIdx     int
+	routeDest    point
+	routeVersion uint64
+	routeFrom    point // where the plan was searched from
+	planned      bool
+
+	dwell int       // ticks still to spend in the current cell (incident capacity loss)
+	trip  tripState // journey so far, for the trip record
+
+	moved     bool    // advanced on the current tick
+	blocked   int     // consecutive ticks held up by other vehicles
+	arrival   uint64  // when it entered its cell, in Occupancy arrival order
+	queueRank int     // place in its cell's queue, 0 at the front
+	progress  float64 // distance travelled through the current cell towards the next, kinematic vehicles only
+}
+
+// SetDestination changes where the vehicle is heading. The planned route is
+// recomputed on the next tick.
+func (v *Vehicle) SetDestination(x, y int) {
+	v.DestX, v.DestY = x, y
+	v.planned = false
+}
+
+// Route returns the remaining planned route starting at the current cell, or
+// nil if no route has been planned yet.
+func (v *Vehicle) Route() []point {
+	if !v.planned || v.routeIdx >= len(v.route) {
+		return nil
+	}
+	out := make([]point, len(v.route)-v.routeIdx)
+	copy(out, v.route[v.routeIdx:])
+	return out
+}
+
+// ensureRoute replans when there is no plan, the destination changed, the
+// routing topology changed, or the vehicle is no longer where the plan says.
+// A search that found no route is not repeated until the topology changes or
+// the vehicle is moved.
+func (v *Vehicle) ensureRoute(pf *PathFinder, d point) {
+	cur := point{v.X, v.Y}
+	if v.planned && v.routeDest == d && v.routeVersion == pf.Version() {
+		if len(v.route) == 0 && v.routeFrom == cur ||
+			v.routeIdx < len(v.route) && v.route[v.routeIdx] == cur {
+			return
+		}
+	}
+	v.route = pf.PathFrom(v.X, v.Y, v.Heading, d.X, d.Y)
+	v.routeIdx = 0
+	v.routeDest = d
+	v.routeVersion = pf.Version()
+	v.routeFrom = cur
+	v.planned = true
+}
+
+// nextStep returns the next cell on the planned route, if any.
+func (v *Vehicle) nextStep() (point, bool) {
+	if v.routeIdx+1 >= len(v.route) {
+		return point{}, false
+	}
+	return v.route[v.routeIdx+1], true
+}
+
+// nextMovement returns the movement the vehicle makes through its next cell:
+// the heading it enters with and the turn it takes towards the cell after.
+func (v *Vehicle) nextMovement() Movement { return v.movementAt(v.routeIdx + 1) }
+
+// movementAt returns the movement through route[i], for i > 0.
+func (v *Vehicle) movementAt(i int) Movement {
+	m := Movement{Approach: dirBetween(v.route[i-1], v.route[i]), Turn: grid.Straight}
+	if i+1 < len(v.route) {
+		m.Turn = grid.TurnBetween(m.Approach, dirBetween(v.route[i], v.route[i+1]))
+	}
+	return m
+}
+
+// advance moves the vehicle one step along its planned route.
+func (v *Vehicle) advance() {
+	next := v.route[v.routeIdx+1]
+	v.Heading = dirBetween(point{v.X, v.Y}, next)
+	v.routeIdx++
+	v.X, v.Y = next.X, next.Y
 }
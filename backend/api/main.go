@@ -3,19 +3,30 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/rs/cors"
 	"github.com/spf13/viper"
+
+	osm "routeiq/internal/osm"
+	scenario "routeiq/internal/scenario"
+	sim "routeiq/internal/sim"
 )
 
 type server struct {
-	mux *mux.Router
+	mux    *mux.Router
+	engine *sim.Engine
+	geo    atomic.Pointer[osm.GeoRef] // where the grid lies on the map; nil for synthetic grids and restored snapshots
 }
 
 func (s *server) routes() {
@@ -23,7 +34,19 @@ func (s *server) routes() {
 	r.HandleFunc("/healthz", s.handleHealth()).Methods(http.MethodGet)
 	r.HandleFunc("/api/v1/traffic/vehicle", s.handleVehicle()).Methods(http.MethodPost)
 	r.HandleFunc("/api/v1/traffic/incident", s.handleIncident()).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/traffic/incidents", s.handleIncidents()).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/traffic/profile", s.handleProfile()).Methods(http.MethodGet)
 	r.HandleFunc("/api/v1/routes/optimal", s.handleOptimalRoute()).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/routes/matrix", s.handleRouteMatrix()).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/simulation/state", s.handleSimState()).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/simulation/trips", s.handleTrips()).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/simulation/map", s.handleMap()).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/simulation/{action:start|pause|step}", s.handleSimControl()).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/simulation/snapshot", s.handleGetSnapshot()).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/simulation/snapshot", s.handleRestoreSnapshot()).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/signals/coordination", s.handleGetCoordination()).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/signals/coordination", s.handleCoordinate()).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/emergency/responses", s.handleEmergencyResponses()).Methods(http.MethodGet)
     r.HandleFunc("/ws", s.handleWS())
 }
 
@@ -34,24 +57,718 @@ func (s *server) handleHealth() http.HandlerFunc {
 	}
 }
 
+type vehicleEvent struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	Position    xy        `json:"position"`
+	Speed       float64   `json:"speed"`
+	Destination xy        `json:"destination"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// handleVehicle upserts a vehicle into the running simulation.
 func (s *server) handleVehicle() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		var ev vehicleEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_payload", err.Error(), nil)
+			return
+		}
+		vt := sim.VehicleType(ev.Type)
+		switch vt {
+		case "":
+			vt = sim.VehicleCar
+		case sim.VehicleCar, sim.VehicleEmergency:
+		default:
+			writeError(w, http.StatusBadRequest, "invalid_payload", "unknown vehicle type",
+				map[string]any{"type": ev.Type})
+			return
+		}
+		added, err := s.engine.UpsertVehicle(sim.Vehicle{
+			ID: ev.ID, Type: vt,
+			X: ev.Position.X, Y: ev.Position.Y,
+			Speed: ev.Speed,
+			DestX: ev.Destination.X, DestY: ev.Destination.Y,
+		})
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_payload", err.Error(), nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusAccepted)
-		json.NewEncoder(w).Encode(map[string]string{"message": "vehicle event accepted"})
+		json.NewEncoder(w).Encode(map[string]any{"message": "vehicle event accepted", "created": added})
+	}
+}
+
+// handleEmergencyResponses reports response times of emergency vehicles that
+// have reached their destination.
+func (s *server) handleEmergencyResponses() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sum := s.engine.EmergencyResponses()
+		out := make([]map[string]any, 0, len(sum.Responses))
+		for _, resp := range sum.Responses {
+			out = append(out, map[string]any{
+				"vehicle_id":       resp.VehicleID,
+				"dispatch_tick":    resp.DispatchTick,
+				"arrival_tick":     resp.ArrivalTick,
+				"response_seconds": resp.ResponseSeconds,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"count":                 len(out),
+			"mean_response_seconds": sum.MeanSeconds,
+			"max_response_seconds":  sum.MaxSeconds,
+			"responses":             out,
+		})
 	}
 }
 
+type incidentPayload struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Position  xy        `json:"position"`
+	Severity  int       `json:"severity"`
+	Timestamp time.Time `json:"timestamp"`
+	Resolved  bool      `json:"resolved"`
+	Generated bool      `json:"generated,omitempty"`
+}
+
+func incidentJSON(inc sim.Incident) incidentPayload {
+	return incidentPayload{
+		ID:        inc.ID,
+		Type:      string(inc.Type),
+		Position:  xy{inc.X, inc.Y},
+		Severity:  inc.Severity,
+		Timestamp: inc.Timestamp,
+		Resolved:  inc.Resolved,
+		Generated: inc.Generated,
+	}
+}
+
+// handleIncident records or updates an incident in the simulation, which
+// reroutes traffic around it.
 func (s *server) handleIncident() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		var p incidentPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_payload", err.Error(), nil)
+			return
+		}
+		inc, created, err := s.engine.ReportIncident(sim.Incident{
+			ID:        p.ID,
+			Type:      sim.IncidentType(p.Type),
+			X:         p.Position.X,
+			Y:         p.Position.Y,
+			Severity:  p.Severity,
+			Timestamp: p.Timestamp,
+			Resolved:  p.Resolved,
+		})
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_payload", err.Error(), nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusAccepted)
-		json.NewEncoder(w).Encode(map[string]string{"message": "incident accepted"})
+		json.NewEncoder(w).Encode(map[string]any{"incident": incidentJSON(inc), "created": created})
+	}
+}
+
+// handleIncidents lists active incidents, or all of them with ?all=true.
+func (s *server) handleIncidents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		incidents := s.engine.Incidents(r.URL.Query().Get("all") == "true")
+		out := make([]incidentPayload, 0, len(incidents))
+		for _, inc := range incidents {
+			out = append(out, incidentJSON(inc))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"incidents": out})
+	}
+}
+
+// handleProfile reports the predicted congestion of cell ?x=&y= by hour.
+func (s *server) handleProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		x, errX := strconv.Atoi(r.URL.Query().Get("x"))
+		y, errY := strconv.Atoi(r.URL.Query().Get("y"))
+		if errX != nil || errY != nil {
+			writeError(w, http.StatusBadRequest, "invalid_payload", "x and y must be integers", nil)
+			return
+		}
+		profile, ticks, ok := s.engine.CongestionProfile(x, y)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid_payload", "cell is outside the grid",
+				map[string]any{"position": xy{x, y}})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"position":       xy{x, y},
+			"multipliers":    profile,
+			"observed_ticks": ticks,
+			"time_of_day":    formatClock(s.engine.TimeOfDay()),
+		})
+	}
+}
+
+type routeRequest struct {
+	Origin       *xy    `json:"origin"`
+	Destination  *xy    `json:"destination"`
+	Alternatives int    `json:"alternatives"`
+	DepartAt     string `json:"depart_at"`
+	ArriveBy     string `json:"arrive_by"`
+	Preferences  struct {
+		AvoidIncidents bool    `json:"avoid_incidents"`
+		Weight         float64 `json:"weight"`
+		IgnoreSignals  bool    `json:"ignore_signals"`
+	} `json:"preferences"`
+}
+
+type routePayload struct {
+	Path              []xy    `json:"path"`
+	Distance          float64 `json:"distance"`
+	ETASeconds        float64 `json:"eta_seconds"`
+	SignalWaitSeconds float64 `json:"signal_wait_seconds"`
+	DepartAt          string  `json:"depart_at"`
+	ArriveAt          string  `json:"arrive_at"`
+}
+
+func routeJSON(r sim.Route) routePayload {
+	path := make([]xy, len(r.Path))
+	for i, c := range r.Path {
+		path[i] = xy{c[0], c[1]}
+	}
+	return routePayload{
+		Path: path, Distance: float64(r.Distance), ETASeconds: r.ETASeconds, SignalWaitSeconds: r.WaitSeconds,
+		DepartAt: formatClock(r.Depart), ArriveAt: formatClock(r.Arrive),
 	}
 }
 
+type alternativePayload struct {
+	routePayload
+	TrafficMultipliers []float64   `json:"traffic_multipliers"`
+	SignalWaits        []float64   `json:"signal_waits"`
+	Differences        differences `json:"differences"`
+}
+
+type differences struct {
+	ExtraSeconds  float64 `json:"extra_seconds"`
+	ExtraDistance int     `json:"extra_distance"`
+	Shared        float64 `json:"shared"`
+	DivergeAt     xy      `json:"diverge_at"`
+	RejoinAt      xy      `json:"rejoin_at"`
+	Summary       string  `json:"summary"`
+}
+
+// handleOptimalRoute plans a route through the live simulation.
 func (s *server) handleOptimalRoute() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusNotImplemented)
-		json.NewEncoder(w).Encode(map[string]string{"error": "route optimization not implemented"})
+		started := time.Now()
+		var req routeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_payload", err.Error(), nil)
+			return
+		}
+		if req.Origin == nil || req.Destination == nil {
+			writeError(w, http.StatusBadRequest, "invalid_payload", "origin and destination are required", nil)
+			return
+		}
+		opts := sim.RouteOptions{
+			AvoidIncidents: req.Preferences.AvoidIncidents,
+			Weight:         req.Preferences.Weight,
+			IgnoreSignals:  req.Preferences.IgnoreSignals,
+		}
+		for _, t := range []struct {
+			field, value string
+			into         **time.Duration
+		}{{"depart_at", req.DepartAt, &opts.DepartAt}, {"arrive_by", req.ArriveBy, &opts.ArriveBy}} {
+			if t.value == "" {
+				continue
+			}
+			d, err := parseClock(t.value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid_payload", err.Error(), map[string]any{"field": t.field})
+				return
+			}
+			*t.into = &d
+		}
+		route, alts, err := s.engine.Alternatives(req.Origin.X, req.Origin.Y, req.Destination.X, req.Destination.Y, opts, req.Alternatives)
+		var perr *sim.PointError
+		switch {
+		case errors.As(err, &perr):
+			details := map[string]any{"field": perr.Field, "position": xy{perr.X, perr.Y}}
+			if perr.Outside {
+				writeError(w, http.StatusBadRequest, "invalid_payload", err.Error(), details)
+			} else {
+				writeError(w, http.StatusUnprocessableEntity, "blocked_point", err.Error(), details)
+			}
+			return
+		case errors.Is(err, sim.ErrTooLate):
+			writeError(w, http.StatusUnprocessableEntity, "too_late", err.Error(), map[string]any{
+				"arrive_by": req.ArriveBy, "time_of_day": formatClock(s.engine.TimeOfDay()),
+			})
+			return
+		case errors.Is(err, sim.ErrNoRoute):
+			writeError(w, http.StatusUnprocessableEntity, "no_route", err.Error(), map[string]any{
+				"origin": req.Origin, "destination": req.Destination,
+				"avoid_incidents": req.Preferences.AvoidIncidents,
+			})
+			return
+		case err != nil:
+			writeError(w, http.StatusBadRequest, "invalid_payload", err.Error(), nil)
+			return
+		}
+		resp := map[string]any{"route": routeJSON(route)}
+		if req.Alternatives > 0 {
+			out := make([]alternativePayload, 0, len(alts))
+			for _, a := range alts {
+				d := a.Differences
+				out = append(out, alternativePayload{
+					routePayload:       routeJSON(a.Route),
+					TrafficMultipliers: a.Multipliers,
+					SignalWaits:        a.SignalWaits,
+					Differences: differences{
+						ExtraSeconds:  d.ExtraSeconds,
+						ExtraDistance: d.ExtraDistance,
+						Shared:        d.Shared,
+						DivergeAt:     xy{d.DivergeAt[0], d.DivergeAt[1]},
+						RejoinAt:      xy{d.RejoinAt[0], d.RejoinAt[1]},
+						Summary:       d.Summary,
+					},
+				})
+			}
+			resp["alternatives"] = out
+		}
+		resp["metadata"] = map[string]any{
+			"computed_ms":         float64(time.Since(started).Microseconds()) / 1000,
+			"traffic_multipliers": route.Multipliers,
+			"signal_waits":        route.SignalWaits,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+type matrixRequest struct {
+	Origins      []xy   `json:"origins"`
+	Destinations []xy   `json:"destinations"`
+	DepartAt     string `json:"depart_at"`
+	Preferences  struct {
+		AvoidIncidents bool `json:"avoid_incidents"`
+		IgnoreSignals  bool `json:"ignore_signals"`
+	} `json:"preferences"`
+}
+
+// handleRouteMatrix returns travel times and distances between many origins
+// and destinations at once.
+func (s *server) handleRouteMatrix() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		var req matrixRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_payload", err.Error(), nil)
+			return
+		}
+		opts := sim.RouteOptions{
+			AvoidIncidents: req.Preferences.AvoidIncidents,
+			IgnoreSignals:  req.Preferences.IgnoreSignals,
+		}
+		if req.DepartAt != "" {
+			d, err := parseClock(req.DepartAt)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid_payload", err.Error(), map[string]any{"field": "depart_at"})
+				return
+			}
+			opts.DepartAt = &d
+		}
+		cells := func(pts []xy) [][2]int {
+			out := make([][2]int, len(pts))
+			for i, p := range pts {
+				out[i] = [2]int{p.X, p.Y}
+			}
+			return out
+		}
+		m, err := s.engine.Matrix(cells(req.Origins), cells(req.Destinations), opts)
+		var perr *sim.PointError
+		switch {
+		case errors.As(err, &perr):
+			details := map[string]any{"field": perr.Field, "position": xy{perr.X, perr.Y}}
+			if perr.Outside {
+				writeError(w, http.StatusBadRequest, "invalid_payload", err.Error(), details)
+			} else {
+				writeError(w, http.StatusUnprocessableEntity, "blocked_point", err.Error(), details)
+			}
+			return
+		case errors.Is(err, sim.ErrMatrixTooLarge):
+			writeError(w, http.StatusBadRequest, "matrix_too_large", err.Error(), map[string]any{
+				"max_points": sim.MaxMatrixPoints, "max_entries": sim.MaxMatrixEntries, "max_work": sim.MaxMatrixWork,
+			})
+			return
+		case err != nil:
+			writeError(w, http.StatusBadRequest, "invalid_payload", err.Error(), nil)
+			return
+		}
+		// unreachable pairs are null
+		durations := make([][]*float64, len(m.Durations))
+		distances := make([][]*int, len(m.Distances))
+		for i := range m.Durations {
+			durations[i] = make([]*float64, len(m.Durations[i]))
+			distances[i] = make([]*int, len(m.Distances[i]))
+			for j := range m.Durations[i] {
+				if m.Distances[i][j] >= 0 {
+					durations[i][j], distances[i][j] = &m.Durations[i][j], &m.Distances[i][j]
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"durations": durations,
+			"distances": distances,
+			"depart_at": formatClock(m.Depart),
+			"metadata": map[string]any{
+				"computed_ms":  float64(time.Since(started).Microseconds()) / 1000,
+				"origins":      len(req.Origins),
+				"destinations": len(req.Destinations),
+			},
+		})
+	}
+}
+
+type simVehicle struct {
+	ID          string  `json:"id"`
+	Type        string  `json:"type"`
+	Position    xy      `json:"position"`
+	Speed       float64 `json:"speed"`
+	Destination xy      `json:"destination"`
+}
+
+type simLight struct {
+	Position   xy                `json:"position"`
+	Phase      string            `json:"phase"`
+	Stage      string            `json:"stage"`
+	Approaches map[string]string `json:"approaches"`
+	Preempted  bool              `json:"preempted"`
+}
+
+type simQueue struct {
+	Position xy   `json:"position"`
+	Vehicles int  `json:"vehicles"`
+	Waiting  int  `json:"waiting"`
+	Capacity int  `json:"capacity"`
+	Lanes    int  `json:"lanes"`
+	Full     bool `json:"full"`
+}
+
+type xy struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+func (s *server) handleSimState() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vehicles := s.engine.Vehicles()
+		outV := make([]simVehicle, 0, len(vehicles))
+		for _, v := range vehicles {
+			outV = append(outV, simVehicle{
+				ID:          v.ID,
+				Type:        string(v.Type),
+				Position:    xy{v.X, v.Y},
+				Speed:       v.Speed,
+				Destination: xy{v.DestX, v.DestY},
+			})
+		}
+		signals := s.engine.Signals()
+		outL := make([]simLight, 0, len(signals))
+		for _, sig := range signals {
+			approaches := make(map[string]string, len(sig.Approaches))
+			for d, st := range sig.Approaches {
+				approaches[d.String()] = st
+			}
+			outL = append(outL, simLight{Position: xy{sig.X, sig.Y}, Phase: sig.Phase, Stage: sig.Stage, Approaches: approaches, Preempted: sig.Preempted})
+		}
+		queues := s.engine.Queues()
+		outQ := make([]simQueue, 0, len(queues))
+		for _, q := range queues {
+			outQ = append(outQ, simQueue{
+				Position: xy{q.X, q.Y},
+				Vehicles: q.Vehicles,
+				Waiting:  q.Waiting,
+				Capacity: q.Capacity.Vehicles(),
+				Lanes:    q.Capacity.Lanes,
+				Full:     q.Full,
+			})
+		}
+		st := s.engine.Stats()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"tick":        s.engine.Ticks(),
+			"time_of_day": formatClock(s.engine.TimeOfDay()),
+			"running":     s.engine.Running(),
+			"vehicles":    outV,
+			"lights":      outL,
+			"queues":      outQ,
+			"stats": map[string]any{
+				"vehicles":   st.Vehicles,
+				"moving":     st.Moving,
+				"waiting":    st.Waiting,
+				"mean_speed": st.MeanSpeed,
+				"wait_ticks": st.WaitTicks,
+				"departures": st.Departures,
+				"completed":  s.engine.TripSummary().Completed,
+				"blocked":    st.Blocked,
+				"gridlock": map[string]any{
+					"cycles":   st.GridlockCycles,
+					"vehicles": st.GridlockVehicles,
+					"reroutes": st.Reroutes,
+				},
+				"unserved_trips": st.UnservedTrips,
+			},
+		})
+	}
+}
+
+// handleMap reports the grid size and, for an imported map, its
+// geographic reference.
+func (s *server) handleMap() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g := s.engine.Grid()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"width":     g.Width,
+			"height":    g.Height,
+			"reference": s.geo.Load(),
+		})
+	}
+}
+
+type tripRecord struct {
+	VehicleID   string  `json:"vehicle_id"`
+	Type        string  `json:"type"`
+	Origin      xy      `json:"origin"`
+	Destination xy      `json:"destination"`
+	DepartTick  int64   `json:"depart_tick"`
+	ArriveTick  int64   `json:"arrive_tick"`
+	Travel      int64   `json:"travel_seconds"`
+	FreeFlow    float64 `json:"free_flow_seconds"`
+	Delay       float64 `json:"delay_seconds"`
+	Stops       int     `json:"stops"`
+	Distance    int     `json:"distance"`
+}
+
+// handleTrips reports completed trips: a summary over all of them and the
+// most recent records, newest last (?limit=N, default 100).
+func (s *server) handleTrips() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if q := r.URL.Query().Get("limit"); q != "" {
+			n, err := strconv.Atoi(q)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, "invalid_payload", "limit must be a non-negative integer", map[string]any{"limit": q})
+				return
+			}
+			limit = n
+		}
+		trips := s.engine.Trips()
+		trips = trips[max(len(trips)-limit, 0):]
+		out := make([]tripRecord, 0, len(trips))
+		for _, t := range trips {
+			out = append(out, tripRecord{
+				VehicleID:   t.VehicleID,
+				Type:        string(t.Type),
+				Origin:      xy{t.OriginX, t.OriginY},
+				Destination: xy{t.DestX, t.DestY},
+				DepartTick:  t.DepartTick,
+				ArriveTick:  t.ArriveTick,
+				Travel:      t.TravelSeconds,
+				FreeFlow:    t.FreeFlowSeconds,
+				Delay:       t.DelaySeconds,
+				Stops:       t.Stops,
+				Distance:    t.Distance,
+			})
+		}
+		sum := s.engine.TripSummary()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"summary": map[string]any{
+				"completed":              sum.Completed,
+				"mean_travel_seconds":    sum.MeanTravel,
+				"mean_free_flow_seconds": sum.MeanFreeFlow,
+				"mean_delay_seconds":     sum.MeanDelay,
+				"total_delay_seconds":    sum.TotalDelay,
+				"mean_stops":             sum.MeanStops,
+				"mean_distance":          sum.MeanDistance,
+			},
+			"trips": out,
+		})
+	}
+}
+
+// parseClock parses a time of day given as HH:MM or HH:MM:SS.
+func parseClock(s string) (time.Duration, error) {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+				time.Duration(t.Second())*time.Second, nil
+		}
+	}
+	return 0, fmt.Errorf("time of day %q is not HH:MM or HH:MM:SS", s)
+}
+
+// formatClock formats a time of day as HH:MM:SS.
+func formatClock(d time.Duration) string {
+	sec := int(d / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", sec/3600, sec/60%60, sec%60)
+}
+
+func (s *server) handleSimControl() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch mux.Vars(r)["action"] {
+		case "start":
+			s.engine.Start()
+		case "pause":
+			s.engine.Pause()
+		case "step":
+			s.engine.Step()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"tick": s.engine.Ticks(), "running": s.engine.Running()})
+	}
+}
+
+// maxSnapshotBytes bounds the size of an uploaded snapshot.
+const maxSnapshotBytes = 64 << 20
+
+// handleGetSnapshot downloads the simulation's complete state.
+func (s *server) handleGetSnapshot() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap, err := s.engine.Snapshot()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "snapshot_failed", err.Error(), nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="routeiq-snapshot-%d.json"`, snap.Tick))
+		sim.WriteSnapshot(w, snap)
+	}
+}
+
+// handleRestoreSnapshot replaces the simulation's state with an uploaded
+// snapshot. A running simulation keeps running from the restored tick.
+func (s *server) handleRestoreSnapshot() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap, err := sim.ReadSnapshot(http.MaxBytesReader(w, r.Body, maxSnapshotBytes))
+		if errors.Is(err, sim.ErrSnapshotVersion) {
+			writeError(w, http.StatusUnprocessableEntity, "unsupported_version", err.Error(),
+				map[string]any{"supported": sim.SnapshotVersion})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_snapshot", err.Error(), nil)
+			return
+		}
+		if err := s.engine.Restore(snap); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "invalid_snapshot", err.Error(), nil)
+			return
+		}
+		s.geo.Store(nil) // snapshots do not record where their grid lies
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"tick":     s.engine.Ticks(),
+			"running":  s.engine.Running(),
+			"vehicles": s.engine.VehicleCount(),
+		})
+	}
+}
+
+// writeError writes the documented error envelope.
+func writeError(w http.ResponseWriter, status int, code, message string, details map[string]any) {
+	if details == nil {
+		details = map[string]any{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"code": code, "message": message, "details": details},
+	})
+}
+
+type coordinationRequest struct {
+	Intersections []xy    `json:"intersections"`
+	Speed         float64 `json:"speed"`
+}
+
+type coordinatedSignal struct {
+	Position   xy             `json:"position"`
+	TravelTime float64        `json:"travel_time_seconds"`
+	Offset     int            `json:"offset_seconds"`
+	Splits     map[string]int `json:"green_seconds"`
+}
+
+func coordinationResponse(cp sim.CoordinationPlan) map[string]any {
+	signals := make([]coordinatedSignal, 0, len(cp.Signals))
+	for _, sig := range cp.Signals {
+		splits := make(map[string]int, len(sig.Plan.Phases))
+		for _, ph := range sig.Plan.Phases {
+			splits[ph.Name] = ph.GreenSec
+		}
+		signals = append(signals, coordinatedSignal{
+			Position:   xy{sig.X, sig.Y},
+			TravelTime: sig.TravelTime,
+			Offset:     sig.Offset,
+			Splits:     splits,
+		})
+	}
+	return map[string]any{
+		"direction":     cp.Direction.String(),
+		"phase":         cp.Phase,
+		"cycle_seconds": cp.Cycle,
+		"signals":       signals,
+	}
+}
+
+func (s *server) handleGetCoordination() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cp, ok := s.engine.Coordination()
+		if !ok {
+			writeError(w, http.StatusNotFound, "not_found", "no coordination plan applied", nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(coordinationResponse(cp))
+	}
+}
+
+// handleCoordinate optimises a green wave for the given corridor from the
+// flows observed so far and applies it to the running signals.
+func (s *server) handleCoordinate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req coordinationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_payload", err.Error(), nil)
+			return
+		}
+		if req.Speed == 0 {
+			req.Speed = 1
+		}
+		corridor := sim.Corridor{Speed: req.Speed, Flows: make(map[[2]int][]float64)}
+		for _, p := range req.Intersections {
+			k := [2]int{p.X, p.Y}
+			corridor.Intersections = append(corridor.Intersections, k)
+			corridor.Flows[k] = s.engine.PhaseFlows(p.X, p.Y)
+		}
+		cp, err := sim.OptimizeCorridor(corridor)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_corridor", err.Error(), nil)
+			return
+		}
+		if err := s.engine.ApplyCoordination(cp); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "invalid_corridor", err.Error(), nil)
+			return
+		}
+		s.engine.ResetFlows()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(coordinationResponse(cp))
 	}
 }
 
@@ -61,6 +778,22 @@ var upgrader = websocket.Upgrader{
     CheckOrigin: func(r *http.Request) bool { return true },
 }
 
+// wsMessage is the envelope for realtime updates.
+type wsMessage struct {
+    Type      string    `json:"type"`
+    Timestamp time.Time `json:"timestamp"`
+    Data      any       `json:"data"`
+}
+
+func eventMessage(ev sim.Event) wsMessage {
+    data := ev.Data
+    if inc, ok := data.(sim.Incident); ok {
+        data = incidentJSON(inc)
+    }
+    return wsMessage{Type: ev.Type, Timestamp: ev.Timestamp, Data: data}
+}
+
+// handleWS streams engine events to the client as they are published.
 func (s *server) handleWS() http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         conn, err := upgrader.Upgrade(w, r, nil)
@@ -69,6 +802,20 @@ func (s *server) handleWS() http.HandlerFunc {
             return
         }
         defer conn.Close()
+        events, cancel := s.engine.Subscribe(64)
+        defer cancel()
+        var writeMu sync.Mutex // gorilla connections allow one concurrent writer
+        go func() {
+            for ev := range events {
+                writeMu.Lock()
+                err := conn.WriteJSON(eventMessage(ev))
+                writeMu.Unlock()
+                if err != nil {
+                    conn.Close()
+                    return
+                }
+            }
+        }()
         // Simple heartbeat loop
         for {
             _, msg, err := conn.ReadMessage()
@@ -76,7 +823,9 @@ func (s *server) handleWS() http.HandlerFunc {
                 break
             }
             // Echo
+            writeMu.Lock()
             _ = conn.WriteMessage(websocket.TextMessage, append([]byte("ack:"), msg...))
+            writeMu.Unlock()
         }
     }
 }
@@ -88,6 +837,22 @@ func initConfig() {
 	viper.SetDefault("READ_TIMEOUT_SEC", 15)
 	viper.SetDefault("WRITE_TIMEOUT_SEC", 15)
 	viper.SetDefault("IDLE_TIMEOUT_SEC", 60)
+	viper.SetDefault("GRID_WIDTH", 20)
+	viper.SetDefault("GRID_HEIGHT", 20)
+	viper.SetDefault("SIM_VEHICLES", 100)
+	viper.SetDefault("SIM_TICK_MS", 1000)
+	viper.SetDefault("SIM_SEED", 0)
+	viper.SetDefault("SIGNAL_CONTROL", sim.ControlFixed)
+	viper.SetDefault("SIM_START_TIME", "08:00")
+	viper.SetDefault("SIM_INCIDENTS", false)
+	viper.SetDefault("SIM_DEMAND_TRIPS_PER_HOUR", 0)
+	viper.SetDefault("SIM_LANES", 1)
+	viper.SetDefault("SIM_LANE_STORAGE", 1)
+	viper.SetDefault("SIM_KINEMATICS", false)
+	viper.SetDefault("SIM_SCENARIO", "")
+	viper.SetDefault("SCENARIO_DIR", "scenarios")
+	viper.SetDefault("SIM_OSM_FILE", "")
+	viper.SetDefault("SIM_OSM_CELL_METERS", osm.DefaultCellSize)
 }
 
 func main() {
@@ -98,7 +863,87 @@ func main() {
 		addr = ":8080"
 	}
 
-	s := &server{mux: mux.NewRouter()}
+	control := viper.GetString("SIGNAL_CONTROL")
+	if _, err := sim.NewController(control, sim.SignalPlan{}); err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+	start, err := time.Parse("15:04", viper.GetString("SIM_START_TIME"))
+	if err != nil {
+		log.Fatalf("config error: SIM_START_TIME: %v", err)
+	}
+	var incidents *sim.IncidentGeneratorConfig
+	if viper.GetBool("SIM_INCIDENTS") {
+		cfg := sim.DefaultIncidentGeneratorConfig()
+		incidents = &cfg
+	}
+	var kinematics *sim.Kinematics
+	if viper.GetBool("SIM_KINEMATICS") {
+		k := sim.DefaultKinematics()
+		kinematics = &k
+	}
+	width, height := viper.GetInt("GRID_WIDTH"), viper.GetInt("GRID_HEIGHT")
+	var network *osm.Network
+	if path := viper.GetString("SIM_OSM_FILE"); path != "" {
+		if viper.GetString("SIM_SCENARIO") != "" {
+			log.Fatalf("config error: set SIM_SCENARIO or SIM_OSM_FILE, not both")
+		}
+		network, err = osm.Load(path, osm.Options{CellSize: viper.GetFloat64("SIM_OSM_CELL_METERS")})
+		if err != nil {
+			log.Fatalf("config error: SIM_OSM_FILE: %v", err)
+		}
+		width, height = network.Width, network.Height
+		log.Printf("imported %d roads from %s onto a %dx%d grid with %d signals", network.Ways, path, width, height, len(network.Signals))
+	}
+	var demand *sim.DemandModel
+	if trips := viper.GetFloat64("SIM_DEMAND_TRIPS_PER_HOUR"); trips > 0 {
+		m := sim.CommuterDemand(width, height, trips)
+		demand = &m
+	}
+	cfg := sim.EngineConfig{
+		Width:        width,
+		Height:       height,
+		Vehicles:     viper.GetInt("SIM_VEHICLES"),
+		TickInterval: time.Duration(viper.GetInt("SIM_TICK_MS")) * time.Millisecond,
+		Seed:         viper.GetUint64("SIM_SEED"),
+		Control:      control,
+		StartTime:    time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute,
+		Incidents:    incidents,
+		Demand:       demand,
+		DefaultCapacity: sim.CellCapacity{
+			Lanes:   viper.GetInt("SIM_LANES"),
+			Storage: viper.GetInt("SIM_LANE_STORAGE"),
+		},
+		Kinematics: kinematics,
+	}
+	var geo *osm.GeoRef
+	if network != nil {
+		nc := network.EngineConfig()
+		cfg.Blocked, cfg.ClosedEdges, cfg.SpeedLimits, cfg.Intersections = nc.Blocked, nc.ClosedEdges, nc.SpeedLimits, nc.Intersections
+		geo = &network.Ref
+	}
+	if name := viper.GetString("SIM_SCENARIO"); name != "" {
+		sc, err := scenario.Load(name, viper.GetString("SCENARIO_DIR"))
+		if err != nil {
+			log.Fatalf("config error: SIM_SCENARIO: %v", err)
+		}
+		scfg, err := sc.EngineConfig()
+		if err != nil {
+			log.Fatalf("config error: SIM_SCENARIO: %v", err)
+		}
+		scfg.TickInterval = cfg.TickInterval
+		if scfg.Seed == 0 {
+			scfg.Seed = cfg.Seed
+		}
+		cfg = scfg
+		log.Printf("loaded scenario %s", sc.Name)
+	}
+	engine := sim.NewEngine(cfg)
+	log.Printf("simulation seed %d", engine.Seed())
+	engine.Start()
+	defer engine.Stop()
+
+	s := &server{mux: mux.NewRouter(), engine: engine}
+	s.geo.Store(geo)
 	s.routes()
 
 	c := cors.New(cors.Options{